@@ -95,10 +95,20 @@ func main() {
 			return httpReader(tablePath)
 		}
 
+		// Mapped catalogs take their filenode from pg_filenode.map
+		var relMaps []*pgdump.RelMapFile
+		for _, path := range []string{filepath.Join(baseDir, "pg_filenode.map"), filepath.Join(pgDataDir, "global", "pg_filenode.map")} {
+			if data, err := httpReader(path); err == nil {
+				if rm, err := pgdump.ParseRelMapFile(data); err == nil {
+					relMaps = append(relMaps, rm)
+				}
+			}
+		}
+
 		// Step 4: Dump the database using pgdump library
 		dbDump, err := pgdump.DumpDatabaseFromFiles(pgClassData, pgAttrData, tableReader, &pgdump.Options{
 			SkipSystemTables: true,
-		})
+		}, relMaps...)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "[-] Failed to dump %s: %v\n", db.Name, err)
 			continue
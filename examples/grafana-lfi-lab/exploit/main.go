@@ -14,7 +14,7 @@ import (
 func main() {
 	if len(os.Args) < 2 {
 		fmt.Println("usage: exploit <url> [--json] [command] [args...]")
-		fmt.Println("commands: summary, creds, dbs, tables <db>, columns <db> <table>, query <db> <table>, dump [db]")
+		fmt.Println("commands: summary, creds, dbs, tablespaces, tables <db>, columns <db> <table>, query <db> <table>, dump [db]")
 		os.Exit(1)
 	}
 
@@ -4,10 +4,12 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Chocapikk/pgread/pgdump"
 )
@@ -16,22 +18,32 @@ import (
 func main() {
 	var (
 		dataDir, singleFile, dbFilter, tableFilter string
+		schemaFilter                               string
 		listOnly, verbose, showVersion             bool
 		detectPaths, listDBs, debug                bool
 		sqlOutput, csvOutput                       bool
 		searchPattern, passwords, secrets          string
-		showDeleted, showWAL                       bool
+		showDeleted, showWAL, walChanges           bool
 		showControl, verifyChecksums               bool
 		parseIndex, showDropped                    bool
 		showSequences, showRelmap, blockRange      string
+		visibility                                 string
 		binaryDump, skipOldValues, toastVerbose    bool
 		segmentNumber, segmentSize                 int
+		asOfXID                                    uint
+		systemColumns, showHistory, deadItems      bool
+		carve, showOrphans, droppedTables          bool
+		schemaHistory, xminSchema, inferSchema     bool
+		showForks                                  bool
+		walFPI, fpiDir                             string
+		redoLSN, redoTime, redoWAL                 string
 	)
 
 	flag.StringVar(&dataDir, "d", "", "PostgreSQL data directory (auto-detected if not set)")
 	flag.StringVar(&singleFile, "f", "", "Single heap file to parse")
 	flag.StringVar(&dbFilter, "db", "", "Filter by database name")
 	flag.StringVar(&tableFilter, "t", "", "Filter tables containing string")
+	flag.StringVar(&schemaFilter, "schema", "", "Filter by schema name")
 	flag.BoolVar(&listOnly, "list", false, "List schema only, no data")
 	flag.BoolVar(&listDBs, "list-db", false, "List databases only")
 	flag.BoolVar(&detectPaths, "detect", false, "Show detected PostgreSQL paths")
@@ -41,14 +53,32 @@ func main() {
 	flag.StringVar(&passwords, "passwords", "", "Extract password hashes (use 'all' or specify user)")
 	flag.StringVar(&secrets, "secrets", "", "Search for secrets/credentials (use 'auto' for common patterns)")
 	flag.BoolVar(&showDeleted, "deleted", false, "Include deleted (non-vacuumed) rows")
+	flag.StringVar(&visibility, "visibility", "auto", "Tuple visibility: auto, clog (pg_xact) or hints (hint bits only)")
+	flag.UintVar(&asOfXID, "as-of-xid", 0, "Show rows as they were right after this transaction ID")
+	flag.BoolVar(&systemColumns, "system-columns", false, "Add ctid, xmin, xmax, cmin, cmax, t_infomask and t_ctid to rows")
+	flag.BoolVar(&deadItems, "dead-items", false, "Also decode tuples behind LP_DEAD/LP_UNUSED line pointers (with -deleted or -history)")
+	flag.BoolVar(&carve, "carve", false, "Carve tuples from page free space and damaged pages (JSON)")
+	flag.BoolVar(&showHistory, "history", false, "Show row version history (update chains) instead of rows (JSON)")
+	flag.BoolVar(&schemaHistory, "schema-history", false, "Show table definitions over time from dead catalog rows (with -db)")
+	flag.BoolVar(&xminSchema, "xmin-schema", false, "Decode each row with the table definition current at its xmin")
 	flag.BoolVar(&showWAL, "wal", false, "Show WAL (Write-Ahead Log) summary")
+	flag.BoolVar(&walChanges, "wal-changes", false, "Decode row inserts, updates and deletes from heap WAL records (JSON lines)")
+	flag.StringVar(&walFPI, "wal-fpi", "", "List full-page images in WAL ('all' or relation filenode, blocks with -R)")
+	flag.StringVar(&fpiDir, "fpi-dir", "", "Write the pages of -wal-fpi to this directory")
+	flag.StringVar(&redoLSN, "redo-lsn", "", "Replay heap WAL onto the tables up to this LSN first (e.g. 0/3000148)")
+	flag.StringVar(&redoTime, "redo-time", "", "Replay heap WAL up to this commit time (RFC 3339 or '2006-01-02 15:04:05')")
+	flag.StringVar(&redoWAL, "redo-wal", "", "WAL directory for -redo-lsn/-redo-time (default: pg_wal of the data directory)")
 	flag.BoolVar(&showControl, "control", false, "Show pg_control file information")
 	flag.BoolVar(&verifyChecksums, "checksum", false, "Verify page checksums")
 	flag.BoolVar(&parseIndex, "index", false, "Parse index file (use with -f)")
+	flag.BoolVar(&inferSchema, "infer", false, "Infer column types from tuple data and dump rows (use with -f)")
 	flag.BoolVar(&showDropped, "dropped", false, "Show dropped columns")
+	flag.BoolVar(&droppedTables, "dropped-tables", false, "Recover dropped tables from dead pg_class/pg_attribute rows")
+	flag.BoolVar(&showOrphans, "orphans", false, "List relation files and flag those pg_class no longer references")
 	flag.StringVar(&showSequences, "sequences", "", "Show sequences ('all' or database name)")
 	flag.StringVar(&showRelmap, "relmap", "", "Show pg_filenode.map ('global', 'all', or db OID)")
 	flag.StringVar(&blockRange, "R", "", "Block range to read (e.g., '0:10', '5:', ':20', '5')")
+	flag.BoolVar(&showForks, "forks", false, "Add visibility map and free space map state to block output (use with -f)")
 	flag.BoolVar(&binaryDump, "b", false, "Binary block dump (hex output)")
 	flag.BoolVar(&skipOldValues, "o", false, "Skip old/dead tuple values")
 	flag.BoolVar(&toastVerbose, "toast-verbose", false, "Verbose TOAST information")
@@ -83,6 +113,17 @@ func main() {
 		return
 	}
 
+	if asOfXID > math.MaxUint32 {
+		fmt.Fprintf(os.Stderr, "Error: -as-of-xid %d is not a 32-bit transaction ID\n", asOfXID)
+		os.Exit(1)
+	}
+
+	redo, err := parseRedoTarget(redoLSN, redoTime, redoWAL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	if singleFile != "" {
 		// Build segment options if specified
 		var segOpts *pgdump.SegmentOptions
@@ -93,14 +134,18 @@ func main() {
 			}
 		}
 		
-		if binaryDump {
+		if redo != nil {
+			parseRedoFile(singleFile, blockRange, redo)
+		} else if binaryDump {
 			parseBinaryDump(singleFile, blockRange)
 		} else if parseIndex {
 			parseIndexFile(singleFile)
+		} else if inferSchema {
+			parseInferred(singleFile)
 		} else if toastVerbose {
 			parseToastVerbose(singleFile)
-		} else if blockRange != "" {
-			parseBlockRangeWithSegment(singleFile, blockRange, segOpts)
+		} else if blockRange != "" || showForks {
+			parseBlockRangeWithSegment(singleFile, blockRange, segOpts, showForks)
 		} else {
 			parseSingle(singleFile)
 		}
@@ -193,6 +238,64 @@ func main() {
 		return
 	}
 
+	// Recover dropped tables
+	if droppedTables {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if dbFilter != "" {
+			result, err := pgdump.RecoverDroppedTables(dataDir, dbFilter)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			enc.Encode(result)
+		} else {
+			results, err := pgdump.ScanDroppedTables(dataDir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			enc.Encode(results)
+		}
+		return
+	}
+
+	// Show schema history
+	if schemaHistory {
+		if dbFilter == "" {
+			fmt.Fprintln(os.Stderr, "Error: -schema-history requires -db")
+			os.Exit(1)
+		}
+		histories, err := pgdump.ReadSchemaHistory(dataDir, dbFilter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		var matched []pgdump.SchemaHistory
+		for _, h := range histories {
+			if tableFilter == "" || strings.Contains(strings.ToLower(h.Name), strings.ToLower(tableFilter)) {
+				matched = append(matched, h)
+			}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(matched)
+		return
+	}
+
+	// Show orphaned relation files
+	if showOrphans {
+		reports, err := pgdump.FindOrphanFiles(dataDir, dbFilter)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(reports)
+		return
+	}
+
 	// Show sequences
 	if showSequences != "" {
 		enc := json.NewEncoder(os.Stdout)
@@ -283,6 +386,12 @@ func main() {
 		return
 	}
 
+	visibilityMode, err := pgdump.ParseVisibilityMode(visibility)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Search for secrets using trufflehog detectors
 	if secrets != "" {
 		if verbose {
@@ -291,7 +400,10 @@ func main() {
 		findings, err := pgdump.ScanForSecrets(dataDir, &pgdump.Options{
 			DatabaseFilter:   dbFilter,
 			TableFilter:      tableFilter,
+			SchemaFilter:     schemaFilter,
 			SkipSystemTables: true,
+			Visibility:       visibilityMode,
+			AsOfXID:          uint32(asOfXID),
 		})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -312,6 +424,8 @@ func main() {
 		results, err := pgdump.Search(dataDir, &pgdump.SearchOptions{
 			Pattern:    searchPattern,
 			IncludeRow: true,
+			Visibility: visibilityMode,
+			AsOfXID:    uint32(asOfXID),
 		})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -336,12 +450,81 @@ func main() {
 		return
 	}
 
+	// Full-page images from WAL
+	if walFPI != "" {
+		filter := &pgdump.FPIFilter{}
+		if walFPI != "all" {
+			oid, err := strconv.ParseUint(walFPI, 10, 32)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid filenode %q\n", walFPI)
+				os.Exit(1)
+			}
+			filter.Filenode = uint32(oid)
+		}
+		br, err := pgdump.ParseBlockRange(blockRange)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		filter.Blocks = br
+
+		walDir := filepath.Join(dataDir, "pg_wal")
+		var images []pgdump.FullPageImage
+		if fpiDir != "" {
+			images, err = pgdump.WriteFullPageImages(walDir, fpiDir, filter)
+		} else {
+			err = pgdump.ExtractFullPageImages(walDir, filter, func(fpi *pgdump.FullPageImage) error {
+				fpi.Page = nil
+				images = append(images, *fpi)
+				return nil
+			})
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(images)
+		return
+	}
+
+	// Row changes from WAL
+	if walChanges {
+		pgdump.Debug = debug
+		enc := json.NewEncoder(os.Stdout)
+		err := pgdump.ReadWALChanges(dataDir, &pgdump.Options{
+			DatabaseFilter:   dbFilter,
+			TableFilter:      tableFilter,
+			SchemaFilter:     schemaFilter,
+			SkipSystemTables: true,
+			Visibility:       visibilityMode,
+		}, func(c *pgdump.WALChange) error {
+			return enc.Encode(c)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	pgdump.Debug = debug
 	result, err := pgdump.DumpDataDir(dataDir, &pgdump.Options{
 		DatabaseFilter:   dbFilter,
 		TableFilter:      tableFilter,
+		SchemaFilter:     schemaFilter,
 		ListOnly:         listOnly,
 		SkipSystemTables: true,
+		Visibility:       visibilityMode,
+		AsOfXID:          uint32(asOfXID),
+		IncludeDeleted:   showDeleted,
+		SystemColumns:    systemColumns,
+		History:          showHistory,
+		DeadItems:        deadItems,
+		Carve:            carve,
+		XminSchema:       xminSchema,
+		Redo:             redo,
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -354,14 +537,15 @@ func main() {
 		}
 	}
 
-	// Output format
+	// Output format (history and carved tuples are JSON only)
+	jsonOnly := showHistory || carve
 	switch {
-	case sqlOutput:
+	case sqlOutput && !jsonOnly:
 		if err := result.ToSQL(os.Stdout); err != nil {
 			fmt.Fprintf(os.Stderr, "Error generating SQL: %v\n", err)
 			os.Exit(1)
 		}
-	case csvOutput:
+	case csvOutput && !jsonOnly:
 		if err := result.ToCSV(os.Stdout); err != nil {
 			fmt.Fprintf(os.Stderr, "Error generating CSV: %v\n", err)
 			os.Exit(1)
@@ -374,7 +558,7 @@ func main() {
 }
 
 func parseToastVerbose(path string) {
-	data, err := os.ReadFile(path)
+	data, err := pgdump.ReadRelationFile(path)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -398,7 +582,58 @@ func parseToastVerbose(path string) {
 	enc.Encode(info)
 }
 
-func parseBlockRangeWithSegment(path, rangeStr string, segOpts *pgdump.SegmentOptions) {
+// parseRedoTarget builds the redo target of -redo-lsn and -redo-time, nil
+// when neither is set
+func parseRedoTarget(lsn, at, walDir string) (*pgdump.RedoTarget, error) {
+	if lsn == "" && at == "" {
+		return nil, nil
+	}
+	target := &pgdump.RedoTarget{WALDir: walDir}
+	if lsn != "" {
+		v, err := pgdump.ParseLSN(lsn)
+		if err != nil {
+			return nil, err
+		}
+		target.LSN = v
+	}
+	if at != "" {
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"} {
+			if t, err := time.ParseInLocation(layout, at, time.Local); err == nil {
+				target.Time = t
+				break
+			}
+		}
+		if target.Time.IsZero() {
+			return nil, fmt.Errorf("invalid time %q", at)
+		}
+	}
+	return target, nil
+}
+
+// parseRedoFile rebuilds a relation file from WAL and shows its blocks
+func parseRedoFile(path, rangeStr string, target *pgdump.RedoTarget) {
+	br, err := pgdump.ParseBlockRange(rangeStr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing block range: %v\n", err)
+		os.Exit(1)
+	}
+	rel, err := pgdump.RedoRelationFile(path, target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "[*] %d blocks: %d images restored, %d records applied, %d skipped\n",
+		rel.Blocks, rel.Restored, rel.Applied, rel.Skipped)
+	for _, e := range rel.Errors {
+		fmt.Fprintf(os.Stderr, "[!] %s\n", e)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(rel.DumpBlockRange(br))
+}
+
+func parseBlockRangeWithSegment(path, rangeStr string, segOpts *pgdump.SegmentOptions, forks bool) {
 	br, err := pgdump.ParseBlockRange(rangeStr)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error parsing block range: %v\n", err)
@@ -416,7 +651,11 @@ func parseBlockRangeWithSegment(path, rangeStr string, segOpts *pgdump.SegmentOp
 			segInfo.SegmentNumber, segInfo.TotalBlocks, segInfo.GlobalOffset)
 	}
 	
-	blocks, err := pgdump.DumpBlockRange(path, br)
+	dump := pgdump.DumpBlockRange
+	if forks {
+		dump = pgdump.DumpBlockRangeWithForks
+	}
+	blocks, err := dump(path, br)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -453,7 +692,7 @@ func parseBinaryDump(path, rangeStr string) {
 }
 
 func parseIndexFile(path string) {
-	data, err := os.ReadFile(path)
+	data, err := pgdump.ReadRelationFile(path)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -470,8 +709,26 @@ func parseIndexFile(path string) {
 	enc.Encode(info)
 }
 
+func parseInferred(path string) {
+	data, err := pgdump.ReadRelationFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	result := pgdump.InferSchema(data)
+	if len(result.Candidates) == 0 {
+		fmt.Fprintln(os.Stderr, "No tuples to infer a schema from")
+		os.Exit(1)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(result)
+}
+
 func parseSingle(path string) {
-	data, err := os.ReadFile(path)
+	data, err := pgdump.ReadRelationFile(path)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -488,6 +745,11 @@ func parseSingle(path string) {
 		for _, t := range pgdump.ParsePGClass(data) {
 			fmt.Printf("  %s (OID %d, filenode %d, kind %s)\n", t.Name, t.OID, t.Filenode, t.Kind)
 		}
+	case "1247":
+		fmt.Println("pg_type:")
+		for _, t := range pgdump.ParsePGType(data, 0, nil) {
+			fmt.Printf("  %s (OID %d, typtype %c, len %d)\n", t.Name, t.OID, t.Kind, t.Len)
+		}
 	case "1249":
 		fmt.Println("pg_attribute:")
 		for relid, cols := range pgdump.ParsePGAttribute(data, 0) {
@@ -514,6 +776,7 @@ Usage:
   pgread -list-db                            List databases
   pgread -db mydb                            Dump specific database
   pgread -db mydb -t password                Filter tables
+  pgread -db mydb -schema tenant_42          Filter by schema
   pgread -d /path/to/data/                   Use specific data directory
   pgread -f /path/to/1262                    Parse single file
 
@@ -523,23 +786,42 @@ Security / Forensics:
   pgread -secrets auto                       Search for secrets (700+ patterns via Trufflehog)
   pgread -search "password|secret"           Search with custom regex
   pgread -deleted                            Include deleted (non-vacuumed) rows
+  pgread -deleted -sql                       Recovered rows as commented-out INSERTs
+  pgread -visibility hints                   Trust hint bits only (skip pg_xact lookups)
+  pgread -as-of-xid 1234 -db mydb            Show rows as they were after xid 1234
+  pgread -as-of-xid 1234 -search "admin"     Search a past snapshot
+  pgread -system-columns -db mydb            Add ctid, xmin, xmax, infomask flags to rows
+  pgread -history -db mydb -t users          Row versions and per-column changes
+  pgread -deleted -dead-items                Also recover tuples behind pruned line pointers
+  pgread -carve -db mydb -t users            Carve tuples from free space (offset, confidence)
   pgread -wal                                Show WAL transaction summary
+  pgread -wal-changes -db mydb -t users      Row changes from WAL, including vacuumed rows
+  pgread -wal-fpi 16385 -R 0 -fpi-dir pages  Write every WAL image of block 0 as 8 KB pages
+  pgread -redo-lsn 0/3000148 -db mydb        Replay heap WAL and dump tables as of an LSN
+  pgread -redo-time "2024-05-01 12:00:00"    Replay heap WAL up to a point in time
 
 Low-Level / Forensics:
   pgread -control                            Show pg_control file (version, state, LSN)
   pgread -checksum                           Verify page checksums (detect corruption)
   pgread -dropped                            Show dropped columns (recoverable data)
+  pgread -dropped-tables -db mydb            Rebuild dropped tables and dump their rows
+  pgread -schema-history -db mydb -t users   Renames, type changes, added/dropped columns
+  pgread -xmin-schema -db mydb               Decode old rows with the schema they were written with
+  pgread -orphans -db mydb                   Relation files no live pg_class row references
   pgread -sequences all                      Show all sequences
   pgread -sequences mydb                     Show sequences for specific database
   pgread -relmap global                      Show global pg_filenode.map
   pgread -relmap all                         Show all relmap files
   pgread -f /path/to/file -R 0:10            Read specific block range
+  pgread -f /path/to/file -R 0:10 -forks     Blocks with visibility map and FSM state
+  pgread -f /path/to/file -redo-lsn 0/1A2B   Blocks rebuilt from WAL up to an LSN (with -R)
   pgread -f /path/to/file -b                 Binary block dump (hex output)
   pgread -f /path/to/file -b -R 0:5          Binary dump of block range
   pgread -f /path/to/toast -toast-verbose    Verbose TOAST table info
   pgread -f /path/to/file -n 2 -R 0:10       Read from segment 2
   pgread -f /path/to/file -s 134217728       Custom segment size (128MB)
   pgread -f /path/to/index -index            Parse index file (BTree/GIN/GiST/Hash)
+  pgread -f /path/to/heap -infer             Guess column types without pg_attribute
 
 Fixed OIDs:
   1262  pg_database  (global/1262)
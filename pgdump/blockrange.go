@@ -141,6 +141,10 @@ type BlockInfo struct {
 	ItemCount   int    `json:"item_count"`
 	FreeSpace   int    `json:"free_space"`
 	IsEmpty     bool   `json:"is_empty,omitempty"`
+
+	// Set by DumpBlockRangeWithForks
+	VM      *VMStatus `json:"vm,omitempty"`
+	FSMFree *int      `json:"fsm_free,omitempty"` // free space the FSM records
 }
 
 // ParseBlockInfo extracts information about a single block
@@ -167,7 +171,7 @@ func ParseBlockInfo(data []byte, blockNumber uint32) *BlockInfo {
 	}
 
 	// Parse page header
-	lsn := u64(data, 0)
+	lsn := pageLSN(data)
 	info.LSN = FormatLSN(lsn)
 	info.Checksum = u16(data, 8)
 	info.Flags = u16(data, 10)
@@ -0,0 +1,250 @@
+package pgdump
+
+import "math"
+
+// Regions of a page a carved tuple can come from
+const (
+	RegionFreeSpace    = "free_space"   // between pd_lower and pd_upper
+	RegionUnreferenced = "unreferenced" // tuple area no LP_NORMAL item points at
+	RegionDamagedPage  = "damaged_page" // page whose header fails validation
+)
+
+// CarvedTuple is a heap tuple found by scanning raw page bytes rather than
+// through a line pointer
+type CarvedTuple struct {
+	Block      uint32                 `json:"block"`
+	Offset     int                    `json:"offset"` // byte offset within the page
+	Length     int                    `json:"length"`
+	Region     string                 `json:"region"`
+	Confidence float64                `json:"confidence"` // 0-1, see carveScore
+	Xmin       uint32                 `json:"xmin"`
+	Xmax       uint32                 `json:"xmax"`
+	Values     map[string]interface{} `json:"values"`
+}
+
+// CarveTuples scans every page of a heap for tuples matching columns
+func CarveTuples(data []byte, columns []Column) []CarvedTuple {
+	var d Decoder
+	return d.Carve(data, columns)
+}
+
+// Carve scans every page of a heap for tuples matching columns outside
+// the live line pointers: in free space, in unreferenced tuple storage and
+// anywhere on pages whose header is damaged
+func (d *Decoder) Carve(data []byte, columns []Column) []CarvedTuple {
+	var carved []CarvedTuple
+	for off := 0; off+PageSize <= len(data); off += PageSize {
+		carved = append(carved, d.CarvePage(data[off:off+PageSize], uint32(off/PageSize), columns)...)
+	}
+	return carved
+}
+
+// CarvePage scans one page byte by byte for plausible tuple headers
+func (d *Decoder) CarvePage(page []byte, block uint32, columns []Column) []CarvedTuple {
+	if len(page) < PageSize || len(columns) == 0 || isZeroPage(page) {
+		return nil
+	}
+
+	// Bytes owned by live tuples are not carved
+	h := parseHeader(page)
+	valid := validHeader(h)
+	owned := make([]bool, PageSize)
+	if valid {
+		for _, item := range parseItems(page, h) {
+			if item.Flags == lpNormal && item.Offset+item.Length <= PageSize {
+				for i := item.Offset; i < item.Offset+item.Length; i++ {
+					owned[i] = true
+				}
+			}
+		}
+	}
+
+	// free[i] is where the unowned run starting at i ends
+	free := make([]int, PageSize+1)
+	free[PageSize] = PageSize
+	for i := PageSize - 1; i >= 0; i-- {
+		free[i] = i
+		if !owned[i] {
+			free[i] = free[i+1]
+		}
+	}
+
+	start := headerSize
+	if valid {
+		start = int(h.Lower) // the line pointer array holds no tuples
+	}
+
+	var carved []CarvedTuple
+	for off := start; off+tupleHeaderSize <= PageSize; off++ {
+		if free[off]-off < tupleHeaderSize {
+			continue
+		}
+		c, ok := d.carveAt(page[off:free[off]], block, off, columns)
+		if !ok {
+			continue
+		}
+		switch {
+		case !valid:
+			c.Region = RegionDamagedPage
+		case off < int(h.Upper):
+			c.Region = RegionFreeSpace
+		default:
+			c.Region = RegionUnreferenced
+		}
+		carved = append(carved, c)
+		off += c.Length - 1
+	}
+	return carved
+}
+
+// carveAt checks whether raw starts with a tuple of the table and
+// decodes it. Hard checks reject impossible headers; what passes is scored
+func (d *Decoder) carveAt(raw []byte, block uint32, off int, columns []Column) (CarvedTuple, bool) {
+	var c CarvedTuple
+	xmin := u32(raw, 0)
+	infomask2, infomask := u16(raw, 18), u16(raw, 20)
+	natts := int(infomask2 & HeapNattsMask)
+	if xmin == InvalidXID || natts == 0 || natts > len(columns) || infomask2&0x1800 != 0 {
+		return c, false
+	}
+
+	// t_hoff is fully determined by the null bitmap
+	hoff := tupleHeaderSize
+	if infomask&HeapHasNull != 0 {
+		hoff += (natts + 7) / 8
+	}
+	hoff = align(hoff, 8)
+	if int(raw[22]) != hoff || hoff > len(raw) {
+		return c, false
+	}
+	tuple := ParseHeapTuple(raw)
+	if tuple == nil {
+		return c, false
+	}
+	if tuple.Bitmap != nil && natts%8 != 0 && tuple.Bitmap[len(tuple.Bitmap)-1]>>(natts%8) != 0 {
+		return c, false // bits past natts are always zero
+	}
+	span, hasVarlena, ok := tupleSpan(tuple, columns)
+	if !ok || span == 0 {
+		return c, false
+	}
+	tuple.Data = tuple.Data[:span]
+
+	c = CarvedTuple{
+		Block:      block,
+		Offset:     off,
+		Length:     hoff + span,
+		Confidence: carveScore(tuple, block, off, len(columns), hasVarlena),
+		Xmin:       tuple.Header.Xmin,
+		Xmax:       tuple.Header.Xmax,
+		Values:     d.DecodeTuple(tuple, columns),
+	}
+	return c, c.Values != nil
+}
+
+// carveScore rates how much a carved header looks like one PostgreSQL
+// wrote for this table and page
+func carveScore(t *HeapTupleData, block uint32, off, ncols int, hasVarlena bool) float64 {
+	h := t.Header
+	score := 0.25
+	if h.Natts == ncols {
+		score += 0.2 // older tuples may lack columns added since
+	}
+	if off%8 == 0 {
+		score += 0.1 // tuples are MAXALIGNed
+	}
+	if h.Xmin >= FirstNormalXID || h.Infomask&HeapXminFrozen == HeapXminFrozen {
+		score += 0.1
+	}
+	if (h.Infomask&HeapHasVarWidth != 0) == hasVarlena {
+		score += 0.1
+	}
+	if !h.HasNull || hasNullBit(t) {
+		score += 0.1
+	}
+	if h.Infomask&(HeapXminCommitted|HeapXminInvalid|HeapXmaxInvalid|HeapXmaxCommitted) != 0 {
+		score += 0.05 // hint bits set by a later reader
+	}
+	if h.CtidBlock == block && h.CtidOffset >= 1 && h.CtidOffset <= maxHeapTuplesPerPage {
+		score += 0.1
+	}
+	return math.Round(score*100) / 100
+}
+
+// maxHeapTuplesPerPage is MaxHeapTuplesPerPage for 8 KB pages
+const maxHeapTuplesPerPage = 291
+
+// hasNullBit reports whether the null bitmap marks any attribute NULL
+func hasNullBit(t *HeapTupleData) bool {
+	for n := 1; n <= t.Header.Natts; n++ {
+		if t.IsNull(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// tupleSpan walks the stored attributes the way DecodeTuple lays them out
+// and returns the bytes they occupy. ok is false when a value runs past
+// the data or carries an impossible varlena header
+func tupleSpan(t *HeapTupleData, columns []Column) (n int, hasVarlena, ok bool) {
+	data := t.Data
+	off := 0
+	for idx, col := range columns {
+		num := col.Num
+		if num == 0 {
+			num = idx + 1
+		}
+		if num > t.Header.Natts || t.IsNull(num) {
+			continue
+		}
+
+		colAlign := alignFromChar(col.Align)
+		if colAlign == 0 {
+			colAlign = typeAlign(col.TypID, col.Len)
+		}
+		if col.Len == -1 && off < len(data) && (isShortVarlena(data[off:]) || IsTOASTPointer(data[off:])) {
+			colAlign = 1
+		}
+		off = align(off, colAlign)
+		if off >= len(data) {
+			return 0, false, false
+		}
+		rem := data[off:]
+
+		size := 0
+		switch {
+		case col.Len > 0:
+			size = col.Len
+		case col.Len == -1:
+			hasVarlena = true
+			switch {
+			case IsTOASTPointer(rem):
+				size = varlenaExternalSize(rem)
+			case isShortVarlena(rem):
+				size = int(rem[0] >> 1)
+			case len(rem) >= 4 && u32(rem, 0)&0x01 == 0:
+				size = int(u32(rem, 0) >> 2)
+				if size < 4 {
+					return 0, false, false
+				}
+			default:
+				return 0, false, false
+			}
+		default:
+			hasVarlena = true
+			size = len(rem) + 1
+			for i, b := range rem {
+				if b == 0 {
+					size = i + 1
+					break
+				}
+			}
+		}
+		if off+size > len(data) {
+			return 0, false, false
+		}
+		off += size
+	}
+	return off, hasVarlena, true
+}
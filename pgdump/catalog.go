@@ -1,6 +1,11 @@
 package pgdump
 
-import "sort"
+import (
+	"encoding/hex"
+	"slices"
+	"sort"
+	"strings"
+)
 
 // System catalog OIDs (fixed in all PostgreSQL versions)
 const (
@@ -8,6 +13,10 @@ const (
 	PGAuthID    = 1260 // pg_authid - users/passwords (global)
 	PGClass     = 1259 // pg_class - tables/indexes
 	PGAttribute = 1249 // pg_attribute - table columns
+	PGTablespace = 1213 // pg_tablespace - tablespaces (global)
+	PGNamespace  = 2615 // pg_namespace - schemas
+	PGType       = 1247 // pg_type - data types
+	PGEnum       = 3501 // pg_enum - enum labels
 )
 
 // Column defines a table column for decoding
@@ -17,6 +26,10 @@ type Column struct {
 	Len   int
 	Num   int
 	Align byte // 'c'=1, 's'=2, 'i'=4, 'd'=8
+
+	// Missing is attmissingval, the value of a column added with a
+	// non-volatile default for tuples written before it (nil = NULL)
+	Missing []byte
 }
 
 // DatabaseInfo represents a database entry
@@ -25,10 +38,20 @@ type DatabaseInfo struct {
 	Name string
 }
 
+// NamespaceInfo represents a schema entry
+type NamespaceInfo struct {
+	OID  uint32
+	Name string
+}
+
 // TableInfo represents a table entry
 type TableInfo struct {
 	OID, Filenode uint32
 	Name, Kind    string
+	Schema        string // resolved from relnamespace via pg_namespace
+	Namespace     uint32 // relnamespace
+	ToastRelID    uint32 // reltoastrelid (0 = no TOAST table)
+	Tablespace    uint32 // reltablespace (0 = database default)
 }
 
 // AttrInfo represents a column attribute
@@ -38,6 +61,8 @@ type AttrInfo struct {
 	Num   int
 	Len   int
 	Align byte // 'c'=1, 's'=2, 'i'=4, 'd'=8
+
+	Missing []byte // attmissingval array datum (see Column.Missing)
 }
 
 // Predefined schemas for system catalogs
@@ -47,6 +72,11 @@ var (
 		{Name: "datname", TypID: OidName, Len: 64},
 	}
 
+	schemaPGNamespace = []Column{
+		{Name: "oid", TypID: OidOid, Len: 4},
+		{Name: "nspname", TypID: OidName, Len: 64},
+	}
+
 	schemaPGClass = []Column{
 		{Name: "oid", TypID: OidOid, Len: 4},
 		{Name: "relname", TypID: OidName, Len: 64},
@@ -81,6 +111,53 @@ var (
 		{Name: "attalign", TypID: OidChar, Len: 1},
 	}
 
+	// Complete pg_attribute rows, used to reach attmissingval
+	schemaPGAttrFullV12 = attrLayout(
+		attrCol("attrelid", OidOid), attrCol("attname", OidName), attrCol("atttypid", OidOid),
+		attrCol("attstattarget", OidInt4), attrCol("attlen", OidInt2), attrCol("attnum", OidInt2),
+		attrCol("attndims", OidInt4), attrCol("attcacheoff", OidInt4), attrCol("atttypmod", OidInt4),
+		attrCol("attbyval", OidBool), attrCol("attstorage", OidChar), attrCol("attalign", OidChar),
+		attrCol("attnotnull", OidBool), attrCol("atthasdef", OidBool), attrCol("atthasmissing", OidBool),
+		attrCol("attidentity", OidChar), attrCol("attgenerated", OidChar), attrCol("attisdropped", OidBool),
+		attrCol("attislocal", OidBool), attrCol("attinhcount", OidInt4), attrCol("attcollation", OidOid),
+	)
+
+	// PostgreSQL 14-15: attcompression added, attalign moved before attstorage
+	schemaPGAttrFullV14 = attrLayout(
+		attrCol("attrelid", OidOid), attrCol("attname", OidName), attrCol("atttypid", OidOid),
+		attrCol("attstattarget", OidInt4), attrCol("attlen", OidInt2), attrCol("attnum", OidInt2),
+		attrCol("attndims", OidInt4), attrCol("attcacheoff", OidInt4), attrCol("atttypmod", OidInt4),
+		attrCol("attbyval", OidBool), attrCol("attalign", OidChar), attrCol("attstorage", OidChar),
+		attrCol("attcompression", OidChar), attrCol("attnotnull", OidBool), attrCol("atthasdef", OidBool),
+		attrCol("atthasmissing", OidBool), attrCol("attidentity", OidChar), attrCol("attgenerated", OidChar),
+		attrCol("attisdropped", OidBool), attrCol("attislocal", OidBool), attrCol("attinhcount", OidInt4),
+		attrCol("attcollation", OidOid),
+	)
+
+	// PostgreSQL 16: columns reordered, attstattarget/attinhcount/attndims int2
+	schemaPGAttrFullV16 = attrLayout(
+		attrCol("attrelid", OidOid), attrCol("attname", OidName), attrCol("atttypid", OidOid),
+		attrCol("attlen", OidInt2), attrCol("attnum", OidInt2), attrCol("attcacheoff", OidInt4),
+		attrCol("atttypmod", OidInt4), attrCol("attndims", OidInt2), attrCol("attbyval", OidBool),
+		attrCol("attalign", OidChar), attrCol("attstorage", OidChar), attrCol("attcompression", OidChar),
+		attrCol("attnotnull", OidBool), attrCol("atthasdef", OidBool), attrCol("atthasmissing", OidBool),
+		attrCol("attidentity", OidChar), attrCol("attgenerated", OidChar), attrCol("attisdropped", OidBool),
+		attrCol("attislocal", OidBool), attrCol("attinhcount", OidInt2), attrCol("attstattarget", OidInt2),
+		attrCol("attcollation", OidOid),
+	)
+
+	// PostgreSQL 17+: attstattarget is nullable and moved to the variable part
+	schemaPGAttrFullV17 = attrLayout(
+		attrCol("attrelid", OidOid), attrCol("attname", OidName), attrCol("atttypid", OidOid),
+		attrCol("attlen", OidInt2), attrCol("attnum", OidInt2), attrCol("attcacheoff", OidInt4),
+		attrCol("atttypmod", OidInt4), attrCol("attndims", OidInt2), attrCol("attbyval", OidBool),
+		attrCol("attalign", OidChar), attrCol("attstorage", OidChar), attrCol("attcompression", OidChar),
+		attrCol("attnotnull", OidBool), attrCol("atthasdef", OidBool), attrCol("atthasmissing", OidBool),
+		attrCol("attidentity", OidChar), attrCol("attgenerated", OidChar), attrCol("attisdropped", OidBool),
+		attrCol("attislocal", OidBool), attrCol("attinhcount", OidInt2), attrCol("attcollation", OidOid),
+		attrCol("attstattarget", OidInt2),
+	)
+
 	// PostgreSQL 16+ pg_attribute structure (attstattarget removed)
 	schemaPGAttrV16 = []Column{
 		{Name: "attrelid", TypID: OidOid, Len: 4},
@@ -97,8 +174,14 @@ var (
 
 // ParsePGDatabase extracts database list from pg_database heap file
 func ParsePGDatabase(data []byte) []DatabaseInfo {
+	return parsePGDatabase(data, nil)
+}
+
+// parsePGDatabase is ParsePGDatabase with visibility decided by xact
+// (nil = hint bits)
+func parsePGDatabase(data []byte, xact *CommitLog) []DatabaseInfo {
 	var result []DatabaseInfo
-	for _, row := range ReadRows(data, schemaPGDatabase, true) {
+	for _, row := range catalogRows(data, schemaPGDatabase, xact) {
 		if oid, name := getOID(row, "oid"), getString(row, "datname"); oid > 0 && name != "" {
 			result = append(result, DatabaseInfo{OID: oid, Name: name})
 		}
@@ -106,32 +189,140 @@ func ParsePGDatabase(data []byte) []DatabaseInfo {
 	return result
 }
 
+// ParsePGNamespace extracts schema list from pg_namespace heap file; xact
+// decides which rows are live (nil = hint bits)
+func ParsePGNamespace(data []byte, xact *CommitLog) []NamespaceInfo {
+	var result []NamespaceInfo
+	for _, row := range catalogRows(data, schemaPGNamespace, xact) {
+		if oid, name := getOID(row, "oid"), getString(row, "nspname"); oid > 0 && name != "" {
+			result = append(result, NamespaceInfo{OID: oid, Name: name})
+		}
+	}
+	return result
+}
+
+// ResolveSchemas fills TableInfo.Schema by reading pg_namespace through
+// reader; its filenode is looked up in pg_class like any other relation
+func ResolveSchemas(tables map[uint32]TableInfo, reader FileReader, xact *CommitLog) {
+	names := namespaceNames(tables, reader, xact)
+	if names == nil {
+		return
+	}
+	for fn, info := range tables {
+		info.Schema = names[info.Namespace]
+		tables[fn] = info
+	}
+}
+
+// namespaceNames maps pg_namespace OIDs to schema names
+func namespaceNames(tables map[uint32]TableInfo, reader FileReader, xact *CommitLog) map[uint32]string {
+	data, err := readCatalog(tables, reader, PGNamespace)
+	if err != nil {
+		return nil
+	}
+	names := make(map[uint32]string)
+	for _, ns := range ParsePGNamespace(data, xact) {
+		names[ns.OID] = ns.Name
+	}
+	return names
+}
+
+// catalogRows decodes the live rows of a catalog, visibility decided by
+// xact (nil = hint bits)
+func catalogRows(data []byte, columns []Column, xact *CommitLog) []map[string]interface{} {
+	d := Decoder{Xact: xact}
+	return d.ReadRows(data, columns, true)
+}
+
+// readCatalog reads a per-database catalog by OID, taking its filenode
+// from pg_class and falling back to the OID itself
+func readCatalog(tables map[uint32]TableInfo, reader FileReader, oid uint32) ([]byte, error) {
+	filenode := oid
+	for fn, info := range tables {
+		if info.OID == oid {
+			filenode = fn
+			break
+		}
+	}
+	return reader(filenode)
+}
+
+// isSystemSchema reports whether a schema holds PostgreSQL's own catalogs
+func isSystemSchema(schema string) bool {
+	return schema == "pg_catalog" || schema == "information_schema" ||
+		schema == "pg_toast" || strings.HasPrefix(schema, "pg_temp_") || strings.HasPrefix(schema, "pg_toast_temp_")
+}
+
+// qualifiedName returns schema.name, or name when the schema is unknown
+func qualifiedName(schema, name string) string {
+	if schema == "" {
+		return name
+	}
+	return schema + "." + name
+}
+
 // ParsePGClass extracts table info from pg_class heap file
 func ParsePGClass(data []byte) map[uint32]TableInfo {
+	return ParsePGClassWithRelMap(data, nil)
+}
+
+// ParsePGClassWithRelMap is ParsePGClass that also keeps mapped catalogs
+// (relfilenode = 0), taking their filenode from pg_filenode.map. xact
+// decides which rows are live (nil = hint bits)
+func ParsePGClassWithRelMap(data []byte, xact *CommitLog, maps ...*RelMapFile) map[uint32]TableInfo {
 	tables := make(map[uint32]TableInfo)
-	for _, row := range ReadRows(data, schemaPGClass, true) {
-		if fn := getOID(row, "relfilenode"); fn > 0 {
-			tables[fn] = TableInfo{
-				OID:      getOID(row, "oid"),
-				Name:     getString(row, "relname"),
-				Filenode: fn,
-				Kind:     getString(row, "relkind"),
-			}
+	for _, row := range catalogRows(data, schemaPGClass, xact) {
+		if info := classInfo(row, maps); info.Filenode > 0 {
+			tables[info.Filenode] = info
 		}
 	}
 	return tables
 }
 
+// classInfo builds a TableInfo from a pg_class row
+func classInfo(row map[string]interface{}, maps []*RelMapFile) TableInfo {
+	fn := getOID(row, "relfilenode")
+	if fn == 0 {
+		fn = mappedFilenode(getOID(row, "oid"), maps)
+	}
+	return TableInfo{
+		OID:        getOID(row, "oid"),
+		Name:       getString(row, "relname"),
+		Filenode:   fn,
+		Kind:       getString(row, "relkind"),
+		Namespace:  getOID(row, "relnamespace"),
+		ToastRelID: getOID(row, "reltoastrelid"),
+		Tablespace: getOID(row, "reltablespace"),
+	}
+}
+
 // ParsePGAttribute extracts column info from pg_attribute heap file
 func ParsePGAttribute(data []byte, pgVersion int) map[uint32][]AttrInfo {
+	return parseAttributes(data, pgVersion, nil, true)
+}
+
+// parseAttributes is ParsePGAttribute with visibility decided by xact (nil
+// = hint bits), optionally also reading the dead rows of dropped
+// relations, keeping the newest version of each column
+func parseAttributes(data []byte, pgVersion int, xact *CommitLog, visibleOnly bool) map[uint32][]AttrInfo {
 	schema := detectAttrSchema(data, pgVersion)
 	result := make(map[uint32][]AttrInfo)
+	// Without visibility filtering several versions of an attribute can
+	// survive; keep the one written last
+	newest := make(map[attKey]uint32)
 
-	for _, row := range ReadRows(data, schema, true) {
+	d := Decoder{Xact: xact, SystemColumns: true}
+	for _, row := range d.ReadRows(data, schema, visibleOnly) {
 		relid, num := getOID(row, "attrelid"), toInt(row["attnum"])
 		if relid == 0 || num <= 0 {
 			continue
 		}
+		key, xmin := attKey{relid, num}, row["xmin"].(uint32)
+		prev, seen := newest[key]
+		if seen && xidPrecedesOrEquals(xmin, prev) {
+			continue
+		}
+		newest[key] = xmin
 		
 		// Get alignment character ('c', 's', 'i', 'd')
 		var alignByte byte = 'i' // default to int alignment
@@ -139,13 +330,27 @@ func ParsePGAttribute(data []byte, pgVersion int) map[uint32][]AttrInfo {
 			alignByte = align[0]
 		}
 		
-		result[relid] = append(result[relid], AttrInfo{
+		attr := AttrInfo{
 			Name:  getString(row, "attname"),
 			TypID: int(getOID(row, "atttypid")),
 			Num:   num,
 			Len:   toInt(row["attlen"]),
 			Align: alignByte,
-		})
+		}
+		if i := slices.IndexFunc(result[relid], func(a AttrInfo) bool { return a.Num == num }); i >= 0 {
+			result[relid][i] = attr
+		} else {
+			result[relid] = append(result[relid], attr)
+		}
+	}
+
+	// Fast defaults (atthasmissing)
+	for key, missing := range parseMissingValues(data, pgVersion, xact) {
+		for i, a := range result[key.relid] {
+			if a.Num == key.num {
+				result[key.relid][i].Missing = missing
+			}
+		}
 	}
 
 	// Sort by attnum
@@ -157,6 +362,24 @@ func ParsePGAttribute(data []byte, pgVersion int) map[uint32][]AttrInfo {
 	return result
 }
 
+// attrCol is a fixed-width pg_attribute column
+func attrCol(name string, typID int) Column {
+	if typID == OidName {
+		return Column{Name: name, TypID: typID, Len: 64}
+	}
+	return Column{Name: name, TypID: typID, Len: fixedLengths[typID]}
+}
+
+// attrLayout appends the variable-length columns every version ends with
+func attrLayout(fixed ...Column) []Column {
+	return append(fixed,
+		Column{Name: "attacl", TypID: 1034, Len: -1, Align: 'i'},
+		Column{Name: "attoptions", TypID: 1009, Len: -1, Align: 'i'},
+		Column{Name: "attfdwoptions", TypID: 1009, Len: -1, Align: 'i'},
+		Column{Name: "attmissingval", TypID: OidBytea, Len: -1, Align: 'd'},
+	)
+}
+
 func detectAttrSchema(data []byte, version int) []Column {
 	if version >= 16 {
 		return schemaPGAttrV16
@@ -182,6 +405,72 @@ func detectAttrSchema(data []byte, version int) []Column {
 	return schemaPGAttrV15
 }
 
+// detectAttrLayout is detectAttrSchema for the complete pg_attribute rows.
+// Without a version, PostgreSQL 12-13 and 14-15 are told apart by which
+// column holds a valid attalign; 16 and 17 only differ past attislocal
+func detectAttrLayout(data []byte, version int) []Column {
+	switch {
+	case version >= 17:
+		return schemaPGAttrFullV17
+	case version == 16:
+		return schemaPGAttrFullV16
+	case version >= 14:
+		return schemaPGAttrFullV14
+	case version >= 12:
+		return schemaPGAttrFullV12
+	}
+	if detectAttrSchema(data, 0)[3].Name != "attstattarget" {
+		return schemaPGAttrFullV16
+	}
+	for _, row := range ReadRows(data, schemaPGAttrFullV14, true) {
+		if align := getString(row, "attalign"); len(align) != 1 || !strings.Contains("csid", align) {
+			return schemaPGAttrFullV12
+		}
+	}
+	return schemaPGAttrFullV14
+}
+
+// attKey identifies a pg_attribute row
+type attKey struct {
+	relid uint32
+	num   int
+}
+
+// parseMissingValues reads attmissingval for columns with atthasmissing.
+// It sits at the end of the row, behind fixed fields that moved between
+// versions, so each layout is tried and a value is only kept when its
+// array element type matches atttypid
+func parseMissingValues(data []byte, version int, xact *CommitLog) map[attKey][]byte {
+	layouts := [][]Column{schemaPGAttrFullV12, schemaPGAttrFullV14, schemaPGAttrFullV16, schemaPGAttrFullV17}
+	switch {
+	case version >= 17:
+		layouts = layouts[3:]
+	case version == 16:
+		layouts = layouts[2:3]
+	case version >= 14:
+		layouts = layouts[1:2]
+	case version >= 12:
+		layouts = layouts[:1]
+	}
+
+	result := make(map[attKey][]byte)
+	for _, layout := range layouts {
+		for _, row := range catalogRows(data, layout, xact) {
+			key := attKey{getOID(row, "attrelid"), toInt(row["attnum"])}
+			if hasMissing, _ := row["atthasmissing"].(bool); !hasMissing || key.num <= 0 || result[key] != nil {
+				continue
+			}
+			// attmissingval is read as bytea to keep the datum undecoded
+			hexval, _ := row["attmissingval"].(string)
+			raw, err := hex.DecodeString(strings.TrimPrefix(hexval, `\x`))
+			if err == nil && len(raw) >= 20 && u32(raw, 8) == getOID(row, "atttypid") {
+				result[key] = raw
+			}
+		}
+	}
+	return result
+}
+
 func getOID(row map[string]interface{}, key string) uint32 {
 	if v, ok := row[key].(uint32); ok {
 		return v
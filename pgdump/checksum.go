@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strconv"
 )
 
 // ChecksumResult contains page checksum verification results
@@ -21,6 +20,7 @@ type ChecksumResult struct {
 // FileChecksumResult contains results for a single file
 type FileChecksumResult struct {
 	Path         string           `json:"path"`
+	Fork         string           `json:"fork,omitempty"`
 	TotalBlocks  int              `json:"total_blocks"`
 	ValidBlocks  int              `json:"valid_blocks"`
 	InvalidBlocks int             `json:"invalid_blocks"`
@@ -36,6 +36,7 @@ type DataDirChecksumResult struct {
 	TotalBlocks   int                   `json:"total_blocks"`
 	ValidBlocks   int                   `json:"valid_blocks"`
 	InvalidBlocks int                   `json:"invalid_blocks"`
+	InvalidByFork map[string]int        `json:"invalid_by_fork,omitempty"`
 	Files         []FileChecksumResult  `json:"files,omitempty"`
 }
 
@@ -60,7 +61,7 @@ func VerifyPageChecksum(page []byte, blockNumber uint32) ChecksumResult {
 	result.StoredChecksum = binary.LittleEndian.Uint16(page[8:10])
 	
 	// Get LSN
-	result.LSN = binary.LittleEndian.Uint64(page[0:8])
+	result.LSN = pageLSN(page)
 	result.LSNStr = FormatLSN(result.LSN)
 	
 	// Compute checksum
@@ -117,22 +118,12 @@ func VerifyDataDirChecksums(dataDir string) (*DataDirChecksumResult, error) {
 	
 	// Scan base directory for database directories
 	baseDir := filepath.Join(dataDir, "base")
-	entries, err := os.ReadDir(baseDir)
-	if err != nil {
+	if _, err := os.ReadDir(baseDir); err != nil {
 		return nil, fmt.Errorf("cannot read base directory: %w", err)
 	}
 	
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-		
-		// Check if it's a numeric OID directory
-		if _, err := strconv.ParseUint(entry.Name(), 10, 32); err != nil {
-			continue
-		}
-		
-		dbPath := filepath.Join(baseDir, entry.Name())
+	// Database directories in base/ and in every tablespace
+	for _, dbPath := range databaseDirs(dataDir) {
 		files, err := os.ReadDir(dbPath)
 		if err != nil {
 			continue
@@ -143,18 +134,11 @@ func VerifyDataDirChecksums(dataDir string) (*DataDirChecksumResult, error) {
 				continue
 			}
 			
-			// Check if it's a numeric filenode
-			name := f.Name()
-			if _, err := strconv.ParseUint(name, 10, 32); err != nil {
-				// Check for segment files (e.g., "12345.1")
-				if len(name) > 2 && name[len(name)-2] == '.' {
-					base := name[:len(name)-2]
-					if _, err := strconv.ParseUint(base, 10, 32); err != nil {
-						continue
-					}
-				} else {
-					continue
-				}
+			// Any fork of a relation, optionally with a segment suffix
+			// (e.g., "12345.1", "12345_fsm"); block numbers restart per fork
+			rel, ok := parseRelationFileName(f.Name())
+			if !ok {
+				continue
 			}
 			
 			filePath := filepath.Join(dbPath, f.Name())
@@ -163,20 +147,21 @@ func VerifyDataDirChecksums(dataDir string) (*DataDirChecksumResult, error) {
 				continue
 			}
 			
-			// Determine segment number from filename
-			segNum := uint32(0)
-			if idx := len(name) - 1; idx > 0 && name[idx-1] == '.' {
-				segNum = uint32(name[idx] - '0')
-			}
-			
-			fileResult := VerifyFileChecksums(data, segNum)
+			fileResult := VerifyFileChecksums(data, uint32(rel.Segment))
 			fileResult.Path = filePath
+			fileResult.Fork = rel.Fork
 			
 			result.TotalFiles++
 			result.TotalBlocks += fileResult.TotalBlocks
 			result.ValidBlocks += fileResult.ValidBlocks
 			result.InvalidBlocks += fileResult.InvalidBlocks
 			
+			if fileResult.InvalidBlocks > 0 {
+				if result.InvalidByFork == nil {
+					result.InvalidByFork = make(map[string]int)
+				}
+				result.InvalidByFork[rel.Fork] += fileResult.InvalidBlocks
+			}
 			if len(fileResult.Errors) > 0 {
 				result.Files = append(result.Files, *fileResult)
 			}
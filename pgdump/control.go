@@ -365,15 +365,20 @@ func inferPGVersion(controlVersion, catalogVersion uint32) int {
 
 // verifyCRC32C verifies CRC-32C checksum
 func verifyCRC32C(data []byte, expected uint32) bool {
-	crc := uint32(0xFFFFFFFF)
-	table := makeCRC32CTable()
+	crc := updateCRC32C(0xFFFFFFFF, data)
+	crc ^= 0xFFFFFFFF
+	return crc == expected
+}
+
+var crc32cTable = makeCRC32CTable()
 
+// updateCRC32C adds data to a running CRC-32C (COMP_CRC32C): start from
+// 0xFFFFFFFF and invert the result
+func updateCRC32C(crc uint32, data []byte) uint32 {
 	for _, b := range data {
-		crc = table[(crc^uint32(b))&0xFF] ^ (crc >> 8)
+		crc = crc32cTable[(crc^uint32(b))&0xFF] ^ (crc >> 8)
 	}
-
-	crc ^= 0xFFFFFFFF
-	return crc == expected
+	return crc
 }
 
 // makeCRC32CTable generates CRC-32C lookup table
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 )
 
 // ToCSV writes the dump result as CSV to the writer
@@ -21,7 +22,7 @@ func (r *DumpResult) ToCSV(w io.Writer) error {
 // ToCSV writes a single database dump as CSV
 func (d *DatabaseDump) ToCSV(w io.Writer) error {
 	for _, table := range d.Tables {
-		fmt.Fprintf(w, "# Database: %s, Table: %s\n", d.Name, table.Name)
+		fmt.Fprintf(w, "# Database: %s, Table: %s\n", d.Name, qualifiedName(table.Schema, table.Name))
 		if err := table.ToCSV(w); err != nil {
 			return err
 		}
@@ -40,27 +41,59 @@ func (t *TableDump) ToCSV(w io.Writer) error {
 		return nil
 	}
 
+	// Recovered rows are appended after live ones, told apart by StatusColumn
+	withStatus := len(t.DeletedRows) > 0
+	withFound := false
+	for _, row := range t.DeletedRows {
+		if _, ok := row[FoundColumn]; ok {
+			withFound = true
+			break
+		}
+	}
+
 	header := make([]string, len(t.Columns))
 	for i, col := range t.Columns {
 		header[i] = col.Name
 	}
+	if withStatus {
+		header = append(header, StatusColumn)
+	}
+	if withFound {
+		header = append(header, FoundColumn)
+	}
 	if err := cw.Write(header); err != nil {
 		return err
 	}
 
 	// Write rows
-	for _, row := range t.Rows {
-		record := make([]string, len(t.Columns))
-		for i, col := range t.Columns {
-			val, ok := row[col.Name]
-			if !ok || val == nil {
-				record[i] = ""
-			} else {
-				record[i] = formatCSVValue(val)
+	for _, rows := range [][]map[string]interface{}{t.Rows, t.DeletedRows} {
+		for _, row := range rows {
+			record := make([]string, len(t.Columns))
+			for i, col := range t.Columns {
+				val, ok := row[col.Name]
+				if !ok || val == nil {
+					record[i] = ""
+				} else {
+					record[i] = formatCSVValue(val)
+				}
+			}
+			if withStatus {
+				status, _ := row[StatusColumn].(string)
+				if status == "" {
+					status = StatusLive
+				}
+				record = append(record, status)
+			}
+			if withFound {
+				found, _ := row[FoundColumn].(string)
+				if found == "" {
+					found = FoundNormal
+				}
+				record = append(record, found)
+			}
+			if err := cw.Write(record); err != nil {
+				return err
 			}
-		}
-		if err := cw.Write(record); err != nil {
-			return err
 		}
 	}
 
@@ -92,6 +125,10 @@ func formatCSVValue(val interface{}) string {
 	case []byte:
 		return string(v)
 
+	case []string:
+		// Flag names (t_infomask)
+		return strings.Join(v, "|")
+
 	case []interface{}:
 		// Arrays - JSON encode
 		b, _ := json.Marshal(v)
@@ -23,6 +23,7 @@ func ReadDeletedRows(data []byte, columns []Column) []DeletedRow {
 		if tuple.Header.XmaxCommitted && !tuple.Header.XmaxInvalid {
 			row := DeletedRow{
 				PageOffset: entry.PageOffset,
+				ItemOffset: entry.Item,
 				RawSize:    len(tuple.Data),
 			}
 
@@ -38,38 +39,90 @@ func ReadDeletedRows(data []byte, columns []Column) []DeletedRow {
 	return deleted
 }
 
-// ScanAllDeletedRows scans entire data directory for deleted rows
-func ScanAllDeletedRows(dataDir string, opts *Options) (*DumpResult, error) {
-	opts = withDefaults(opts)
-	
-	// Use regular dump but include deleted rows
-	result, err := DumpDataDir(dataDir, opts)
-	if err != nil {
-		return nil, err
+// Tuple statuses reported in the StatusColumn of recovered rows
+const (
+	StatusLive          = "live"
+	StatusDeleted       = "deleted"        // deleted or updated by a committed transaction
+	StatusAbortedInsert = "aborted_insert" // inserting transaction rolled back
+	StatusUncommitted   = "uncommitted"    // inserting transaction not known to have committed
+)
+
+// Extra columns of recovered rows: the tuple status, and how the tuple
+// was found when its line pointer was not LP_NORMAL (see FoundDead)
+const (
+	StatusColumn = "_status"
+	FoundColumn  = "_found"
+)
+
+// TupleStatus classifies a tuple the way the decoder's visibility rules
+// see it. It returns "" for tuples inserted after the AsOf snapshot
+func (d *Decoder) TupleStatus(t *HeapTupleData) string {
+	h := t.Header
+	if d.AsOf != 0 && !xidPrecedesOrEquals(h.Xmin, d.AsOf) {
+		return ""
+	}
+	switch {
+	case d.IsVisible(t):
+		return StatusLive
+	case d.Xact.XminCommitted(h):
+		return StatusDeleted
+	case h.Infomask&HeapXminInvalid != 0:
+		return StatusAbortedInsert
 	}
+	if status, ok := d.Xact.Status(h.Xmin); ok && status == XactAborted {
+		return StatusAbortedInsert
+	}
+	return StatusUncommitted
+}
 
-	// Mark this as including deleted rows
-	// The actual deleted row detection happens in ReadRows when we pass visibleOnly=false
-	return result, nil
+// entryStatus is TupleStatus for a tuple found on a page: one recovered
+// from behind a dead line pointer was pruned, whatever its hint bits say
+func (d *Decoder) entryStatus(e TupleEntry) string {
+	status := d.TupleStatus(e.Tuple)
+	if status == StatusLive && e.Found != FoundNormal {
+		return StatusDeleted
+	}
+	return status
+}
+
+// ScanAllDeletedRows scans entire data directory for deleted rows
+func ScanAllDeletedRows(dataDir string, opts *Options) (*DumpResult, error) {
+	withDeleted := *withDefaults(opts)
+	withDeleted.IncludeDeleted = true
+	return DumpDataDir(dataDir, &withDeleted)
 }
 
 // ReadRowsWithDeleted returns both visible and deleted rows separately
 func ReadRowsWithDeleted(data []byte, columns []Column) (visible []map[string]interface{}, deleted []map[string]interface{}) {
-	for _, entry := range ReadTuples(data, false) {
+	var d Decoder
+	return d.ReadRowsWithDeleted(data, columns)
+}
+
+// ReadRowsWithDeleted returns visible rows and the non-vacuumed rows that
+// are not, the latter tagged with their status in StatusColumn
+func (d *Decoder) ReadRowsWithDeleted(data []byte, columns []Column) (visible []map[string]interface{}, deleted []map[string]interface{}) {
+	for _, entry := range d.tuples(data) {
 		tuple := entry.Tuple
 		if tuple == nil {
 			continue
 		}
 
-		row := DecodeTuple(tuple, columns)
+		status := d.entryStatus(entry)
+		if status == "" {
+			continue
+		}
+		row := d.decodeEntry(entry, columns)
 		if row == nil {
 			continue
 		}
 
-		if tuple.IsVisible() {
+		if status == StatusLive {
 			visible = append(visible, row)
-		} else if tuple.Header.XmaxCommitted && !tuple.Header.XmaxInvalid {
-			// Deleted but not vacuumed
+		} else {
+			row[StatusColumn] = status
+			if entry.Found != FoundNormal {
+				row[FoundColumn] = entry.Found
+			}
 			deleted = append(deleted, row)
 		}
 	}
@@ -185,8 +185,7 @@ func expandPath(path string) string {
 
 // ListDatabases returns databases found in data directory (quick scan)
 func ListDatabases(dataDir string) []DatabaseInfo {
-	pgDatabase := filepath.Join(dataDir, "global", "1262")
-	data, err := os.ReadFile(pgDatabase)
+	data, err := readGlobalCatalog(dataDir, PGDatabase)
 	if err != nil {
 		return nil
 	}
@@ -268,7 +268,7 @@ func RecoverDroppedColumnData(dataDir, dbName, tableName string, attNum int) (*D
 	}
 	
 	// Read table data
-	tableData, err := os.ReadFile(filepath.Join(basePath, strconv.FormatUint(uint64(tableInfo.Filenode), 10)))
+	tableData, err := ReadRelationFile(filepath.Join(basePath, strconv.FormatUint(uint64(tableInfo.Filenode), 10)))
 	if err != nil {
 		return nil, fmt.Errorf("cannot read table data: %w", err)
 	}
@@ -448,3 +448,106 @@ func GetDroppedColumnSchema(dataDir, dbName, tableName string) ([]Column, error)
 	attrs := parseAllAttributes(attrData, tableOID)
 	return buildColumnsWithDropped(attrs), nil
 }
+
+// DroppedTablesResult contains the dropped tables recovered from a database
+type DroppedTablesResult struct {
+	Database string         `json:"database"`
+	Tables   []DroppedTable `json:"tables"`
+}
+
+// DroppedTable is a table rebuilt from dead pg_class and pg_attribute
+// rows, with the rows of its heap file when that survives
+type DroppedTable struct {
+	TableDump
+	DroppedBy uint32 `json:"dropped_by,omitempty"` // xmax of the dead pg_class row
+	File      string `json:"file"`
+	Found     bool   `json:"found"` // heap file still on disk
+}
+
+// RecoverDroppedTables rebuilds the tables of a database whose catalog
+// rows are dead but not yet vacuumed, and dumps their surviving heap
+// files. Relations still live under the same OID (TRUNCATE, rewrites)
+// are left to FindOrphanFiles
+func RecoverDroppedTables(dataDir, dbName string) (*DroppedTablesResult, error) {
+	dbOID, err := lookupDatabase(dataDir, dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	xact := commitLogFor(OpenCommitLog(dataDir), VisibilityAuto)
+	globalMap := loadRelMap(os.ReadFile(filepath.Join(dataDir, "global", "pg_filenode.map")))
+	rels, err := openRelations(dataDir, dbOID, xact, globalMap)
+	if err != nil {
+		return nil, err
+	}
+
+	// Dead TOAST relations stay reachable through the dropped table's
+	// reltoastrelid
+	tables := make(map[uint32]TableInfo)
+	liveOIDs := make(map[uint32]bool)
+	for _, info := range rels.live {
+		tables[info.Filenode] = info
+		liveOIDs[info.OID] = true
+	}
+	for _, rel := range rels.dead {
+		if _, ok := tables[rel.info.Filenode]; !ok && !liveOIDs[rel.OID] {
+			tables[rel.info.Filenode] = rel.info
+		}
+	}
+	reader := rels.loc.reader(tables, os.ReadFile)
+	dec := &Decoder{Xact: xact, TOAST: NewTOASTReaderFromFiles(tables, reader, xact)}
+	opts := withDefaults(nil)
+
+	result := &DroppedTablesResult{Database: dbName}
+	for path, rel := range rels.dead {
+		if rel.Kind != "r" || liveOIDs[rel.OID] {
+			continue
+		}
+		table := DroppedTable{
+			TableDump: dumpTable(rel.info.Filenode, rel.info, rel.attrs, reader, dec, opts),
+			DroppedBy: rel.Xmax,
+			File:      path,
+		}
+		if _, err := os.Stat(path); err == nil {
+			table.Found = true
+		}
+		result.Tables = append(result.Tables, table)
+	}
+	sort.Slice(result.Tables, func(i, j int) bool { return result.Tables[i].Filenode < result.Tables[j].Filenode })
+	return result, nil
+}
+
+// lookupDatabase returns the OID of a database from pg_database
+func lookupDatabase(dataDir, dbName string) (uint32, error) {
+	dbData, err := readGlobalCatalog(dataDir, PGDatabase)
+	if err != nil {
+		return 0, fmt.Errorf("cannot read pg_database: %w", err)
+	}
+	for _, db := range ParsePGDatabase(dbData) {
+		if db.Name == dbName {
+			return db.OID, nil
+		}
+	}
+	return 0, fmt.Errorf("database %q not found", dbName)
+}
+
+// ScanDroppedTables recovers dropped tables in all non-template databases
+func ScanDroppedTables(dataDir string) ([]DroppedTablesResult, error) {
+	dbData, err := readGlobalCatalog(dataDir, PGDatabase)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []DroppedTablesResult
+	for _, db := range ParsePGDatabase(dbData) {
+		if strings.HasPrefix(db.Name, "template") {
+			continue
+		}
+		result, err := RecoverDroppedTables(dataDir, db.Name)
+		if err != nil || len(result.Tables) == 0 {
+			continue
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
@@ -1,6 +1,9 @@
 package pgdump
 
 import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
 	"regexp"
 	"testing"
 )
@@ -211,3 +214,89 @@ func TestDroppedColumnDataStruct(t *testing.T) {
 		t.Error("Values[2] should be nil")
 	}
 }
+
+// nameDatum pads s to a 64-byte name
+func nameDatum(s string) []byte {
+	b := make([]byte, 64)
+	copy(b, s)
+	return b
+}
+
+// catalogTuple encodes a catalog row; columns missing from values are zero
+func catalogTuple(xmin, xmax uint32, infomask uint16, columns []Column, values map[string][]byte) []byte {
+	data, bitmap := makeRowData(columns, values)
+	return makeHeapTuple(xmin, xmax, infomask, len(columns), bitmap, data)
+}
+
+// writeFiles creates files under dir
+func writeFiles(t *testing.T, dir string, files map[string][]byte) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRecoverDroppedTables(t *testing.T) {
+	le16 := func(v uint16) []byte { return binary.LittleEndian.AppendUint16(nil, v) }
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	live := uint16(HeapXminCommitted | HeapXmaxInvalid)
+	dead := uint16(HeapXminCommitted | HeapXmaxCommitted)
+	class := func(xmax uint32, infomask uint16, oid, filenode uint32, name string) []byte {
+		return catalogTuple(100, xmax, infomask, schemaPGClass, map[string][]byte{
+			"oid": le32(oid), "relname": nameDatum(name), "relnamespace": le32(2200),
+			"relfilenode": le32(filenode), "relkind": {'r'},
+		})
+	}
+	attr := func(xmax uint32, infomask uint16, relid uint32, name string, num uint16) []byte {
+		return catalogTuple(100, xmax, infomask, schemaPGAttrV15, map[string][]byte{
+			"attrelid": le32(relid), "attname": nameDatum(name), "atttypid": le32(OidInt4),
+			"attlen": le16(4), "attnum": le16(num), "attbyval": {1}, "attalign": {'i'},
+		})
+	}
+	row := func(id, amount uint32) []byte {
+		return makeHeapTuple(110, 0, live, 2, nil, append(le32(id), le32(amount)...))
+	}
+
+	dataDir := t.TempDir()
+	writeFiles(t, dataDir, map[string][]byte{
+		"global/1262": makeHeapPage(catalogTuple(1, 0, live, schemaPGDatabase, map[string][]byte{
+			"oid": le32(16384), "datname": nameDatum("shop"),
+		})),
+		"base/16384/1259": makeHeapPage(
+			class(0, live, 16385, 16388, "users"),
+			class(115, dead, 16385, 16385, "users"), // old filenode before TRUNCATE
+			class(120, dead, 16390, 16390, "orders"),
+		),
+		"base/16384/1249": makeHeapPage(
+			attr(0, live, 16385, "id", 1),
+			attr(120, dead, 16390, "id", 1),
+			attr(120, dead, 16390, "amount", 2),
+		),
+		"base/16384/16390": makeHeapPage(row(1, 250), row(2, 990)),
+	})
+
+	result, err := RecoverDroppedTables(dataDir, "shop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Tables) != 1 {
+		t.Fatalf("recovered %d tables, want orders only: %+v", len(result.Tables), result.Tables)
+	}
+	orders := result.Tables[0]
+	if orders.Name != "orders" || orders.DroppedBy != 120 || !orders.Found || len(orders.Columns) != 2 {
+		t.Errorf("orders = %+v", orders)
+	}
+	if orders.RowCount != 2 || orders.Rows[1]["amount"] != int32(990) {
+		t.Errorf("orders rows = %v", orders.Rows)
+	}
+
+	if _, err := RecoverDroppedTables(dataDir, "missing"); err == nil {
+		t.Error("RecoverDroppedTables found a missing database")
+	}
+}
@@ -2,6 +2,9 @@ package pgdump
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
 	"regexp"
 	"strings"
 	"testing"
@@ -51,6 +54,111 @@ func TestReadRowsWithDeleted(t *testing.T) {
 	}
 }
 
+func TestDecoderReadRowsWithDeleted(t *testing.T) {
+	xact := make([]byte, PageSize)
+	setXactStatus(xact, 100, XactCommitted)
+	setXactStatus(xact, 101, XactCommitted)
+	setXactStatus(xact, 102, XactAborted)
+	dec := &Decoder{Xact: testCommitLog(map[string][]byte{"pg_xact/0000": xact})}
+
+	columns := []Column{{Name: "id", TypID: OidInt4, Len: 4, Align: 'i', Num: 1}}
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	page := makeHeapPage(
+		makeHeapTuple(100, 0, HeapXmaxInvalid, 1, nil, le32(1)),
+		makeHeapTuple(100, 101, 0, 1, nil, le32(2)),
+		makeHeapTuple(102, 0, HeapXmaxInvalid, 1, nil, le32(3)),
+		makeHeapTuple(103, 0, HeapXmaxInvalid, 1, nil, le32(4)),
+	)
+
+	visible, deleted := dec.ReadRowsWithDeleted(page, columns)
+	if len(visible) != 1 || visible[0]["id"] != int32(1) || visible[0][StatusColumn] != nil {
+		t.Errorf("visible = %v", visible)
+	}
+	want := []string{StatusDeleted, StatusAbortedInsert, StatusUncommitted}
+	if len(deleted) != len(want) {
+		t.Fatalf("deleted = %v", deleted)
+	}
+	for i, status := range want {
+		if deleted[i]["id"] != int32(i+2) || deleted[i][StatusColumn] != status {
+			t.Errorf("deleted[%d] = %v, want id %d %s", i, deleted[i], i+2, status)
+		}
+	}
+
+	// A snapshot before xid 101 sees row 2 live and never saw rows 3 and 4
+	dec.AsOf = 100
+	visible, deleted = dec.ReadRowsWithDeleted(page, columns)
+	if len(visible) != 2 || len(deleted) != 0 {
+		t.Errorf("as of 100: visible = %v, deleted = %v", visible, deleted)
+	}
+}
+
+func TestDecoderReadRowsWithDeletedTOAST(t *testing.T) {
+	xact := make([]byte, PageSize)
+	setXactStatus(xact, 100, XactCommitted)
+	setXactStatus(xact, 101, XactCommitted)
+	setXactStatus(xact, 102, XactAborted)
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	chunk := func(xmin, xmax uint32, infomask uint16, data string) []byte {
+		return makeHeapTuple(xmin, xmax, infomask, 3, nil, append(append(le32(7), le32(0)...), makeVarlena([]byte(data))...))
+	}
+
+	// 101 deleted the row and, with it, its TOAST value. An aborted
+	// insert reused the same chunk key
+	value := "deleted but not vacuumed"
+	deleted := uint16(HeapXminCommitted | HeapXmaxCommitted)
+	toast := NewTOASTReader()
+	toast.LoadTOASTTable(16400, makeHeapPage(
+		chunk(102, 0, HeapXminInvalid|HeapXmaxInvalid, "aborted"),
+		chunk(100, 101, deleted, value),
+	))
+	page := makeHeapPage(makeHeapTuple(100, 101, deleted, 1, nil,
+		makeTOASTPointer(uint32(len(value)+4), uint32(len(value)), 7, 16400)))
+
+	dec := &Decoder{Xact: testCommitLog(map[string][]byte{"pg_xact/0000": xact}), TOAST: toast}
+	columns := []Column{{Name: "doc", TypID: OidText, Len: -1, Align: 'i', Num: 1}}
+	visible, recovered := dec.ReadRowsWithDeleted(page, columns)
+	if len(visible) != 0 || len(recovered) != 1 || recovered[0]["doc"] != value {
+		t.Errorf("visible = %v, deleted = %v; want doc %q recovered", visible, recovered, value)
+	}
+}
+
+func TestDecoderAsOfTOAST(t *testing.T) {
+	xact := make([]byte, PageSize)
+	setXactStatus(xact, 100, XactCommitted)
+	setXactStatus(xact, 120, XactCommitted)
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	chunk := func(xmin, xmax, valueID uint32, data string) []byte {
+		return makeHeapTuple(xmin, xmax, 0, 3, nil, append(append(le32(valueID), le32(0)...), makeVarlena([]byte(data))...))
+	}
+	pointer := func(valueID uint32, data string) []byte {
+		return makeTOASTPointer(uint32(len(data)+4), uint32(len(data)), valueID, 16400)
+	}
+
+	// 120 updated the row, deleting value 7 and storing value 8
+	before, after := "the value before the update", "the value after the update"
+	toast := NewTOASTReader()
+	toast.LoadTOASTTable(16400, makeHeapPage(chunk(100, 120, 7, before), chunk(120, 0, 8, after)))
+	page := makeHeapPage(
+		makeHeapTuple(100, 120, HeapUpdated, 1, nil, pointer(7, before)),
+		makeHeapTuple(120, 0, 0, 1, nil, pointer(8, after)),
+	)
+
+	columns := []Column{{Name: "doc", TypID: OidText, Len: -1, Align: 'i', Num: 1}}
+	dec := &Decoder{Xact: testCommitLog(map[string][]byte{"pg_xact/0000": xact}), TOAST: toast}
+	for _, tt := range []struct {
+		asOf uint32
+		want string
+	}{
+		{110, before},
+		{0, after},
+	} {
+		dec.AsOf = tt.asOf
+		if rows := dec.ReadRows(page, columns, true); len(rows) != 1 || rows[0]["doc"] != tt.want {
+			t.Errorf("as of %d: rows = %v, want doc %q", tt.asOf, rows, tt.want)
+		}
+	}
+}
+
 // === TOAST Tests ===
 
 func TestIsTOASTPointer(t *testing.T) {
@@ -61,8 +169,9 @@ func TestIsTOASTPointer(t *testing.T) {
 	}{
 		{"empty", []byte{}, false},
 		{"short", []byte{0x05}, false},
-		{"external", []byte{0x01, 0x00}, true},
-		{"compressed external", []byte{0x02, 0x00}, true},
+		{"external ondisk", []byte{0x01, VarTagOnDisk}, true},
+		{"unknown vartag", []byte{0x01, 0x00}, false},
+		{"inline compressed", []byte{0x02, 0x00}, false},
 		{"normal varlena", []byte{0x05, 'h', 'i'}, false},
 	}
 
@@ -84,10 +193,230 @@ func TestParseTOASTPointer(t *testing.T) {
 	// Valid pointer (minimal)
 	data := make([]byte, 20)
 	data[0] = 0x01 // external
+	data[1] = VarTagOnDisk
 	ptr := ParseTOASTPointer(data)
 	if ptr == nil {
 		t.Error("Expected non-nil pointer")
 	}
+
+	// Compressed lz4 pointer: rawsize 1004, extsize 100 with method 1
+	data = makeTOASTPointer(1004, 100|ToastCompressionLZ4<<30, 42, 16400)
+	ptr = ParseTOASTPointer(data)
+	if ptr == nil {
+		t.Fatal("Expected non-nil pointer")
+	}
+	if ptr.RawSize != 1004 || ptr.ExtSize != 100 || ptr.ValueID != 42 || ptr.ToastRelID != 16400 {
+		t.Errorf("ParseTOASTPointer = %+v", ptr)
+	}
+	if !ptr.IsCompressed || ptr.CompressionMethod != ToastCompressionLZ4 {
+		t.Errorf("expected compressed lz4 pointer, got %+v", ptr)
+	}
+}
+
+func makeTOASTPointer(rawSize, extInfo, valueID, toastRelID uint32) []byte {
+	data := make([]byte, 18)
+	data[0] = 0x01
+	data[1] = VarTagOnDisk
+	binary.LittleEndian.PutUint32(data[2:], rawSize)
+	binary.LittleEndian.PutUint32(data[6:], extInfo)
+	binary.LittleEndian.PutUint32(data[10:], valueID)
+	binary.LittleEndian.PutUint32(data[14:], toastRelID)
+	return data
+}
+
+func TestDecompressPGLZ(t *testing.T) {
+	// "abcabcabcabc": 3 literals then a back-reference (offset 3, length 9)
+	data := []byte{0x08, 'a', 'b', 'c', 0x06, 0x03}
+	got, err := decompressPGLZ(data, 12)
+	if err != nil {
+		t.Fatalf("decompressPGLZ error: %v", err)
+	}
+	if string(got) != "abcabcabcabc" {
+		t.Errorf("decompressPGLZ = %q, want %q", got, "abcabcabcabc")
+	}
+}
+
+func TestDecoderResolvesTOAST(t *testing.T) {
+	value := "out-of-line value"
+	chunks := []TOASTChunk{
+		{ChunkID: 7, ChunkSeq: 1, Data: []byte(value[8:])},
+		{ChunkID: 7, ChunkSeq: 0, Data: []byte(value[:8])},
+	}
+	toast := NewTOASTReader()
+	toast.chunks[16400] = chunks
+
+	ptr := makeTOASTPointer(uint32(len(value)+4), uint32(len(value)), 7, 16400)
+	tuple := &HeapTupleData{
+		Header: &HeapTupleHeader{Natts: 2},
+		Data:   append(append([]byte{}, ptr...), 0, 0, 0x2A, 0, 0, 0), // pad to 4, then int4
+	}
+	columns := []Column{
+		{Name: "doc", TypID: OidText, Len: -1, Align: 'i', Num: 1},
+		{Name: "id", TypID: OidInt4, Len: 4, Align: 'i', Num: 2},
+	}
+
+	// Without a TOAST reader the value is nil but later columns stay aligned
+	row := DecodeTuple(tuple, columns)
+	if row["doc"] != nil || row["id"] != int32(42) {
+		t.Errorf("DecodeTuple = %v", row)
+	}
+
+	dec := &Decoder{TOAST: toast}
+	row = dec.DecodeTuple(tuple, columns)
+	if row["doc"] != value {
+		t.Errorf("doc = %v, want %q", row["doc"], value)
+	}
+	if row["id"] != int32(42) {
+		t.Errorf("id = %v, want 42", row["id"])
+	}
+}
+
+// testTypeRegistry describes: enum app.mood, domain posint over int4,
+// composite pair (x int4, label text) and the arrays of mood and pair
+func testTypeRegistry() *TypeRegistry {
+	return &TypeRegistry{
+		Types: map[uint32]TypeInfo{
+			OidInt4: {OID: OidInt4, Name: "int4", Len: 4, Align: 'i', Kind: 'b'},
+			16500:   {OID: 16500, Name: "mood", Schema: "app", Len: 4, Align: 'i', Kind: 'e'},
+			16501:   {OID: 16501, Name: "_mood", Schema: "app", Len: -1, Align: 'i', Kind: 'b', Category: 'A', Elem: 16500},
+			16510:   {OID: 16510, Name: "posint", Schema: "public", Len: 4, Align: 'i', Kind: 'd', BaseType: OidInt4},
+			16520:   {OID: 16520, Name: "pair", Schema: "public", Len: -1, Align: 'd', Kind: 'c', RelID: 16519},
+			16521:   {OID: 16521, Name: "_pair", Schema: "public", Len: -1, Align: 'd', Kind: 'b', Category: 'A', Elem: 16520},
+		},
+		Enums: map[uint32]EnumInfo{
+			16502: {OID: 16502, TypeOID: 16500, SortOrder: 2, Label: "happy"},
+			16503: {OID: 16503, TypeOID: 16500, SortOrder: 1, Label: "sad"},
+		},
+		Attrs: map[uint32][]AttrInfo{
+			16519: {
+				{Name: "x", TypID: OidInt4, Num: 1, Len: 4, Align: 'i'},
+				{Name: "label", TypID: OidText, Num: 2, Len: -1, Align: 'i'},
+			},
+		},
+	}
+}
+
+// makeVarlena prefixes payload with a 4-byte varlena header
+func makeVarlena(payload []byte) []byte {
+	data := make([]byte, 4, 4+len(payload))
+	binary.LittleEndian.PutUint32(data, uint32(4+len(payload))<<2)
+	return append(data, payload...)
+}
+
+// makeRecord builds a composite datum payload: a 23-byte tuple header
+// (the first word being the stripped varlena length) padded to t_hoff
+func makeRecord(natts int, fields []byte) []byte {
+	hdr := make([]byte, 20) // 24-byte header minus the varlena length word
+	binary.LittleEndian.PutUint16(hdr[14:], uint16(natts))
+	hdr[18] = 24
+	return append(hdr, fields...)
+}
+
+// makeArray builds a one-dimensional array payload without nulls
+func makeArray(elemOid uint32, elems ...[]byte) []byte {
+	data := make([]byte, 20)
+	binary.LittleEndian.PutUint32(data[0:], 1)
+	binary.LittleEndian.PutUint32(data[8:], elemOid)
+	binary.LittleEndian.PutUint32(data[12:], uint32(len(elems)))
+	binary.LittleEndian.PutUint32(data[16:], 1)
+	for _, e := range elems {
+		data = append(data, e...)
+	}
+	return data
+}
+
+func TestDecoderUserTypes(t *testing.T) {
+	reg := testTypeRegistry()
+	dec := &Decoder{Types: reg}
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+
+	// (x=7, label='hi'); text is a short varlena right after x
+	record := makeRecord(2, append(le32(7), 0x07, 'h', 'i'))
+	// Composite elements are 'd' aligned: each datum is padded to 8
+	elem1 := append(makeVarlena(record), make([]byte, 1)...)
+	elem2 := makeVarlena(makeRecord(2, append(le32(8), 0x07, 'o', 'k')))
+
+	tests := []struct {
+		name  string
+		typID int
+		data  []byte
+		want  string
+	}{
+		{"enum", 16500, le32(16503), "sad"},
+		{"unknown enum oid", 16500, le32(99), "99"},
+		{"domain", 16510, le32(42), "42"},
+		{"composite", 16520, record, "map[label:hi x:7]"},
+		{"enum array", 16501, makeArray(16500, le32(16502), le32(16503)), "[happy sad]"},
+		{"composite array", 16521, makeArray(16520, elem1, elem2), "[map[label:hi x:7] map[label:ok x:8]]"},
+		{"builtin", OidInt4, le32(5), "5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fmt.Sprint(dec.decode(tt.data, tt.typID)); got != tt.want {
+				t.Errorf("decode = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Without a registry user types fall back to raw decoding
+	var plain Decoder
+	if got := plain.decode(le32(16503), 16500); got == "sad" {
+		t.Error("zero Decoder should not resolve enums")
+	}
+}
+
+func TestTypeRegistryNames(t *testing.T) {
+	reg := testTypeRegistry()
+	tests := []struct {
+		oid  int
+		want string
+	}{
+		{16500, "app.mood"},
+		{16501, "app.mood[]"},
+		{16510, "posint"},
+		{16521, "pair[]"},
+		{1007, "int4[]"},
+		{99999, "oid:99999"},
+	}
+	for _, tt := range tests {
+		if got := reg.TypeName(tt.oid); got != tt.want {
+			t.Errorf("TypeName(%d) = %q, want %q", tt.oid, got, tt.want)
+		}
+	}
+
+	defs := reg.Definitions([]int{16521, 16501, 16510, OidText})
+	if len(defs) != 3 {
+		t.Fatalf("Definitions = %+v, want pair, mood and posint", defs)
+	}
+	if defs[0].Kind != "composite" || defs[0].ArrayTypID != 16521 || len(defs[0].Attributes) != 2 {
+		t.Errorf("pair = %+v", defs[0])
+	}
+	if defs[1].Kind != "enum" || strings.Join(defs[1].Labels, ",") != "sad,happy" {
+		t.Errorf("mood = %+v, want labels in sort order", defs[1])
+	}
+	if defs[2].Kind != "domain" || defs[2].BaseType != "int4" {
+		t.Errorf("posint = %+v", defs[2])
+	}
+}
+
+func TestTOASTReaderFromFiles(t *testing.T) {
+	tables := map[uint32]TableInfo{
+		20000: {OID: 16400, Filenode: 20000, Name: "pg_toast_16384", Kind: "t"},
+	}
+	var requested []uint32
+	r := NewTOASTReaderFromFiles(tables, func(fn uint32) ([]byte, error) {
+		requested = append(requested, fn)
+		return nil, os.ErrNotExist
+	}, nil)
+
+	ptr := makeTOASTPointer(14, 10, 1, 16400)
+	if got := r.ReadValue(ptr); got != nil {
+		t.Errorf("ReadValue = %v, want nil for missing relation", got)
+	}
+	r.ReadValue(ptr)
+	if len(requested) != 1 || requested[0] != 20000 {
+		t.Errorf("requested filenodes = %v, want [20000] (loaded once)", requested)
+	}
 }
 
 func TestReadTOASTTable(t *testing.T) {
@@ -228,6 +557,22 @@ func TestTableToCSV(t *testing.T) {
 	}
 }
 
+func TestTableToCSVDeletedRows(t *testing.T) {
+	table := TableDump{
+		Name:        "users",
+		Columns:     []ColumnInfo{{Name: "id", Type: "int4", TypID: OidInt4}},
+		Rows:        []map[string]interface{}{{"id": int32(1)}},
+		DeletedRows: []map[string]interface{}{{"id": int32(2), StatusColumn: StatusDeleted}},
+	}
+	var buf bytes.Buffer
+	if err := table.ToCSV(&buf); err != nil {
+		t.Fatalf("ToCSV failed: %v", err)
+	}
+	if got, want := buf.String(), "id,_status\n1,live\n2,deleted\n"; got != want {
+		t.Errorf("ToCSV = %q, want %q", got, want)
+	}
+}
+
 func TestFormatCSVValue(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -384,6 +729,9 @@ func TestFormatLSN(t *testing.T) {
 		if got := FormatLSN(tt.lsn); got != tt.expect {
 			t.Errorf("FormatLSN(0x%016X) = %s, want %s", tt.lsn, got, tt.expect)
 		}
+		if got, err := ParseLSN(tt.expect); err != nil || got != tt.lsn {
+			t.Errorf("ParseLSN(%q) = 0x%016X, %v", tt.expect, got, err)
+		}
 	}
 }
 
@@ -421,21 +769,21 @@ func TestIsZeroPadding(t *testing.T) {
 
 func TestParseBlockRefs(t *testing.T) {
 	// Empty data
-	blocks := parseBlockRefs([]byte{})
-	if len(blocks) != 0 {
-		t.Errorf("Expected 0 blocks for empty data, got %d", len(blocks))
+	rec := &WALRecord{}
+	if err := decodeRecordBody(rec, []byte{}, 16); err != nil || len(rec.Blocks) != 0 {
+		t.Errorf("Expected 0 blocks for empty data, got %d (%v)", len(rec.Blocks), err)
 	}
 
-	// End marker
-	blocks = parseBlockRefs([]byte{0xFF})
-	if len(blocks) != 0 {
-		t.Errorf("Expected 0 blocks for end marker, got %d", len(blocks))
+	// Main data header without its length
+	rec = &WALRecord{}
+	if err := decodeRecordBody(rec, []byte{0xFF}, 16); err == nil || len(rec.Blocks) != 0 {
+		t.Errorf("Expected an error for a truncated main data header, got %d blocks", len(rec.Blocks))
 	}
 
 	// Invalid block ID
-	blocks = parseBlockRefs([]byte{0x50})
-	if len(blocks) != 0 {
-		t.Errorf("Expected 0 blocks for invalid block ID, got %d", len(blocks))
+	rec = &WALRecord{}
+	if err := decodeRecordBody(rec, []byte{0x50}, 16); err == nil || len(rec.Blocks) != 0 {
+		t.Errorf("Expected an error for invalid block ID, got %d blocks", len(rec.Blocks))
 	}
 }
 
@@ -453,3 +801,56 @@ func TestWALSummaryFields(t *testing.T) {
 		t.Error("Missing INSERT operations")
 	}
 }
+
+func TestResolveSchemas(t *testing.T) {
+	tables := map[uint32]TableInfo{
+		16384: {OID: 16384, Filenode: 16384, Name: "users", Namespace: 2200},
+		16390: {OID: 16390, Filenode: 16390, Name: "users", Namespace: 16389},
+		20000: {OID: PGNamespace, Filenode: 20000, Name: "pg_namespace", Namespace: 11},
+	}
+	var requested uint32
+	ResolveSchemas(tables, func(fn uint32) ([]byte, error) {
+		requested = fn
+		return nil, os.ErrNotExist
+	}, nil)
+	if requested != 20000 {
+		t.Errorf("pg_namespace read from filenode %d, want 20000", requested)
+	}
+
+	if got := qualifiedName("audit", "users"); got != "audit.users" {
+		t.Errorf("qualifiedName = %q, want audit.users", got)
+	}
+	c := NewRemoteClient(func(string) ([]byte, error) { return nil, os.ErrNotExist })
+	c.cache.tables[1] = map[uint32]TableInfo{
+		16384: {OID: 16384, Name: "users", Schema: "audit"},
+		16390: {OID: 16390, Name: "users", Schema: "public"},
+		16400: {OID: 16400, Name: "events", Schema: "audit"},
+		16410: {OID: 16410, Name: "events", Schema: "archive"},
+	}
+	for _, tt := range []struct {
+		name string
+		oid  uint32
+	}{
+		{"users", 16390},
+		{"audit.users", 16384},
+		{"archive.events", 16410},
+		{"events", 0}, // in two schemas, neither public
+		{"missing", 0},
+	} {
+		table, err := c.Table(1, tt.name)
+		if tt.oid == 0 && err == nil || tt.oid != 0 && (err != nil || table.OID != tt.oid) {
+			t.Errorf("Table(%q) = %+v, %v; want OID %d", tt.name, table, err, tt.oid)
+		}
+	}
+	// Without pg_namespace every schema is unknown
+	c.cache.tables[2] = map[uint32]TableInfo{
+		16410: {OID: 16410, Name: "events"},
+		16400: {OID: 16400, Name: "events"},
+	}
+	if table, err := c.Table(2, "events"); err != nil || table.OID != 16400 {
+		t.Errorf("Table(events) without schemas = %+v, %v; want OID 16400", table, err)
+	}
+	if !isSystemSchema("pg_catalog") || !isSystemSchema("pg_toast_temp_3") || isSystemSchema("public") {
+		t.Error("isSystemSchema misclassified a schema")
+	}
+}
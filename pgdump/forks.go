@@ -0,0 +1,149 @@
+package pgdump
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+)
+
+// Visibility map layout (visibilitymap.c): two bits per heap block after
+// the MAXALIGNed page header
+const (
+	vmAllVisible        = 0x01
+	vmAllFrozen         = 0x02
+	vmHeapBlocksPerPage = (PageSize - headerSize) * 4
+)
+
+// Free space map layout (fsmpage.h, freespace.c): a binary tree of
+// one-byte free space categories per page, after fp_next_slot. Leaf
+// pages are interleaved with the upper levels of a three-level tree
+const (
+	fsmNodesPerPage = PageSize - headerSize - 4
+	fsmNonLeafNodes = PageSize/2 - 1
+	fsmSlotsPerPage = fsmNodesPerPage - fsmNonLeafNodes
+	fsmCategoryStep = PageSize / 256
+	fsmLeafOffset   = headerSize + 4 + fsmNonLeafNodes
+)
+
+// VMStatus is the visibility map state of one heap block
+type VMStatus struct {
+	AllVisible bool `json:"all_visible"`
+	AllFrozen  bool `json:"all_frozen"`
+}
+
+// ParseVisibilityMap decodes a _vm fork into the state of each heap
+// block. nblocks limits the result; 0 stops after the last block with a
+// bit set and -1 keeps every block the fork covers
+func ParseVisibilityMap(data []byte, nblocks int) []VMStatus {
+	var result []VMStatus
+	last := -1
+	for page := 0; page*PageSize+PageSize <= len(data); page++ {
+		for i, b := range data[page*PageSize+headerSize : (page+1)*PageSize] {
+			for j := 0; j < 4; j++ {
+				blk := page*vmHeapBlocksPerPage + i*4 + j
+				if nblocks > 0 && blk >= nblocks {
+					return result
+				}
+				bits := b >> (2 * j)
+				result = append(result, VMStatus{
+					AllVisible: bits&vmAllVisible != 0,
+					AllFrozen:  bits&vmAllFrozen != 0,
+				})
+				if bits&(vmAllVisible|vmAllFrozen) != 0 {
+					last = blk
+				}
+			}
+		}
+	}
+	if nblocks == 0 {
+		return result[:last+1]
+	}
+	return result
+}
+
+// ParseFreeSpaceMap decodes a _fsm fork into the free space recorded for
+// each heap block, in bytes rounded down to the 32-byte category. VACUUM
+// records the space it frees, so pages with a large value are where dead
+// tuples were pruned recently, the best places to carve. nblocks limits
+// the result as in ParseVisibilityMap
+func ParseFreeSpaceMap(data []byte, nblocks int) []int {
+	var result []int
+	last := -1
+	for leaf := 0; ; leaf++ {
+		// Physical page of bottom-level page n: n plus the upper-level
+		// pages preceding it (fsm_logical_to_physical)
+		page := leaf + leaf/fsmSlotsPerPage + leaf/(fsmSlotsPerPage*fsmSlotsPerPage) + 2
+		if page*PageSize+PageSize > len(data) {
+			break
+		}
+		leaves := data[page*PageSize+fsmLeafOffset : page*PageSize+fsmLeafOffset+fsmSlotsPerPage]
+		for i, cat := range leaves {
+			blk := leaf*fsmSlotsPerPage + i
+			if nblocks > 0 && blk >= nblocks {
+				return result
+			}
+			result = append(result, int(cat)*fsmCategoryStep)
+			if cat != 0 {
+				last = blk
+			}
+		}
+	}
+	if nblocks == 0 {
+		return result[:last+1]
+	}
+	return result
+}
+
+// DumpBlockRangeWithForks is DumpBlockRange with the visibility map and
+// free space map state of each block, read from the _vm and _fsm forks
+// next to path. Missing forks leave the fields unset
+func DumpBlockRangeWithForks(path string, blockRange *BlockRange) ([]BlockInfo, error) {
+	blocks, err := DumpBlockRange(path, blockRange)
+	if err != nil {
+		return nil, err
+	}
+
+	// Forks cover the whole relation: block numbers of segment N start
+	// after the blocks of segments 0..N-1
+	dir, name := filepath.Split(path)
+	base, first := name, 0
+	if f, ok := parseRelationFileName(name); ok {
+		if f.Fork != ForkMain {
+			return nil, fmt.Errorf("%s is the %s fork, not a main fork", name, f.Fork)
+		}
+		base = strconv.FormatUint(uint64(f.Filenode), 10)
+		if f.Temp {
+			base = fmt.Sprintf("t%d_%s", f.Backend, base)
+		}
+		first = f.Segment * (DefaultSegmentSize / PageSize)
+	}
+
+	var vm []VMStatus
+	if data, err := ReadRelationFile(filepath.Join(dir, base+"_"+ForkVM)); err == nil {
+		vm = ParseVisibilityMap(data, -1)
+	}
+	var fsm []int
+	if data, err := ReadRelationFile(filepath.Join(dir, base+"_"+ForkFSM)); err == nil {
+		fsm = ParseFreeSpaceMap(data, -1)
+	}
+
+	for i := range blocks {
+		b := &blocks[i]
+		blk := first + int(b.BlockNumber)
+		if vm != nil {
+			status := VMStatus{}
+			if blk < len(vm) {
+				status = vm[blk]
+			}
+			b.VM = &status
+		}
+		if fsm != nil {
+			free := 0
+			if blk < len(fsm) {
+				free = fsm[blk]
+			}
+			b.FSMFree = &free
+		}
+	}
+	return blocks, nil
+}
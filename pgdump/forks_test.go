@@ -0,0 +1,70 @@
+package pgdump
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseForks(t *testing.T) {
+	// Block 0 all-visible, block 1 all-visible and all-frozen, block 6
+	// all-visible
+	vm := make([]byte, PageSize)
+	vm[headerSize] = vmAllVisible | (vmAllVisible|vmAllFrozen)<<2
+	vm[headerSize+1] = vmAllVisible << 4
+
+	want := []VMStatus{{true, false}, {true, true}, {}, {}, {}, {}, {true, false}}
+	got := ParseVisibilityMap(vm, 0)
+	if len(got) != len(want) {
+		t.Fatalf("ParseVisibilityMap = %d blocks, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("block %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if got := ParseVisibilityMap(vm, 3); len(got) != 3 {
+		t.Errorf("ParseVisibilityMap(vm, 3) = %d blocks", len(got))
+	}
+	if got := ParseVisibilityMap(vm, -1); len(got) != vmHeapBlocksPerPage {
+		t.Errorf("ParseVisibilityMap(vm, -1) = %d blocks, want %d", len(got), vmHeapBlocksPerPage)
+	}
+
+	// Root, level 1, then the first leaf page: block 1 has 1024 bytes free
+	fsm := make([]byte, 3*PageSize)
+	fsm[2*PageSize+fsmLeafOffset+1] = 1024 / fsmCategoryStep
+	if got := ParseFreeSpaceMap(fsm, 0); len(got) != 2 || got[0] != 0 || got[1] != 1024 {
+		t.Errorf("ParseFreeSpaceMap = %v, want [0 1024]", got)
+	}
+
+	dir := t.TempDir()
+	heap := makeHeapPage(makeHeapTuple(100, 0, HeapXmaxInvalid, 1, nil, []byte{1, 0, 0, 0}))
+	writeFiles(t, dir, map[string][]byte{
+		"16384":     append(append([]byte(nil), heap...), heap...),
+		"16384_vm":  vm,
+		"16384_fsm": fsm,
+	})
+	blocks, err := DumpBlockRangeWithForks(filepath.Join(dir, "16384"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("got %d blocks, want 2", len(blocks))
+	}
+	if b := blocks[1]; b.VM == nil || !b.VM.AllFrozen || b.FSMFree == nil || *b.FSMFree != 1024 {
+		t.Errorf("block 1 = vm %+v fsm %v", b.VM, b.FSMFree)
+	}
+
+	if _, err := DumpBlockRangeWithForks(filepath.Join(dir, "16384_vm"), nil); err == nil {
+		t.Error("DumpBlockRangeWithForks accepted a _vm fork")
+	}
+	os.Remove(filepath.Join(dir, "16384_fsm"))
+	blocks, _ = DumpBlockRangeWithForks(filepath.Join(dir, "16384"), nil)
+	if blocks[0].FSMFree != nil || blocks[0].VM == nil {
+		t.Errorf("without _fsm: block 0 = vm %+v fsm %v", blocks[0].VM, blocks[0].FSMFree)
+	}
+
+	if segs, _ := ListSegments(filepath.Join(dir, "16384_vm")); len(segs) != 1 || segs[0].Fork != ForkVM {
+		t.Errorf("ListSegments(16384_vm) = %+v", segs)
+	}
+}
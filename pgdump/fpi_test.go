@@ -0,0 +1,122 @@
+package pgdump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDecompressImage(t *testing.T) {
+	// pglz: literals "a" and "b", then 4 bytes from 2 back
+	if got, err := decompressPGLZ([]byte{0x04, 'a', 'b', 0x01, 0x02}, 6); err != nil || string(got) != "ababab" {
+		t.Errorf("decompressPGLZ = %q, %v", got, err)
+	}
+	if _, err := decompressPGLZ([]byte{0x01, 0x01, 0x05, 'a'}, 4); err == nil {
+		t.Error("pglz accepted a reference before the output")
+	}
+	if _, err := decompressPGLZ([]byte{0x00, 'a', 'b', 'c', 'd'}, 3); err == nil {
+		t.Error("pglz ignored bytes past the output")
+	}
+
+	// lz4: literals "ab" with a 6-byte match 2 back, then a last literal
+	if got, err := decompressLZ4([]byte{0x22, 'a', 'b', 0x02, 0x00, 0x10, 'c'}, 9); err != nil || string(got) != "ababababc" {
+		t.Errorf("decompressLZ4 = %q, %v", got, err)
+	}
+	if _, err := decompressLZ4([]byte{0x22, 'a', 'b', 0x02, 0x00, 0x10, 'c'}, 8); err == nil {
+		t.Error("lz4 overran the output")
+	}
+
+	if _, err := decompressImage(CompressionPGLZ, []byte{0x00, 'a'}, 2); err == nil {
+		t.Error("decompressImage accepted a short image")
+	}
+}
+
+func TestFullPageImages(t *testing.T) {
+	le16 := func(v uint16) []byte { return binary.LittleEndian.AppendUint16(nil, v) }
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	cat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	page := makeHeapPage(makeHeapTuple(700, 0, HeapXmaxInvalid, 1, nil, le32(42)))
+	setPageLSN(page, 0x1000028)
+	lower, upper := u16(page, 12), u16(page, 14)
+	image := cat(page[:lower], page[upper:])
+
+	// Literal-only encodings: a zero control byte before every 8 bytes
+	// for pglz, one last-literals sequence for lz4
+	var pglz []byte
+	for i := 0; i < len(image); i += 8 {
+		pglz = append(append(pglz, 0), image[i:min(i+8, len(image))]...)
+	}
+	lz4 := []byte{0xF0}
+	for n := len(image) - 15; ; n -= 255 {
+		lz4 = append(lz4, byte(min(n, 255)))
+		if n < 255 {
+			break
+		}
+	}
+	lz4 = append(lz4, image...)
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zst := enc.EncodeAll(image, nil)
+
+	users := RelFileNode{SpcOID: 1663, DbOID: 16384, RelOID: 16385}
+	record := func(block uint32, info uint8, data []byte) []byte {
+		hdr := cat(le16(uint16(len(data))), le16(lower), []byte{bkpImageHasHole | bkpImageApply | info})
+		if info != 0 {
+			hdr = append(hdr, le16(upper-lower)...)
+		}
+		rec := cat(make([]byte, XLogRecordSize),
+			[]byte{0, bkpBlockHasImage}, le16(0), hdr,
+			le32(users.SpcOID), le32(users.DbOID), le32(users.RelOID), le32(block),
+			data)
+		binary.LittleEndian.PutUint32(rec[0:], uint32(len(rec)))
+		binary.LittleEndian.PutUint32(rec[4:], 700)
+		rec[16], rec[17] = XLOG_FPI, RM_XLOG_ID
+		return rec
+	}
+	b := newWALBuilder(DefaultWALSegSize, 1)
+	b.add(record(3, 0, image))
+	b.add(record(4, 0, image))
+	b.add(record(3, bkpImageCompressPGLZ, pglz))
+	b.add(record(3, bkpImageCompressLZ4, lz4))
+	b.add(record(3, bkpImageCompressZSTD, zst))
+	b.add(record(3, bkpImageCompressPGLZ, pglz[:len(pglz)-1]))
+	walDir := filepath.Join(t.TempDir(), "pg_wal")
+	writeFiles(t, walDir, b.files())
+
+	outDir := filepath.Join(t.TempDir(), "pages")
+	images, err := WriteFullPageImages(walDir, outDir, &FPIFilter{Filenode: 16385, Blocks: &BlockRange{Start: 3, End: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressions := []string{"", CompressionPGLZ, CompressionLZ4, CompressionZSTD, CompressionPGLZ}
+	if len(images) != len(compressions) {
+		t.Fatalf("got %d images, want %d", len(images), len(compressions))
+	}
+	for i, img := range images[:4] {
+		if img.Compression != compressions[i] || img.Block != 3 || img.Fork != ForkMain || !img.Apply || img.Error != "" || img.PageLSN != "0/1000028" {
+			t.Errorf("image %d = %+v", i, img)
+			continue
+		}
+		data, err := os.ReadFile(img.Path)
+		if err != nil || !bytes.Equal(data, page) {
+			t.Errorf("image %d: page file differs from the page (%v)", i, err)
+			continue
+		}
+		if tuples := ParsePage(data); len(tuples) != 1 || tuples[0].Tuple.Header.Xmin != 700 {
+			t.Errorf("image %d: ParsePage = %+v", i, tuples)
+		}
+	}
+	if bad := images[4]; bad.Error == "" || bad.Path != "" {
+		t.Errorf("truncated pglz image = %+v", bad)
+	}
+	if filepath.Base(images[0].Path) >= filepath.Base(images[1].Path) {
+		t.Errorf("page files %s and %s do not sort by LSN", images[0].Path, images[1].Path)
+	}
+}
@@ -4,9 +4,13 @@ import "fmt"
 
 // ReadTuples extracts all visible tuples from heap file data
 func ReadTuples(data []byte, visibleOnly bool) []TupleEntry {
+	return readTuples(data, visibleOnly, false)
+}
+
+func readTuples(data []byte, visibleOnly, forensic bool) []TupleEntry {
 	var entries []TupleEntry
 	for off := 0; off+PageSize <= len(data); off += PageSize {
-		for _, e := range ParsePage(data[off : off+PageSize]) {
+		for _, e := range ParsePageItems(data[off : off+PageSize], forensic) {
 			if !visibleOnly || e.Tuple.IsVisible() {
 				e.PageOffset = off
 				entries = append(entries, e)
@@ -18,15 +22,73 @@ func ReadTuples(data []byte, visibleOnly bool) []TupleEntry {
 
 // ReadRows decodes tuples using column schema
 func ReadRows(data []byte, columns []Column, visibleOnly bool) []map[string]interface{} {
+	var d Decoder
+	return d.ReadRows(data, columns, visibleOnly)
+}
+
+// Decoder decodes tuples with per-database context. The zero value
+// decodes values stored in-line only; out-of-line values come back nil.
+type Decoder struct {
+	TOAST *TOASTReader  // resolves external TOAST pointers (nil = skip)
+	Types *TypeRegistry // decodes user-defined types (nil = built-ins only)
+	Xact  *CommitLog    // decides visibility from pg_xact (nil = hint bits)
+	AsOf  uint32        // shows rows as of this xid (0 = current state)
+
+	SystemColumns bool // adds ctid, xmin, xmax, ... to each row
+	DeadItems     bool // also decodes storage behind LP_DEAD/LP_UNUSED items
+
+	// ColumnsAt, when set, returns the columns a tuple with this xmin was
+	// written with, overriding those passed in (see SchemaHistory)
+	ColumnsAt func(xmin uint32) []Column
+}
+
+// ReadRows decodes tuples using column schema
+func (d *Decoder) ReadRows(data []byte, columns []Column, visibleOnly bool) []map[string]interface{} {
 	var rows []map[string]interface{}
-	for _, t := range ReadTuples(data, visibleOnly) {
-		if row := DecodeTuple(t.Tuple, columns); row != nil {
+	for _, t := range d.tuples(data) {
+		if visibleOnly && (t.Found != FoundNormal || !d.IsVisible(t.Tuple)) {
+			continue
+		}
+		if row := d.decodeEntry(t, columns); row != nil {
 			rows = append(rows, row)
 		}
 	}
 	return rows
 }
 
+// tuples returns every tuple of a heap, with dead item storage if enabled
+func (d *Decoder) tuples(data []byte) []TupleEntry {
+	return readTuples(data, false, d.DeadItems)
+}
+
+// IsVisible checks tuple visibility through the commit log when one is
+// set, falling back to hint bits, and against the AsOf snapshot if any
+func (d *Decoder) IsVisible(t *HeapTupleData) bool {
+	switch {
+	case d.AsOf != 0:
+		return d.Xact.VisibleAt(t.Header, d.AsOf)
+	case d.Xact == nil:
+		return t.IsVisible()
+	}
+	return d.Xact.IsVisible(t.Header)
+}
+
+// decodeEntry decodes a tuple, adding its system columns when enabled
+func (d *Decoder) decodeEntry(e TupleEntry, columns []Column) map[string]interface{} {
+	if d.ColumnsAt != nil {
+		if cols := d.ColumnsAt(e.Tuple.Header.Xmin); cols != nil {
+			columns = cols
+		}
+	}
+	row := d.DecodeTuple(e.Tuple, columns)
+	if row != nil && d.SystemColumns {
+		for k, v := range e.SystemColumns() {
+			row[k] = v
+		}
+	}
+	return row
+}
+
 // Debug enables debug output for tuple decoding
 var Debug bool
 // DebugTable filters debug output to specific table name
@@ -34,6 +96,12 @@ var DebugTable string
 
 // DecodeTuple decodes a tuple using column schema
 func DecodeTuple(tuple *HeapTupleData, columns []Column) map[string]interface{} {
+	var d Decoder
+	return d.DecodeTuple(tuple, columns)
+}
+
+// DecodeTuple decodes a tuple using column schema
+func (d *Decoder) DecodeTuple(tuple *HeapTupleData, columns []Column) map[string]interface{} {
 	if tuple == nil || len(tuple.Data) == 0 {
 		return nil
 	}
@@ -47,6 +115,12 @@ func DecodeTuple(tuple *HeapTupleData, columns []Column) map[string]interface{}
 			num = idx + 1
 		}
 
+		// Columns added after the tuple was written are not stored in it
+		if tuple.Header != nil && num > tuple.Header.Natts {
+			result[col.Name] = d.missingValue(col)
+			continue
+		}
+
 		// For varlena types, check if we have a short varlena (1-byte header)
 		// Short varlena only needs 1-byte alignment, not the standard 4-byte
 		colAlign := alignFromChar(col.Align)
@@ -57,7 +131,8 @@ func DecodeTuple(tuple *HeapTupleData, columns []Column) map[string]interface{}
 		// Special handling for varlena: short varlena uses 1-byte alignment
 		if col.Len == -1 && offset < len(tuple.Data) {
 			// Try 1-byte alignment first to check for short varlena
-			if isShortVarlena(tuple.Data[offset:]) {
+			// or an external TOAST pointer (also a 1-byte header)
+			if isShortVarlena(tuple.Data[offset:]) || IsTOASTPointer(tuple.Data[offset:]) {
 				colAlign = 1
 			}
 		}
@@ -83,7 +158,7 @@ func DecodeTuple(tuple *HeapTupleData, columns []Column) map[string]interface{}
 			continue
 		}
 
-		val, consumed := readValue(tuple.Data, offset, col.TypID, col.Len)
+		val, consumed := d.readValue(tuple.Data, offset, col.TypID, col.Len)
 		if Debug {
 			dataPreview := ""
 			if offset < len(tuple.Data) {
@@ -103,6 +178,24 @@ func DecodeTuple(tuple *HeapTupleData, columns []Column) map[string]interface{}
 	return result
 }
 
+// missingValue decodes attmissingval, a one-element array of the column type
+func (d *Decoder) missingValue(col Column) interface{} {
+	if col.Missing == nil {
+		return nil
+	}
+	elemAlign := alignFromChar(col.Align)
+	if elemAlign == 0 {
+		elemAlign = typeAlign(col.TypID, col.Len)
+	}
+	elems := decodeArrayWith(col.Missing, col.Len, elemAlign, func(b []byte) interface{} {
+		return d.decode(b, col.TypID)
+	})
+	if len(elems) == 0 {
+		return nil
+	}
+	return elems[0]
+}
+
 // alignFromChar converts PostgreSQL alignment char to bytes
 func alignFromChar(c byte) int {
 	switch c {
@@ -175,6 +268,11 @@ func typeAlign(typID, length int) int {
 }
 
 func readValue(data []byte, offset, typID, length int) (interface{}, int) {
+	var d Decoder
+	return d.readValue(data, offset, typID, length)
+}
+
+func (d *Decoder) readValue(data []byte, offset, typID, length int) (interface{}, int) {
 	if offset >= len(data) {
 		return nil, 0
 	}
@@ -184,15 +282,18 @@ func readValue(data []byte, offset, typID, length int) (interface{}, int) {
 		if len(remaining) < length {
 			return nil, 0
 		}
-		return DecodeType(remaining[:length], typID), length
+		return d.decode(remaining[:length], typID), length
 	}
 
 	if length == -1 {
 		val, consumed := ReadVarlena(remaining)
+		if val == nil && d.TOAST != nil && ParseTOASTPointer(remaining) != nil {
+			val = d.TOAST.ReadValue(remaining[:consumed])
+		}
 		if val == nil {
 			return nil, max(consumed, 1)
 		}
-		return DecodeType(val, typID), consumed
+		return d.decode(val, typID), consumed
 	}
 
 	// C-string
@@ -0,0 +1,285 @@
+package pgdump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// makeHeapTuple builds an on-disk heap tuple; bitmap may be nil (no NULLs)
+func makeHeapTuple(xmin, xmax uint32, infomask uint16, natts int, bitmap, data []byte) []byte {
+	hoff := align(tupleHeaderSize+len(bitmap), 8)
+	t := make([]byte, hoff, hoff+len(data))
+	binary.LittleEndian.PutUint32(t[0:], xmin)
+	binary.LittleEndian.PutUint32(t[4:], xmax)
+	binary.LittleEndian.PutUint16(t[18:], uint16(natts))
+	if bitmap != nil {
+		infomask |= 0x0001
+		copy(t[tupleHeaderSize:], bitmap)
+	}
+	binary.LittleEndian.PutUint16(t[20:], infomask)
+	t[22] = byte(hoff)
+	return append(t, data...)
+}
+
+// makeHeapPage lays tuples out like PageAddItem: line pointers after the
+// page header, tuple data growing down from the end of the page
+func makeHeapPage(tuples ...[]byte) []byte {
+	page := make([]byte, PageSize)
+	upper := PageSize
+	for i, tup := range tuples {
+		upper = (upper - len(tup)) &^ 7
+		copy(page[upper:], tup)
+		binary.LittleEndian.PutUint32(page[headerSize+i*itemIDSize:], uint32(upper)|1<<15|uint32(len(tup))<<17)
+	}
+	binary.LittleEndian.PutUint16(page[12:], uint16(headerSize+len(tuples)*itemIDSize))
+	binary.LittleEndian.PutUint16(page[14:], uint16(upper))
+	binary.LittleEndian.PutUint16(page[16:], PageSize)
+	binary.LittleEndian.PutUint16(page[18:], PageSize|4)
+	return page
+}
+
+// makeRowData encodes values in column order. Fixed-width columns missing
+// from values are zero; varlena columns missing from values are NULL
+func makeRowData(columns []Column, values map[string][]byte) (data, bitmap []byte) {
+	bitmap = make([]byte, (len(columns)+7)/8)
+	for i, col := range columns {
+		v, ok := values[col.Name]
+		if !ok && col.Len < 0 {
+			continue
+		}
+		bitmap[i/8] |= 1 << (i % 8)
+		if !ok {
+			v = make([]byte, col.Len)
+		}
+		a := alignFromChar(col.Align)
+		if a == 0 {
+			a = typeAlign(col.TypID, col.Len)
+		}
+		for len(data) < align(len(data), a) {
+			data = append(data, 0)
+		}
+		data = append(data, v...)
+	}
+	return data, bitmap
+}
+
+func TestDecodeTupleMissingValues(t *testing.T) {
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	columns := []Column{
+		{Name: "id", TypID: OidInt4, Len: 4, Align: 'i', Num: 1},
+		{Name: "status", TypID: OidText, Len: -1, Align: 'i', Num: 2,
+			Missing: makeArray(OidText, append([]byte{0x0f}, "active"...))},
+		{Name: "score", TypID: OidInt4, Len: 4, Align: 'i', Num: 3, Missing: makeArray(OidInt4, le32(10))},
+		{Name: "note", TypID: OidText, Len: -1, Align: 'i', Num: 4},
+	}
+
+	// Written before status, score and note were added: only id is stored,
+	// and the bytes after it must not be read as later columns
+	old := ParseHeapTuple(makeHeapTuple(100, 0, 0x0900, 1, nil, append(le32(1), 0xde, 0xad, 0xbe, 0xef)))
+	old.Data = old.Data[:4]
+	row := DecodeTuple(old, columns)
+	if row["id"] != int32(1) || row["status"] != "active" || row["score"] != int32(10) || row["note"] != nil {
+		t.Errorf("old tuple = %v", row)
+	}
+
+	// Written after: stored values win over attmissingval
+	data, bitmap := makeRowData(columns, map[string][]byte{
+		"id": le32(2), "status": append([]byte{0x09}, "new"...), "score": le32(20),
+	})
+	row = DecodeTuple(ParseHeapTuple(makeHeapTuple(101, 0, 0x0900, 4, bitmap, data)), columns)
+	if row["id"] != int32(2) || row["status"] != "new" || row["score"] != int32(20) || row["note"] != nil {
+		t.Errorf("new tuple = %v", row)
+	}
+}
+
+func TestParseMissingValues(t *testing.T) {
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	name := func(s string) []byte { return append([]byte(s), make([]byte, 64-len(s))...) }
+	missing := makeVarlena(makeArray(OidInt4, le32(7)))
+
+	layouts := []struct {
+		name    string
+		version int
+		columns []Column
+	}{
+		{"v12", 12, schemaPGAttrFullV12},
+		{"v14", 15, schemaPGAttrFullV14},
+		{"v16", 16, schemaPGAttrFullV16},
+		{"v17", 17, schemaPGAttrFullV17},
+	}
+	for _, l := range layouts {
+		t.Run(l.name, func(t *testing.T) {
+			var tuples [][]byte
+			for num, attname := range []string{"id", "added"} {
+				values := map[string][]byte{
+					"attrelid": le32(16384), "attname": name(attname), "atttypid": le32(OidInt4),
+					"attlen": {4, 0}, "attnum": {byte(num + 1), 0}, "attalign": {'i'},
+				}
+				if attname == "added" {
+					values["atthasmissing"] = []byte{1}
+					values["attmissingval"] = missing
+				}
+				data, bitmap := makeRowData(l.columns, values)
+				tuples = append(tuples, makeHeapTuple(1, 0, 0x0900, len(l.columns), bitmap, data))
+			}
+			page := makeHeapPage(tuples...)
+
+			for _, version := range []int{0, l.version} {
+				got := parseMissingValues(page, version, nil)
+				if len(got) != 1 || got[attKey{16384, 2}] == nil {
+					t.Errorf("parseMissingValues(version %d) = %v", version, got)
+				}
+			}
+
+			attrs := ParsePGAttribute(page, l.version)[16384]
+			if len(attrs) != 2 || attrs[0].Missing != nil || attrs[1].Missing == nil {
+				t.Fatalf("ParsePGAttribute = %+v", attrs)
+			}
+			old := &HeapTupleData{Header: &HeapTupleHeader{Natts: 1}, Data: le32(1)}
+			if row := DecodeTuple(old, columnsFromAttrs(attrs)); row["added"] != int32(7) {
+				t.Errorf("added = %v, want 7", row["added"])
+			}
+		})
+	}
+}
+
+func TestDecoderSystemColumns(t *testing.T) {
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	columns := []Column{{Name: "id", TypID: OidInt4, Len: 4, Align: 'i', Num: 1}}
+
+	// Tuple 2 was HOT-updated into tuple 3 on block 1
+	old := makeHeapTuple(100, 101, HeapXminCommitted|HeapXmaxCommitted|HeapHasVarWidth, 1, nil, le32(1))
+	binary.LittleEndian.PutUint32(old[8:], 7)
+	binary.LittleEndian.PutUint16(old[14:], 1)
+	binary.LittleEndian.PutUint16(old[16:], 3)
+	binary.LittleEndian.PutUint16(old[18:], 1|HeapHotUpdated)
+	frozen := makeHeapTuple(2, 0, HeapXminFrozen|HeapXmaxInvalid, 1, nil, le32(2))
+	data := append(make([]byte, PageSize), makeHeapPage(frozen, old)...)
+
+	dec := &Decoder{SystemColumns: true}
+	rows := dec.ReadRows(data, columns, false)
+	if len(rows) != 2 {
+		t.Fatalf("rows = %v", rows)
+	}
+	if rows[0]["ctid"] != "(1,1)" || rows[1]["ctid"] != "(1,2)" {
+		t.Errorf("ctid = %v, %v; want (1,1), (1,2)", rows[0]["ctid"], rows[1]["ctid"])
+	}
+	if got := fmt.Sprint(rows[0]["t_infomask"]); got != "[XMAX_INVALID XMIN_FROZEN]" {
+		t.Errorf("frozen t_infomask = %s", got)
+	}
+	r := rows[1]
+	if r["xmin"] != uint32(100) || r["xmax"] != uint32(101) || r["cmin"] != uint32(7) || r["t_ctid"] != "(1,3)" {
+		t.Errorf("system columns = %v", r)
+	}
+	if got := fmt.Sprint(r["t_infomask"]); got != "[HAS_VARWIDTH XMIN_COMMITTED XMAX_COMMITTED HOT_UPDATED]" {
+		t.Errorf("t_infomask = %s", got)
+	}
+	if rows := ReadRows(data, columns, false); rows[0]["ctid"] != nil {
+		t.Error("system columns added without Decoder.SystemColumns")
+	}
+}
+
+func TestReadHistory(t *testing.T) {
+	columns := []Column{
+		{Name: "id", TypID: OidInt4, Len: 4, Align: 'i', Num: 1},
+		{Name: "balance", TypID: OidInt4, Len: 4, Align: 'i', Num: 2},
+	}
+	tuple := func(xmin, xmax uint32, infomask uint16, id, balance uint32, ctid [2]uint16, infomask2 uint16) []byte {
+		data := binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, id), balance)
+		tup := makeHeapTuple(xmin, xmax, infomask, 2, nil, data)
+		binary.LittleEndian.PutUint16(tup[14:], ctid[0])
+		binary.LittleEndian.PutUint16(tup[16:], ctid[1])
+		binary.LittleEndian.PutUint16(tup[18:], 2|infomask2)
+		return tup
+	}
+	committed := uint16(HeapXminCommitted)
+	live := committed | HeapXmaxInvalid
+
+	// Block 0: item 1 is the redirect HOT pruning left for row 1, whose
+	// versions 3 -> 4 follow; item 2 is row 2, locked but never updated.
+	// Row 3 moved from block 0 item 5 to block 1 item 1
+	page0 := makeHeapPage(
+		tuple(90, 0, live, 0, 0, [2]uint16{0, 1}, 0),
+		tuple(100, 105, committed|HeapXmaxExclLock|HeapXmaxLockOnly, 2, 20, [2]uint16{0, 2}, 0),
+		tuple(101, 102, committed|HeapXmaxCommitted, 1, 10, [2]uint16{0, 4}, HeapHotUpdated|HeapOnlyTuple),
+		tuple(102, 0, live, 1, 15, [2]uint16{0, 4}, HeapOnlyTuple),
+		tuple(100, 103, committed, 3, 30, [2]uint16{1, 1}, 0),
+	)
+	binary.LittleEndian.PutUint32(page0[headerSize:], 3|lpRedirect<<15)
+	page1 := makeHeapPage(tuple(103, 0, live, 3, 0, [2]uint16{1, 1}, 0))
+
+	history := ReadRowHistory(append(page0, page1...), columns)
+	if len(history) != 3 {
+		t.Fatalf("history = %+v", history)
+	}
+
+	row2 := history[0]
+	if row2.Root != "(0,2)" || len(row2.Versions) != 1 || row2.Versions[0].Xmax != 0 {
+		t.Errorf("locked row = %+v", row2)
+	}
+
+	row1 := history[1]
+	if row1.Root != "(0,1)" || len(row1.Versions) != 2 {
+		t.Fatalf("HOT chain = %+v", row1)
+	}
+	v := row1.Versions[1]
+	if v.Ctid != "(0,4)" || v.Xmin != 102 || !v.HOT || v.Status != StatusLive {
+		t.Errorf("HOT version = %+v", v)
+	}
+	if len(v.Changes) != 1 || v.Changes["balance"] != (ColumnChange{Old: int32(10), New: int32(15)}) {
+		t.Errorf("HOT changes = %v", v.Changes)
+	}
+	if row1.Versions[0].Xmax != 102 || row1.Versions[0].Status != StatusDeleted {
+		t.Errorf("HOT old version = %+v", row1.Versions[0])
+	}
+
+	row3 := history[2]
+	if len(row3.Versions) != 2 || row3.Versions[1].Ctid != "(1,1)" || row3.Versions[1].Changes["balance"].New != int32(0) {
+		t.Errorf("cross-page chain = %+v", row3)
+	}
+}
+
+func TestCarveTuples(t *testing.T) {
+	columns := []Column{
+		{Name: "id", TypID: OidInt4, Len: 4, Align: 'i', Num: 1},
+		{Name: "email", TypID: OidText, Len: -1, Align: 'i', Num: 2},
+	}
+	row := func(xmin uint32, id byte, email string) []byte {
+		data, _ := makeRowData(columns, map[string][]byte{
+			"id": {id, 0, 0, 0}, "email": append([]byte{byte(len(email)+1)<<1 | 1}, email...),
+		})
+		return makeHeapTuple(xmin, 0, HeapXminCommitted|HeapXmaxInvalid|HeapHasVarWidth, 2, nil, data)
+	}
+	live := row(100, 1, "alice@example.com")
+	pruned := row(101, 2, "bob@example.com")
+
+	// The pruned tuple's bytes remain in free space after compaction
+	page := makeHeapPage(live)
+	lower := int(binary.LittleEndian.Uint16(page[12:]))
+	off := align(lower+64, 8)
+	copy(page[off:], pruned)
+	binary.LittleEndian.PutUint16(page[off+16:], 2) // t_ctid (0,2)
+
+	carved := CarveTuples(page, columns)
+	if len(carved) != 1 {
+		t.Fatalf("carved = %+v", carved)
+	}
+	c := carved[0]
+	if c.Offset != off || c.Region != RegionFreeSpace || c.Length != len(pruned) || c.Xmin != 101 {
+		t.Errorf("carved = %+v", c)
+	}
+	if c.Values["id"] != int32(2) || c.Values["email"] != "bob@example.com" || c.Confidence != 1 {
+		t.Errorf("carved values = %v, confidence %v", c.Values, c.Confidence)
+	}
+
+	// A damaged header hides the live tuple from ParsePage but not from carving
+	binary.LittleEndian.PutUint16(page[18:], 0xFFFF)
+	if ParsePage(page) != nil {
+		t.Fatal("ParsePage accepted a damaged header")
+	}
+	carved = CarveTuples(page, columns)
+	if len(carved) != 2 || carved[1].Region != RegionDamagedPage || carved[1].Values["email"] != "alice@example.com" {
+		t.Errorf("damaged page carved = %+v", carved)
+	}
+}
@@ -0,0 +1,178 @@
+package pgdump
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// RowHistory is one logical row: the tuple versions linked by t_ctid,
+// oldest first, until vacuum removes the dead ones
+type RowHistory struct {
+	Root     string       `json:"root"` // ctid indexes point to (the redirect for pruned HOT chains)
+	Versions []RowVersion `json:"versions"`
+}
+
+// RowVersion is one tuple version of a logical row
+type RowVersion struct {
+	Ctid    string                  `json:"ctid"`
+	Xmin    uint32                  `json:"xmin"`           // transaction that wrote this version
+	Xmax    uint32                  `json:"xmax,omitempty"` // transaction that replaced or deleted it
+	Status  string                  `json:"status"`         // see TupleStatus
+	Found   string                  `json:"found"`          // see FoundNormal
+	HOT     bool                    `json:"hot,omitempty"`  // heap-only tuple (HOT update, no new index entries)
+	Values  map[string]interface{}  `json:"values"`
+	Changes map[string]ColumnChange `json:"changes,omitempty"` // columns that differ from the previous version
+}
+
+// ColumnChange is a column value before and after an update
+type ColumnChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// tid is a tuple's physical location
+type tid struct {
+	block uint32
+	item  int
+}
+
+func (t tid) String() string {
+	return fmt.Sprintf("(%d,%d)", t.block, t.item)
+}
+
+// tidIndex maps TIDs to positions in a heap's entries. The TID of an
+// LP_REDIRECT resolves to the tuple it leads to (its Root), as index TIDs
+// and t_ctid links into a pruned HOT chain do in PostgreSQL
+func tidIndex(entries []TupleEntry) map[tid]int {
+	byTID := make(map[tid]int, len(entries))
+	for i, e := range entries {
+		byTID[tid{e.Block(), e.Item}] = i
+		if e.Root != 0 {
+			byTID[tid{e.Block(), e.Root}] = i
+		}
+	}
+	return byTID
+}
+
+// ReadRowHistory groups a heap's tuple versions into update chains
+func ReadRowHistory(data []byte, columns []Column) []RowHistory {
+	var d Decoder
+	return d.ReadHistory(data, columns)
+}
+
+// ReadHistory groups a heap's tuple versions into update chains. A version
+// links to the one its t_ctid points at when that tuple's xmin is the
+// updating xmax, as heap_update leaves them; redirect line pointers left
+// by HOT pruning give the chain's root
+func (d *Decoder) ReadHistory(data []byte, columns []Column) []RowHistory {
+	entries := d.tuples(data)
+	byTID := tidIndex(entries)
+
+	// next[i] is the newer version of entries[i], or -1
+	next := make([]int, len(entries))
+	hasPrev := make([]bool, len(entries))
+	for i, e := range entries {
+		next[i] = -1
+		h := e.Tuple.Header
+		newer := tid{h.CtidBlock, int(h.CtidOffset)}
+		if newer == (tid{e.Block(), e.Item}) {
+			continue
+		}
+		j, ok := byTID[newer]
+		if !ok || j == i {
+			continue
+		}
+		if updater, ok := d.updater(h); ok && entries[j].Tuple.Header.Xmin == updater {
+			next[i] = j
+			hasPrev[j] = true
+		}
+	}
+
+	var history []RowHistory
+	seen := make([]bool, len(entries))
+	for i, e := range entries {
+		if hasPrev[i] {
+			continue
+		}
+		root := tid{e.Block(), e.Item}
+		if e.Root != 0 {
+			root.item = e.Root
+		}
+		row := RowHistory{Root: root.String()}
+		var prev map[string]interface{}
+		for j := i; j >= 0 && !seen[j]; j = next[j] {
+			seen[j] = true
+			v := d.rowVersion(entries[j], columns)
+			if v.Values == nil {
+				break
+			}
+			if prev != nil {
+				v.Changes = diffRows(prev, v.Values)
+			}
+			prev = v.Values
+			row.Versions = append(row.Versions, v)
+		}
+		if len(row.Versions) > 0 {
+			history = append(history, row)
+		}
+	}
+	return history
+}
+
+// rowVersion decodes one tuple version
+func (d *Decoder) rowVersion(e TupleEntry, columns []Column) RowVersion {
+	h := e.Tuple.Header
+	v := RowVersion{
+		Ctid:   e.Ctid(),
+		Xmin:   h.Xmin,
+		Status: d.entryStatus(e),
+		Found:  e.Found,
+		HOT:    h.Infomask2&HeapOnlyTuple != 0,
+		Values: d.decodeEntry(e, columns),
+	}
+	if !xmaxLockOnly(h.Infomask) && h.Infomask&HeapXmaxInvalid == 0 {
+		v.Xmax = h.Xmax
+	}
+	return v
+}
+
+// updater returns the xid that updated or deleted the tuple, resolving a
+// MultiXact xmax to its updating member
+func (d *Decoder) updater(h *HeapTupleHeader) (uint32, bool) {
+	if h.Xmax == InvalidXID || xmaxLockOnly(h.Infomask) {
+		return 0, false
+	}
+	if h.Infomask&HeapXmaxIsMulti != 0 {
+		return d.Xact.MultiUpdater(h.Xmax)
+	}
+	return h.Xmax, true
+}
+
+// diffRows lists the columns whose values differ between two versions,
+// ignoring system columns
+func diffRows(old, cur map[string]interface{}) map[string]ColumnChange {
+	changes := make(map[string]ColumnChange)
+	for name, val := range cur {
+		if isSystemColumn(name) {
+			continue
+		}
+		if !reflect.DeepEqual(old[name], val) {
+			changes[name] = ColumnChange{Old: old[name], New: val}
+		}
+	}
+	for name, val := range old {
+		if _, ok := cur[name]; !ok {
+			changes[name] = ColumnChange{Old: val}
+		}
+	}
+	return changes
+}
+
+func isSystemColumn(name string) bool {
+	for _, col := range systemColumns {
+		if col.Name == name {
+			return true
+		}
+	}
+	return false
+}
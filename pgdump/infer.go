@@ -0,0 +1,323 @@
+package pgdump
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+// InferResult is the outcome of guessing a heap's columns from its tuples
+type InferResult struct {
+	Tuples     int                      `json:"tuples"` // tuples examined
+	Natts      int                      `json:"natts"`
+	Candidates []InferredSchema         `json:"candidates"`     // best first
+	Rows       []map[string]interface{} `json:"rows,omitempty"` // decoded with the best candidate
+}
+
+// InferredSchema is one proposed column layout
+type InferredSchema struct {
+	Columns []ColumnInfo `json:"columns"`
+	Score   float64      `json:"score"` // 0-1: value plausibility times the share of tuples it decodes exactly
+	columns []Column
+}
+
+// inferType is a candidate column type with a check of stored values
+type inferType struct {
+	typID int
+	len   int // -1 for varlena
+	align byte
+	check func(value []byte) float64 // plausibility, 0 = impossible
+}
+
+// inferTypes are the types inference chooses from, tried in this order
+var inferTypes = []inferType{
+	{OidText, -1, 'i', checkText},
+	{OidInt4, 4, 'i', checkInt4},
+	{OidInt8, 8, 'd', checkInt8},
+	{OidTimestamp, 8, 'd', checkTimestamp},
+	{OidBool, 1, 'c', checkBool},
+	{OidUUID, 16, 'c', checkUUID},
+	{OidNumeric, -1, 'i', checkNumeric},
+	{OidJSONB, -1, 'i', checkJSONB},
+}
+
+// Inference limits
+const (
+	inferMaxTuples  = 2000 // tuples sampled
+	inferBeamWidth  = 16   // partial layouts kept per column
+	inferCandidates = 5    // layouts returned
+)
+
+// InferSchema proposes column layouts for a heap whose catalog entry is
+// lost. Each tuple's natts, null bitmap and data length constrain the
+// layout: columns are guessed one at a time, keeping the partial layouts
+// whose values decode most plausibly (zeroed alignment padding, valid
+// varlena headers, in-range values), and a layout only counts for the
+// tuples it ends exactly at the end of the data. Rows are decoded with
+// the best layout
+func InferSchema(data []byte) *InferResult {
+	var tuples []*HeapTupleData
+	natts := 0
+	for _, e := range ReadTuples(data, false) {
+		if len(tuples) == inferMaxTuples {
+			break
+		}
+		tuples = append(tuples, e.Tuple)
+		natts = max(natts, e.Tuple.Header.Natts)
+	}
+	result := &InferResult{Tuples: len(tuples), Natts: natts}
+	if len(tuples) == 0 || natts == 0 {
+		return result
+	}
+
+	beam := []inferState{{offsets: make([]int, len(tuples))}}
+	for i := 0; i < natts; i++ {
+		var next []inferState
+		for _, s := range beam {
+			next = append(next, s.extend(tuples, i)...)
+		}
+		sort.SliceStable(next, func(a, b int) bool { return next[a].score > next[b].score })
+		if len(next) > inferBeamWidth {
+			next = next[:inferBeamWidth]
+		}
+		beam = next
+	}
+
+	for _, s := range beam {
+		exact := 0
+		for j, t := range tuples {
+			if s.offsets[j] == len(t.Data) {
+				exact++
+			}
+		}
+		score := s.score / float64(natts) * float64(exact) / float64(len(tuples))
+		schema := InferredSchema{Score: math.Round(score*100) / 100}
+		for i, typ := range s.types {
+			col := Column{Name: fmt.Sprintf("col%d", i+1), TypID: typ.typID, Len: typ.len, Align: typ.align, Num: i + 1}
+			schema.columns = append(schema.columns, col)
+			schema.Columns = append(schema.Columns, ColumnInfo{Name: col.Name, Type: TypeName(col.TypID), TypID: col.TypID})
+		}
+		result.Candidates = append(result.Candidates, schema)
+	}
+	sort.SliceStable(result.Candidates, func(a, b int) bool { return result.Candidates[a].Score > result.Candidates[b].Score })
+	if len(result.Candidates) > inferCandidates {
+		result.Candidates = result.Candidates[:inferCandidates]
+	}
+	if len(result.Candidates) == 0 {
+		return result
+	}
+	result.Rows = ReadRows(data, result.Candidates[0].columns, true)
+	return result
+}
+
+// inferState is a partial layout: the types chosen so far and where each
+// tuple's next column starts (-1 once a tuple failed to decode)
+type inferState struct {
+	types   []inferType
+	offsets []int
+	score   float64 // sum of per-column plausibility
+}
+
+// extend tries every type for column i (0-based)
+func (s inferState) extend(tuples []*HeapTupleData, i int) []inferState {
+	present := false
+	for j, t := range tuples {
+		if s.offsets[j] >= 0 && i < t.Header.Natts && !t.IsNull(i+1) {
+			present = true
+			break
+		}
+	}
+
+	var states []inferState
+	for _, typ := range inferTypes {
+		next := inferState{
+			types:   append(append([]inferType(nil), s.types...), typ),
+			offsets: append([]int(nil), s.offsets...),
+			score:   s.score,
+		}
+		if !present {
+			states = append(states, next) // all NULL: nothing to go by
+			break
+		}
+		sum, n := 0.0, 0
+		for j, t := range tuples {
+			off := next.offsets[j]
+			if off < 0 || i >= t.Header.Natts || t.IsNull(i+1) {
+				continue
+			}
+			end, score := inferValue(t.Data, off, typ)
+			if score == 0 {
+				end = -1
+			}
+			next.offsets[j] = end
+			sum += score
+			n++
+		}
+		if sum > 0 {
+			next.score += sum / float64(n)
+			states = append(states, next)
+		}
+	}
+	return states
+}
+
+// inferValue measures a value of typ stored at off, laid out the way
+// DecodeTuple reads it. It returns where the value ends and how plausible
+// it is; padding before it must be zero
+func inferValue(data []byte, off int, typ inferType) (end int, score float64) {
+	a := alignFromChar(typ.align)
+	if typ.len == -1 && off < len(data) && (isShortVarlena(data[off:]) || IsTOASTPointer(data[off:])) {
+		a = 1
+	}
+	start := align(off, a)
+	if start >= len(data) {
+		return 0, 0
+	}
+	for _, b := range data[off:start] {
+		if b != 0 {
+			return 0, 0
+		}
+	}
+
+	if typ.len > 0 {
+		if start+typ.len > len(data) {
+			return 0, 0
+		}
+		return start + typ.len, typ.check(data[start : start+typ.len])
+	}
+
+	rem := data[start:]
+	switch {
+	case IsTOASTPointer(rem):
+		return start + varlenaExternalSize(rem), 0.5 // stored elsewhere
+	case isShortVarlena(rem):
+		size := int(rem[0] >> 1)
+		if size > len(rem) {
+			return 0, 0
+		}
+		return start + size, typ.check(rem[1:size])
+	case len(rem) >= 4 && rem[0]&0x03 == 0x02: // inline compressed
+		size := int(u32(rem, 0)>>2) & 0x3FFFFFFF
+		if size < 8 || size > len(rem) {
+			return 0, 0
+		}
+		return start + size, 0.5
+	case len(rem) >= 4 && rem[0]&0x03 == 0:
+		size := int(u32(rem, 0)>>2) & 0x3FFFFFFF
+		if size < 4 || size > len(rem) {
+			return 0, 0
+		}
+		return start + size, typ.check(rem[4:size])
+	}
+	return 0, 0
+}
+
+func checkText(v []byte) float64 {
+	if !utf8.Valid(v) {
+		return 0
+	}
+	for _, c := range v {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' {
+			return 0
+		}
+	}
+	if len(v) == 0 {
+		return 0.5
+	}
+	return 0.8
+}
+
+func checkInt4(v []byte) float64 {
+	if n := i32(v, 0); n > -1<<20 && n < 1<<20 {
+		return 0.8
+	}
+	return 0.5
+}
+
+func checkInt8(v []byte) float64 {
+	if n := i64(v, 0); n > -1e12 && n < 1e12 {
+		return 0.7 // below int4's score: an int4 then zero padding reads the same
+	}
+	return 0.4
+}
+
+// checkTimestamp accepts 1970-2100, except values within ~4 months of
+// 2000-01-01, which are more likely small integers
+func checkTimestamp(v []byte) float64 {
+	n := i64(v, 0)
+	if n < -946684800e6 || n > 3155760000e6 || n > -1e13 && n < 1e13 {
+		return 0
+	}
+	return 0.9
+}
+
+func checkBool(v []byte) float64 {
+	if v[0] > 1 {
+		return 0
+	}
+	return 0.9
+}
+
+// checkUUID prefers RFC 4122 version and variant bits
+func checkUUID(v []byte) float64 {
+	if version := v[6] >> 4; version >= 1 && version <= 8 && v[8]&0xC0 == 0x80 {
+		return 0.9
+	}
+	return 0.2
+}
+
+// checkNumeric validates the NumericData header and base-10000 digits
+func checkNumeric(v []byte) float64 {
+	if len(v) < 2 || len(v)%2 != 0 {
+		return 0
+	}
+	header := u16(v, 0)
+	digits := v[2:]
+	switch {
+	case header&0xC000 == 0xC000: // NaN, Infinity
+		if len(v) != 2 {
+			return 0
+		}
+		return 0.7
+	case header&0xC000 == 0x8000: // short format
+	case header&0xC000 == 0 || header&0xC000 == 0x4000: // long format: sign and dscale, then weight
+		if len(v) < 4 {
+			return 0
+		}
+		digits = v[4:]
+	default:
+		return 0
+	}
+	for i := 0; i+2 <= len(digits); i += 2 {
+		if u16(digits, i) >= 10000 {
+			return 0
+		}
+	}
+	return 0.7
+}
+
+// checkJSONB validates the root container header: an object, an array
+// or a scalar wrapped in a one-element array
+func checkJSONB(v []byte) float64 {
+	if len(v) < 4 {
+		return 0
+	}
+	header := u32(v, 0)
+	count := int(header & 0x0FFFFFFF)
+	switch header & 0xF0000000 {
+	case 0x20000000: // object: key and value entries
+		count *= 2
+	case 0x40000000: // array
+	case 0x50000000: // scalar
+		if count != 1 {
+			return 0
+		}
+	default:
+		return 0
+	}
+	if 4+count*4 > len(v) {
+		return 0
+	}
+	return 0.9
+}
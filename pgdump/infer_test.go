@@ -0,0 +1,52 @@
+package pgdump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestInferSchema(t *testing.T) {
+	columns := []Column{
+		{Name: "id", TypID: OidInt4, Len: 4, Align: 'i'},
+		{Name: "name", TypID: OidText, Len: -1, Align: 'i'},
+		{Name: "created", TypID: OidTimestamp, Len: 8, Align: 'd'},
+		{Name: "active", TypID: OidBool, Len: 1, Align: 'c'},
+	}
+	hinted := uint16(HeapXminCommitted | HeapXmaxInvalid)
+	var tuples [][]byte
+	for i := 1; i <= 30; i++ {
+		values := map[string][]byte{
+			"id":      binary.LittleEndian.AppendUint32(nil, uint32(i)),
+			"created": binary.LittleEndian.AppendUint64(nil, uint64(7.3e14)+uint64(i)*86400e6),
+			"active":  {byte(i % 2)},
+		}
+		if i%7 != 0 {
+			name := fmt.Sprintf("user %d", i)
+			values["name"] = append([]byte{byte(len(name)+1)<<1 | 1}, name...)
+		}
+		data, bitmap := makeRowData(columns, values)
+		tuples = append(tuples, makeHeapTuple(100, 0, hinted, len(columns), bitmap, data))
+	}
+
+	result := InferSchema(makeHeapPage(tuples...))
+	if result.Tuples != 30 || result.Natts != 4 || len(result.Candidates) == 0 {
+		t.Fatalf("InferSchema = %+v", result)
+	}
+	best := result.Candidates[0]
+	for i, want := range []string{"int4", "text", "timestamp", "bool"} {
+		if best.Columns[i].Type != want {
+			t.Errorf("col%d = %s, want %s (candidates %+v)", i+1, best.Columns[i].Type, want, result.Candidates)
+		}
+	}
+	if best.Score < 0.8 {
+		t.Errorf("best score = %v, want >= 0.8", best.Score)
+	}
+	if len(result.Rows) != 30 || result.Rows[1]["col2"] != "user 2" || result.Rows[6]["col2"] != nil {
+		t.Errorf("rows = %v", result.Rows[:7])
+	}
+
+	if empty := InferSchema(make([]byte, PageSize)); len(empty.Candidates) != 0 {
+		t.Errorf("InferSchema(empty page) = %+v", empty)
+	}
+}
@@ -1,6 +1,9 @@
 package pgdump
 
 import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -179,6 +182,57 @@ func TestRelMapGetOID(t *testing.T) {
 	}
 }
 
+func TestCatalogFilenode(t *testing.T) {
+	dbMap := &RelMapFile{Mappings: []RelMapping{{OID: PGClass, Filenode: 16999}}}
+	globalMap := &RelMapFile{Mappings: []RelMapping{{OID: PGAuthID, Filenode: 17001}}}
+
+	tests := []struct {
+		oid  uint32
+		want uint32
+	}{
+		{PGClass, 16999},    // rewritten by VACUUM FULL
+		{PGAuthID, 17001},   // shared catalog, global map
+		{PGAttribute, 1249}, // not in either map: initdb filenode
+	}
+	for _, tt := range tests {
+		if got := catalogFilenode(tt.oid, dbMap, nil, globalMap); got != tt.want {
+			t.Errorf("catalogFilenode(%d) = %d, want %d", tt.oid, got, tt.want)
+		}
+	}
+	if got := mappedFilenode(PGAttribute, []*RelMapFile{dbMap}); got != 0 {
+		t.Errorf("mappedFilenode(unmapped) = %d, want 0", got)
+	}
+	if rm := loadRelMap([]byte("short"), nil); rm != nil {
+		t.Error("loadRelMap should reject an invalid file")
+	}
+}
+
+func TestDumpDatabaseFromFilesRelMap(t *testing.T) {
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	live := uint16(HeapXminCommitted | HeapXmaxInvalid)
+	classData := makeHeapPage(catalogTuple(1, 0, live, schemaPGClass, map[string][]byte{
+		"oid": le32(PGClass), "relname": nameDatum("pg_class"), "relnamespace": le32(11), "relkind": {'r'},
+	}))
+	reader := func(uint32) ([]byte, error) { return nil, os.ErrNotExist }
+	dbMap := &RelMapFile{Mappings: []RelMapping{{OID: PGClass, Filenode: 16999}}}
+
+	for _, tt := range []struct {
+		maps []*RelMapFile
+		want int
+	}{
+		{nil, 0},
+		{[]*RelMapFile{dbMap}, 1},
+	} {
+		dump, err := DumpDatabaseFromFiles(classData, nil, reader, &Options{}, tt.maps...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dump.Tables) != tt.want || tt.want > 0 && dump.Tables[0].Filenode != 16999 {
+			t.Errorf("with %d maps: tables = %+v, want %d", len(tt.maps), dump.Tables, tt.want)
+		}
+	}
+}
+
 func TestGetCatalogName(t *testing.T) {
 	tests := []struct {
 		oid  uint32
@@ -412,3 +466,85 @@ func TestTOASTValueInfo(t *testing.T) {
 		t.Error("Expected IsCompressed = true")
 	}
 }
+
+// === Tablespace Tests ===
+
+func TestRelationLocator(t *testing.T) {
+	dataDir := t.TempDir()
+	spcTarget := t.TempDir()
+	mkdir := func(parts ...string) {
+		if err := os.MkdirAll(filepath.Join(parts...), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mkdir(dataDir, "base", "16384")
+	mkdir(dataDir, "pg_tblspc")
+	mkdir(spcTarget, "PG_16_202307071", "16384")
+	mkdir(spcTarget, "PG_16_202307071", "16390")
+	if err := os.Symlink(spcTarget, filepath.Join(dataDir, "pg_tblspc", "16500")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+
+	loc := newLocalLocator(dataDir, 16384)
+	spcDir := filepath.Join(dataDir, "pg_tblspc", "16500", "PG_16_202307071", "16384")
+	tests := []struct {
+		tablespace, filenode uint32
+		want                 string
+	}{
+		{0, 1259, filepath.Join(dataDir, "base", "16384") + "/1259"},
+		{DefaultTablespace, 16385, filepath.Join(dataDir, "base", "16384") + "/16385"},
+		{16500, 16386, spcDir + "/16386"},
+	}
+	for _, tt := range tests {
+		if got := loc.path(tt.tablespace, tt.filenode); got != tt.want {
+			t.Errorf("path(%d, %d) = %q, want %q", tt.tablespace, tt.filenode, got, tt.want)
+		}
+	}
+
+	// Database whose default tablespace is not pg_default
+	loc = newLocalLocator(dataDir, 16390)
+	want := filepath.Join(dataDir, "pg_tblspc", "16500", "PG_16_202307071", "16390") + "/1259"
+	if got := loc.path(0, PGClass); got != want {
+		t.Errorf("path(0, 1259) = %q, want %q", got, want)
+	}
+
+	if dirs := databaseDirs(dataDir); len(dirs) != 3 {
+		t.Errorf("databaseDirs = %v, want 3 directories", dirs)
+	}
+}
+
+func TestTablespaceMap(t *testing.T) {
+	spcs := ParseTablespaceMap([]byte("16500 /mnt/fast\n16501 /mnt/odd\\\nname\n"))
+	if len(spcs) != 2 || spcs[16500] != "/mnt/fast" || spcs[16501] != "/mnt/odd\nname" {
+		t.Errorf("ParseTablespaceMap = %q", spcs)
+	}
+
+	// A base backup: pg_tblspc is empty, tablespace_map has the locations
+	dataDir := t.TempDir()
+	spcTarget := t.TempDir()
+	for _, dir := range []string{
+		filepath.Join(dataDir, "base", "16384"),
+		filepath.Join(dataDir, "pg_tblspc"),
+		filepath.Join(spcTarget, "PG_16_202307071", "16384"),
+	} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dataDir, "tablespace_map"), []byte("16500 "+spcTarget+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(spcTarget, "PG_16_202307071", "16384") + "/16386"
+	if got := newLocalLocator(dataDir, 16384).path(16500, 16386); got != want {
+		t.Errorf("path(16500, 16386) = %q, want %q", got, want)
+	}
+}
+
+func TestTablespaceVersionDir(t *testing.T) {
+	if got := TablespaceVersionDir("16", 202307071); got != "PG_16_202307071" {
+		t.Errorf("TablespaceVersionDir = %q", got)
+	}
+	if got := TablespaceVersionDir("9.6", 201608131); got != "PG_9.6_201608131" {
+		t.Errorf("TablespaceVersionDir = %q", got)
+	}
+}
@@ -0,0 +1,295 @@
+package pgdump
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Relation forks, from the suffix of the file name
+const (
+	ForkMain = "main"
+	ForkFSM  = "fsm"
+	ForkVM   = "vm"
+	ForkInit = "init" // empty copy an unlogged relation is reset to
+)
+
+// RelationFile is one file of a database directory, classified by what
+// its first page holds
+type RelationFile struct {
+	Path     string           `json:"path"`
+	Filenode uint32           `json:"filenode"`
+	Fork     string           `json:"fork"`
+	Segment  int              `json:"segment,omitempty"`
+	Temp     bool             `json:"temp,omitempty"`    // t<backend>_<filenode>
+	Backend  int              `json:"backend,omitempty"` // backend that owned a temporary relation
+	Size     int64            `json:"size"`
+	Kind     string           `json:"kind"`               // see classifyPage
+	Relation string           `json:"relation,omitempty"` // live relation using the file
+	Orphaned bool             `json:"orphaned"`
+	Dropped  *DroppedRelation `json:"dropped,omitempty"` // dead pg_class row still naming the file
+}
+
+// DroppedRelation is a dead pg_class row left by DROP, TRUNCATE or a
+// table rewrite, with the columns pg_attribute still holds for it
+type DroppedRelation struct {
+	OID     uint32       `json:"oid"`
+	Schema  string       `json:"schema,omitempty"`
+	Name    string       `json:"name"`
+	Kind    string       `json:"kind"`
+	Xmax    uint32       `json:"xmax,omitempty"` // transaction that removed the row
+	Columns []ColumnInfo `json:"columns,omitempty"`
+
+	info  TableInfo
+	attrs []AttrInfo
+	xmin  uint32
+}
+
+// OrphanReport lists the relation files of one database
+type OrphanReport struct {
+	Database string         `json:"database"`
+	OID      uint32         `json:"oid"`
+	Orphaned int            `json:"orphaned"`
+	Files    []RelationFile `json:"files"`
+}
+
+// FindOrphanFiles lists the relation files of every database, or only
+// dbName, in base/ and in tablespaces, and flags those no live pg_class
+// row references: temporary relations left by a crash, init forks, and
+// old filenodes left after DROP, TRUNCATE or a rewrite
+func FindOrphanFiles(dataDir, dbName string) ([]OrphanReport, error) {
+	dbData, err := readGlobalCatalog(dataDir, PGDatabase)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read pg_database: %w", err)
+	}
+
+	xact := commitLogFor(OpenCommitLog(dataDir), VisibilityAuto)
+	globalMap := loadRelMap(os.ReadFile(filepath.Join(dataDir, "global", "pg_filenode.map")))
+	dirs := databaseDirs(dataDir)
+
+	var reports []OrphanReport
+	found := false
+	for _, db := range parsePGDatabase(dbData, xact) {
+		if dbName != "" && db.Name != dbName || dbName == "" && strings.HasPrefix(db.Name, "template") {
+			continue
+		}
+		found = true
+
+		rels, err := openRelations(dataDir, db.OID, xact, globalMap)
+		if err != nil {
+			continue
+		}
+		report := OrphanReport{Database: db.Name, OID: db.OID}
+		for _, dir := range dirs {
+			if filepath.Base(dir) == strconv.FormatUint(uint64(db.OID), 10) {
+				report.Files = append(report.Files, rels.scanDir(dir)...)
+			}
+		}
+		for _, f := range report.Files {
+			if f.Orphaned {
+				report.Orphaned++
+			}
+		}
+		reports = append(reports, report)
+	}
+	if dbName != "" && !found {
+		return nil, fmt.Errorf("database %q not found", dbName)
+	}
+	return reports, nil
+}
+
+// databaseRelations maps a database's main fork paths to the pg_class
+// rows naming them
+type databaseRelations struct {
+	loc   *relationLocator
+	live  map[string]TableInfo
+	dead  map[string]*DroppedRelation
+	attrs map[uint32][]AttrInfo // columns of live relations by OID
+}
+
+// openRelations reads pg_class and pg_attribute of a database, including
+// dead rows. Rows of in-progress transactions count as live
+func openRelations(dataDir string, dbOID uint32, xact *CommitLog, globalMap *RelMapFile) (*databaseRelations, error) {
+	loc := newLocalLocator(dataDir, dbOID)
+	dbMap := loadRelMap(os.ReadFile(filepath.Join(loc.dbDir, "pg_filenode.map")))
+	classData, err := ReadRelationFile(loc.path(0, catalogFilenode(PGClass, dbMap)))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read pg_class: %w", err)
+	}
+	attrData, _ := ReadRelationFile(loc.path(0, catalogFilenode(PGAttribute, dbMap)))
+
+	r := &databaseRelations{
+		loc:  loc,
+		live: make(map[string]TableInfo),
+		dead: make(map[string]*DroppedRelation),
+	}
+	tables := make(map[uint32]TableInfo)
+	dec := &Decoder{Xact: xact}
+	for _, e := range ReadTuples(classData, false) {
+		row := dec.DecodeTuple(e.Tuple, schemaPGClass)
+		if row == nil {
+			continue
+		}
+		info := classInfo(row, []*RelMapFile{dbMap, globalMap})
+		if info.Filenode == 0 {
+			continue
+		}
+		path := loc.path(info.Tablespace, info.Filenode)
+
+		switch dec.TupleStatus(e.Tuple) {
+		case StatusLive, StatusUncommitted:
+			r.live[path] = info
+			tables[info.Filenode] = info
+		default:
+			h := e.Tuple.Header
+			if prev := r.dead[path]; prev != nil && xidPrecedesOrEquals(h.Xmin, prev.xmin) {
+				continue // keep the newest row version
+			}
+			rel := &DroppedRelation{OID: info.OID, Name: info.Name, Kind: info.Kind, info: info, xmin: h.Xmin}
+			rel.Xmax, _ = dec.updater(h)
+			r.dead[path] = rel
+		}
+	}
+
+	// TRUNCATE and rewrites keep the relation's OID and its columns live
+	names := namespaceNames(tables, loc.reader(tables, os.ReadFile), xact)
+	live := parseAttributes(attrData, 0, xact, true)
+	all := parseAttributes(attrData, 0, xact, false)
+	r.attrs = live
+	for _, rel := range r.dead {
+		rel.Schema = names[rel.info.Namespace]
+		rel.info.Schema = rel.Schema
+		rel.attrs = live[rel.OID]
+		if len(rel.attrs) == 0 {
+			rel.attrs = all[rel.OID]
+		}
+		for _, a := range rel.attrs {
+			rel.Columns = append(rel.Columns, ColumnInfo{Name: a.Name, Type: TypeName(a.TypID), TypID: a.TypID})
+		}
+	}
+	return r, nil
+}
+
+// scanDir lists and classifies the relation files of one directory
+func (r *databaseRelations) scanDir(dir string) []RelationFile {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []RelationFile
+	for _, e := range entries {
+		f, ok := parseRelationFileName(e.Name())
+		if !ok || e.IsDir() {
+			continue
+		}
+		if fi, err := e.Info(); err == nil {
+			f.Size = fi.Size()
+		}
+		f.Path = filepath.Join(dir, e.Name())
+		f.Kind = classifyFile(f.Path, f.Fork)
+
+		main := fmt.Sprintf("%s/%d", dir, f.Filenode)
+		if info, ok := r.live[main]; ok {
+			f.Relation = qualifiedName(info.Schema, info.Name)
+		} else {
+			f.Orphaned = true
+			f.Dropped = r.dead[main]
+		}
+		files = append(files, f)
+	}
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Filenode < files[j].Filenode })
+	return files
+}
+
+// parseRelationFileName splits a relation file name such as "16384",
+// "16384.2", "16384_fsm" or "t3_16384_vm" (temporary relation of
+// backend 3). Other files (PG_VERSION, pg_filenode.map) are rejected
+func parseRelationFileName(name string) (f RelationFile, ok bool) {
+	if strings.HasPrefix(name, "t") {
+		backend, rest, found := strings.Cut(name[1:], "_")
+		n, err := strconv.Atoi(backend)
+		if !found || err != nil {
+			return f, false
+		}
+		f.Temp, f.Backend, name = true, n, rest
+	}
+
+	base, seg, hasSeg := strings.Cut(name, ".")
+	node, fork, hasFork := strings.Cut(base, "_")
+	f.Fork = ForkMain
+	if hasFork {
+		switch fork {
+		case ForkFSM, ForkVM, ForkInit:
+			f.Fork = fork
+		default:
+			return f, false
+		}
+	}
+	if hasSeg {
+		node += "." + seg
+	}
+	f.Filenode, f.Segment, ok = parseSegmentName(node)
+	return f, ok
+}
+
+// classifyFile classifies a relation file by its first page
+func classifyFile(path, fork string) string {
+	if fork == ForkFSM || fork == ForkVM {
+		return fork
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "unknown"
+	}
+	defer file.Close()
+
+	page := make([]byte, PageSize)
+	n, _ := io.ReadFull(file, page)
+	if n == 0 {
+		return "empty"
+	}
+	return classifyPage(page[:n])
+}
+
+// classifyPage names what a relation page belongs to: "sequence", an
+// index access method ("btree", "hash", ...), "toast", "heap", "empty"
+// for a zeroed page, or "unknown"
+func classifyPage(page []byte) string {
+	switch {
+	case len(page) < PageSize:
+		return "unknown"
+	case isZeroPage(page):
+		return "empty"
+	case IsSequenceFile(page):
+		return "sequence"
+	}
+	if t := detectIndexType(page); t != IndexTypeUnknown {
+		return t.String()
+	}
+	if !validHeader(parseHeader(page)) || u16(page, 16) != PageSize {
+		return "unknown" // heap pages have no special space
+	}
+	if isTOASTPage(page) {
+		return "toast"
+	}
+	return "heap"
+}
+
+// isTOASTPage reports whether every tuple on a heap page has the three
+// attributes of a TOAST table and decodes as a chunk with ReadTOASTTable.
+// Visibility plays no part: orphaned chunks are often deleted or unhinted
+func isTOASTPage(page []byte) bool {
+	// ReadTOASTTable keeps one chunk per (chunk_id, chunk_seq)
+	keys := make(map[[8]byte]bool)
+	for _, e := range ReadTuples(page, false) {
+		if e.Tuple.Header.Natts != 3 || e.Tuple.Header.HasNull || len(e.Tuple.Data) < 8 {
+			return false
+		}
+		keys[[8]byte(e.Tuple.Data)] = true
+	}
+	return len(keys) > 0 && len(ReadTOASTTable(page)) == len(keys)
+}
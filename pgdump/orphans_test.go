@@ -0,0 +1,127 @@
+package pgdump
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRelationFileName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    RelationFile
+		invalid bool
+	}{
+		{name: "16384", want: RelationFile{Filenode: 16384, Fork: ForkMain}},
+		{name: "16384.2", want: RelationFile{Filenode: 16384, Fork: ForkMain, Segment: 2}},
+		{name: "16384_fsm", want: RelationFile{Filenode: 16384, Fork: ForkFSM}},
+		{name: "16384_vm.1", want: RelationFile{Filenode: 16384, Fork: ForkVM, Segment: 1}},
+		{name: "16384_init", want: RelationFile{Filenode: 16384, Fork: ForkInit}},
+		{name: "t3_16390", want: RelationFile{Filenode: 16390, Fork: ForkMain, Temp: true, Backend: 3}},
+		{name: "t3_16390_fsm", want: RelationFile{Filenode: 16390, Fork: ForkFSM, Temp: true, Backend: 3}},
+		{name: "PG_VERSION", invalid: true},
+		{name: "pg_filenode.map", invalid: true},
+		{name: "pg_internal.init", invalid: true},
+		{name: "16384_xyz", invalid: true},
+		{name: "tx_16384", invalid: true},
+	}
+	for _, tt := range tests {
+		got, ok := parseRelationFileName(tt.name)
+		if ok == tt.invalid || ok && got != tt.want {
+			t.Errorf("parseRelationFileName(%q) = %+v, %v; want %+v", tt.name, got, ok, tt.want)
+		}
+	}
+}
+
+func TestFindOrphanFilesInDir(t *testing.T) {
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	chunk := func(seq uint32) []byte {
+		return append(append(le32(16500), le32(seq)...), makeVarlena([]byte("chunk"))...)
+	}
+	sequence := make([]byte, PageSize)
+	binary.LittleEndian.PutUint16(sequence[12:], headerSize)
+	binary.LittleEndian.PutUint16(sequence[14:], PageSize-4)
+	binary.LittleEndian.PutUint16(sequence[16:], PageSize-4)
+	binary.LittleEndian.PutUint16(sequence[18:], PageSize|4)
+	binary.LittleEndian.PutUint16(sequence[PageSize-4:], SequenceMagic)
+
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"16384":     makeHeapPage(makeHeapTuple(100, 0, HeapXmaxInvalid, 1, nil, le32(1))),
+		"16384_fsm": make([]byte, PageSize),
+		"16390": makeHeapPage(
+			makeHeapTuple(100, 120, HeapXminCommitted|HeapXmaxCommitted, 3, nil, chunk(0)), // deleted with its table row
+			makeHeapTuple(130, 0, 0, 3, nil, chunk(1)),                                     // never hinted
+			makeHeapTuple(140, 0, HeapXminInvalid, 3, nil, chunk(1)),                       // aborted insert, same key
+		),
+		"16395":           sequence,
+		"t3_16400":        {},
+		"PG_VERSION":      []byte("16\n"),
+		"16401_init":      make([]byte, PageSize),
+		"16402.1":         make([]byte, 100),
+		"pg_filenode.map": make([]byte, 512),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dropped := &DroppedRelation{OID: 16386, Name: "old_users", Kind: "r", Xmax: 120}
+	r := &databaseRelations{
+		live: map[string]TableInfo{
+			dir + "/16384": {Name: "users", Schema: "public"},
+			dir + "/16395": {Name: "users_id_seq", Schema: "public"},
+		},
+		dead: map[string]*DroppedRelation{dir + "/16390": dropped},
+	}
+
+	want := map[string]struct {
+		kind     string
+		orphaned bool
+	}{
+		"16384":      {"heap", false},
+		"16384_fsm":  {ForkFSM, false},
+		"16390":      {"toast", true},
+		"16395":      {"sequence", false},
+		"t3_16400":   {"empty", true},
+		"16401_init": {"empty", true},
+		"16402.1":    {"unknown", true},
+	}
+	got := r.scanDir(dir)
+	if len(got) != len(want) {
+		t.Fatalf("scanDir found %d files, want %d: %+v", len(got), len(want), got)
+	}
+	for _, f := range got {
+		name := filepath.Base(f.Path)
+		w := want[name]
+		if f.Kind != w.kind || f.Orphaned != w.orphaned {
+			t.Errorf("%s: kind %q orphaned %v, want %q %v", name, f.Kind, f.Orphaned, w.kind, w.orphaned)
+		}
+	}
+	if got[0].Relation != "public.users" {
+		t.Errorf("16384 relation = %q, want public.users", got[0].Relation)
+	}
+	for _, f := range got {
+		if f.Filenode == 16390 && f.Dropped != dropped {
+			t.Errorf("16390 dropped = %+v, want the dead pg_class row", f.Dropped)
+		}
+	}
+}
+
+func TestParseAttributesKeepsNewestVersion(t *testing.T) {
+	le16 := func(v uint16) []byte { return binary.LittleEndian.AppendUint16(nil, v) }
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	attr := func(xmin, xmax uint32, name string) []byte {
+		return catalogTuple(xmin, xmax, HeapXminCommitted, schemaPGAttrV15, map[string][]byte{
+			"attrelid": le32(16386), "attname": nameDatum(name), "atttypid": le32(OidInt4),
+			"attlen": le16(4), "attnum": le16(1), "attbyval": {1}, "attalign": {'i'},
+		})
+	}
+	// The renamed version sits before the row it replaced
+	page := makeHeapPage(attr(210, 220, "renamed"), attr(200, 210, "original"))
+	if got := parseAttributes(page, 0, nil, false)[16386]; len(got) != 1 || got[0].Name != "renamed" {
+		t.Errorf("parseAttributes = %+v, want the row written by xid 210", got)
+	}
+}
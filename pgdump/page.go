@@ -1,11 +1,21 @@
 package pgdump
 
+import "fmt"
+
 const (
 	PageSize   = 8192
 	headerSize = 24
 	itemIDSize = 4
 )
 
+// Line pointer states (lp_flags)
+const (
+	lpUnused   = 0
+	lpNormal   = 1
+	lpRedirect = 2 // lp_off holds the item number of the HOT chain's next member
+	lpDead     = 3
+)
+
 // PageHeader represents PostgreSQL page header
 type PageHeader struct {
 	Lower, Upper uint16
@@ -18,14 +28,57 @@ type ItemID struct {
 	Offset, Length, Flags int
 }
 
+// How a tuple was found on its page
+const (
+	FoundNormal = "normal"    // LP_NORMAL line pointer
+	FoundDead   = "lp_dead"   // storage left behind an LP_DEAD line pointer
+	FoundUnused = "lp_unused" // storage left behind an LP_UNUSED line pointer
+)
+
 // TupleEntry combines ItemID with parsed tuple
 type TupleEntry struct {
 	Tuple      *HeapTupleData
-	PageOffset int
+	PageOffset int    // byte offset of the page in the data read
+	Item       int    // line pointer number (1-based), the offset part of ctid
+	Root       int    // LP_REDIRECT item pointing here after HOT pruning (0 = none)
+	Found      string // FoundNormal, FoundDead or FoundUnused
+}
+
+// Block returns the block number of the tuple's page
+func (e *TupleEntry) Block() uint32 {
+	return uint32(e.PageOffset / PageSize)
+}
+
+// Ctid returns the tuple's physical location, e.g. "(0,1)"
+func (e *TupleEntry) Ctid() string {
+	return fmt.Sprintf("(%d,%d)", e.Block(), e.Item)
+}
+
+// SystemColumns returns the tuple's system column values keyed by name.
+// cmin and cmax both hold the raw t_cid, as in PostgreSQL
+func (e *TupleEntry) SystemColumns() map[string]interface{} {
+	h := e.Tuple.Header
+	return map[string]interface{}{
+		"ctid":       e.Ctid(),
+		"xmin":       h.Xmin,
+		"xmax":       h.Xmax,
+		"cmin":       h.Cid,
+		"cmax":       h.Cid,
+		"t_infomask": h.Flags(),
+		"t_ctid":     h.Ctid(),
+	}
 }
 
-// ParsePage extracts all visible tuples from a page
+// ParsePage extracts the tuples of LP_NORMAL items from a page
 func ParsePage(data []byte) []TupleEntry {
+	return ParsePageItems(data, false)
+}
+
+// ParsePageItems extracts the tuples of LP_NORMAL items from a page. With
+// forensic set it also decodes the storage LP_DEAD and LP_UNUSED items
+// still point at, when it looks like an intact tuple. Redirects are
+// resolved: the tuple an LP_REDIRECT leads to records it as Root
+func ParsePageItems(data []byte, forensic bool) []TupleEntry {
 	if len(data) < PageSize {
 		return nil
 	}
@@ -35,23 +88,60 @@ func ParsePage(data []byte) []TupleEntry {
 		return nil
 	}
 
+	items := parseItems(data, h)
+	roots := make(map[int]int)
+	for i, item := range items {
+		if item.Flags == lpRedirect {
+			roots[item.Offset] = i + 1
+		}
+	}
+
 	var entries []TupleEntry
-	for _, item := range parseItems(data, h) {
-		if item.Flags != 1 || item.Length <= 0 {
+	for i, item := range items {
+		found := FoundNormal
+		switch {
+		case item.Flags == lpNormal:
+		case forensic && item.Flags == lpDead:
+			found = FoundDead
+		case forensic && item.Flags == lpUnused:
+			found = FoundUnused
+		default:
 			continue
 		}
-		if item.Offset < int(h.Upper) || item.Offset+item.Length > PageSize {
+		if item.Length <= 0 || item.Offset < int(h.Upper) || item.Offset+item.Length > PageSize {
 			continue
 		}
 
-		tuple := ParseHeapTuple(data[item.Offset : item.Offset+item.Length])
+		raw := data[item.Offset : item.Offset+item.Length]
+		if found != FoundNormal && !plausibleTuple(raw, item.Offset) {
+			continue
+		}
+		tuple := ParseHeapTuple(raw)
 		if tuple != nil {
-			entries = append(entries, TupleEntry{Tuple: tuple})
+			entries = append(entries, TupleEntry{Tuple: tuple, Item: i + 1, Root: roots[i+1], Found: found})
 		}
 	}
 	return entries
 }
 
+// plausibleTuple checks that bytes no live line pointer vouches for still
+// look like a heap tuple header: aligned storage, a sane t_hoff and a
+// null bitmap that fits
+func plausibleTuple(raw []byte, offset int) bool {
+	if offset%8 != 0 || len(raw) < tupleHeaderSize {
+		return false
+	}
+	hoff := int(raw[22])
+	natts := int(u16(raw, 18) & HeapNattsMask)
+	if hoff < tupleHeaderSize || hoff%8 != 0 || hoff > len(raw) || natts == 0 || natts > 1600 {
+		return false
+	}
+	if u16(raw, 20)&HeapHasNull != 0 && tupleHeaderSize+(natts+7)/8 > hoff {
+		return false
+	}
+	return u32(raw, 0) != InvalidXID
+}
+
 func parseHeader(data []byte) *PageHeader {
 	psv := u16(data, 18)
 	return &PageHeader{
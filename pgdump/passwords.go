@@ -1,10 +1,5 @@
 package pgdump
 
-import (
-	"os"
-	"path/filepath"
-)
-
 // AuthInfo contains PostgreSQL user authentication info
 type AuthInfo struct {
 	OID      uint32 `json:"oid"`
@@ -16,8 +11,7 @@ type AuthInfo struct {
 
 // ExtractPasswords extracts password hashes from pg_authid (global/1260)
 func ExtractPasswords(dataDir string) ([]AuthInfo, error) {
-	authFile := filepath.Join(dataDir, "global", "1260")
-	data, err := os.ReadFile(authFile)
+	data, err := readGlobalCatalog(dataDir, PGAuthID)
 	if err != nil {
 		return nil, err
 	}
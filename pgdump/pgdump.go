@@ -39,7 +39,6 @@ package pgdump
 import (
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 )
 
@@ -48,11 +47,21 @@ var Version = "dev"
 
 // Options configures dump behavior
 type Options struct {
-	DatabaseFilter   string // Filter by database name
-	TableFilter      string // Filter tables containing string
-	ListOnly         bool   // Schema only, no data
-	SkipSystemTables bool   // Skip pg_* tables (default: true)
-	PostgresVersion  int    // Hint PG version (0 = auto)
+	DatabaseFilter   string         // Filter by database name
+	TableFilter      string         // Filter tables containing string
+	SchemaFilter     string         // Filter by schema name
+	ListOnly         bool           // Schema only, no data
+	SkipSystemTables bool           // Skip pg_* tables (default: true)
+	PostgresVersion  int            // Hint PG version (0 = auto)
+	Visibility       VisibilityMode // Hint bits or pg_xact (default: pg_xact when readable)
+	AsOfXID          uint32         // Show rows as they were after this xid (0 = current)
+	IncludeDeleted   bool           // Also return deleted and uncommitted rows not yet vacuumed
+	SystemColumns    bool           // Add ctid, xmin, xmax, cmin, cmax, t_infomask and t_ctid to rows
+	History          bool           // Return update chains (History) instead of Rows
+	DeadItems        bool           // Also decode tuples behind LP_DEAD/LP_UNUSED items (forensic)
+	Carve            bool           // Also carve tuples from free space and damaged pages
+	XminSchema       bool           // Decode each tuple with the schema current at its xmin (see SchemaHistory)
+	Redo             *RedoTarget    // Replay WAL onto the selected tables up to a target first (see RedoHeap)
 }
 
 // DumpResult contains complete dump
@@ -65,24 +74,42 @@ type DatabaseDump struct {
 	OID    uint32      `json:"oid"`
 	Name   string      `json:"name"`
 	Tables []TableDump `json:"tables"`
+	Types  []TypeDef   `json:"types,omitempty"` // user-defined types the tables use
 }
 
 // TableDump contains single table dump
 type TableDump struct {
-	OID      uint32                   `json:"oid"`
-	Name     string                   `json:"name"`
-	Filenode uint32                   `json:"filenode"`
-	Kind     string                   `json:"kind"`
-	Columns  []ColumnInfo             `json:"columns,omitempty"`
-	Rows     []map[string]interface{} `json:"rows,omitempty"`
-	RowCount int                      `json:"row_count"`
+	OID         uint32                   `json:"oid"`
+	Schema      string                   `json:"schema,omitempty"`
+	Name        string                   `json:"name"`
+	Filenode    uint32                   `json:"filenode"`
+	Kind        string                   `json:"kind"`
+	Columns     []ColumnInfo             `json:"columns,omitempty"`
+	Rows        []map[string]interface{} `json:"rows,omitempty"`
+	DeletedRows []map[string]interface{} `json:"deleted_rows,omitempty"` // dead rows not yet vacuumed, with StatusColumn
+	History     []RowHistory             `json:"history,omitempty"`      // row versions grouped by update chain
+	Carved      []CarvedTuple            `json:"carved,omitempty"`       // tuples carved outside live line pointers
+	RowCount    int                      `json:"row_count"`
 }
 
 // ColumnInfo describes a column
 type ColumnInfo struct {
-	Name  string `json:"name"`
-	Type  string `json:"type"`
-	TypID int    `json:"typid"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	TypID  int    `json:"typid"`
+	System bool   `json:"system,omitempty"` // tuple header field, not a table column
+}
+
+// systemColumns are appended to a table's columns when Options.SystemColumns
+// is set. t_infomask and t_ctid are header fields as pageinspect shows them
+var systemColumns = []ColumnInfo{
+	{Name: "ctid", Type: "tid", TypID: OidTid, System: true},
+	{Name: "xmin", Type: "xid", TypID: OidXid, System: true},
+	{Name: "xmax", Type: "xid", TypID: OidXid, System: true},
+	{Name: "cmin", Type: "cid", TypID: OidCid, System: true},
+	{Name: "cmax", Type: "cid", TypID: OidCid, System: true},
+	{Name: "t_infomask", Type: "text[]", TypID: 1009, System: true},
+	{Name: "t_ctid", Type: "tid", TypID: OidTid, System: true},
 }
 
 // FileReader reads table data by filenode
@@ -109,13 +136,15 @@ func DumpAll(opts *Options) ([]*DumpResult, error) {
 func DumpDataDir(dataDir string, opts *Options) (*DumpResult, error) {
 	opts = withDefaults(opts)
 
-	dbData, err := os.ReadFile(filepath.Join(dataDir, "global", "1262"))
+	dbData, err := readGlobalCatalog(dataDir, PGDatabase)
 	if err != nil {
 		return nil, err
 	}
 
+	xact := commitLogFor(OpenCommitLog(dataDir), opts.Visibility)
+
 	result := &DumpResult{}
-	for _, db := range ParsePGDatabase(dbData) {
+	for _, db := range parsePGDatabase(dbData, xact) {
 		if strings.HasPrefix(db.Name, "template") {
 			continue
 		}
@@ -123,19 +152,32 @@ func DumpDataDir(dataDir string, opts *Options) (*DumpResult, error) {
 			continue
 		}
 
-		basePath := filepath.Join(dataDir, "base", strconv.FormatUint(uint64(db.OID), 10))
-		classData, _ := os.ReadFile(filepath.Join(basePath, "1259"))
-		attrData, _ := os.ReadFile(filepath.Join(basePath, "1249"))
+		loc := newLocalLocator(dataDir, db.OID)
+		dbMap := loadRelMap(os.ReadFile(filepath.Join(loc.dbDir, "pg_filenode.map")))
+		classData, _ := ReadRelationFile(loc.path(0, catalogFilenode(PGClass, dbMap)))
+		attrData, _ := ReadRelationFile(loc.path(0, catalogFilenode(PGAttribute, dbMap)))
 
 		if len(classData) == 0 {
 			continue
 		}
 
-		reader := func(fn uint32) ([]byte, error) {
-			return os.ReadFile(filepath.Join(basePath, strconv.FormatUint(uint64(fn), 10)))
+		globalMap := loadRelMap(os.ReadFile(filepath.Join(dataDir, "global", "pg_filenode.map")))
+		tables := ParsePGClassWithRelMap(classData, xact, dbMap, globalMap)
+		reader := loc.reader(tables, os.ReadFile)
+
+		dbXact := xact
+		if opts.Redo != nil {
+			redo, err := redoTables(dataDir, db.OID, tables, reader, xact, opts)
+			if err != nil {
+				return nil, err
+			}
+			reader = loc.reader(tables, redo.overlay(os.ReadFile))
+			if opts.Visibility != VisibilityHintBits {
+				dbXact = redo.commitLog(dataDir)
+			}
 		}
 
-		if dump, _ := DumpDatabaseFromFiles(classData, attrData, reader, opts); dump != nil {
+		if dump := dumpDatabase(tables, classData, attrData, reader, dbXact, opts); dump != nil {
 			dump.OID, dump.Name = db.OID, db.Name
 			result.Databases = append(result.Databases, *dump)
 		}
@@ -143,34 +185,82 @@ func DumpDataDir(dataDir string, opts *Options) (*DumpResult, error) {
 	return result, nil
 }
 
-// DumpDatabaseFromFiles dumps using pre-read catalog files and custom reader
-func DumpDatabaseFromFiles(classData, attrData []byte, reader FileReader, opts *Options) (*DatabaseDump, error) {
-	opts = withDefaults(opts)
+// DumpDatabaseFromFiles dumps using pre-read catalog files and custom reader.
+// relMaps are the database's and the global pg_filenode.map (see
+// ParseRelMapFile); without them mapped catalogs are left out
+func DumpDatabaseFromFiles(classData, attrData []byte, reader FileReader, opts *Options, relMaps ...*RelMapFile) (*DatabaseDump, error) {
+	tables := ParsePGClassWithRelMap(classData, nil, relMaps...)
+	return dumpDatabase(tables, classData, attrData, reader, nil, withDefaults(opts)), nil
+}
+
+func dumpDatabase(tables map[uint32]TableInfo, classData, attrData []byte, reader FileReader, xact *CommitLog, opts *Options) *DatabaseDump {
+	attrs := parseAttributes(attrData, opts.PostgresVersion, xact, true)
+	if reader != nil {
+		ResolveSchemas(tables, reader, xact)
+	}
 
-	tables := ParsePGClass(classData)
-	attrs := ParsePGAttribute(attrData, opts.PostgresVersion)
+	dec := &Decoder{
+		TOAST:         NewTOASTReaderFromFiles(tables, reader, xact),
+		Xact:          xact,
+		AsOf:          opts.AsOfXID,
+		SystemColumns: opts.SystemColumns,
+		DeadItems:     opts.DeadItems,
+	}
+	if reader != nil {
+		dec.Types = LoadTypes(tables, attrs, reader, opts.PostgresVersion, xact)
+	}
+
+	var histories map[uint32]*SchemaHistory
+	if opts.XminSchema {
+		histories = schemaHistories(classData, attrData, xact, opts.PostgresVersion)
+	}
 
 	result := &DatabaseDump{}
 	for filenode, info := range tables {
-		if info.Kind != "r" && info.Kind != "" {
-			continue
-		}
-		if opts.SkipSystemTables && strings.HasPrefix(info.Name, "pg_") {
-			continue
-		}
-		if opts.TableFilter != "" && !strings.Contains(strings.ToLower(info.Name), strings.ToLower(opts.TableFilter)) {
+		if !opts.wantTable(info) {
 			continue
 		}
 
-		table := dumpTable(filenode, info, attrs[info.OID], reader, opts)
+		tdec := dec
+		if h := histories[info.OID]; h != nil {
+			tdec = h.decoder(dec, filenode)
+		}
+		table := dumpTable(filenode, info, attrs[info.OID], reader, tdec, opts)
 		result.Tables = append(result.Tables, table)
 	}
-	return result, nil
+	result.Types = dec.Types.Definitions(columnTypes(result.Tables))
+	return result
+}
+
+// wantTable reports whether a relation is a table the filters of o select
+func (o *Options) wantTable(info TableInfo) bool {
+	if info.Kind != "r" && info.Kind != "" {
+		return false
+	}
+	if o.SkipSystemTables && (strings.HasPrefix(info.Name, "pg_") || isSystemSchema(info.Schema)) {
+		return false
+	}
+	if o.SchemaFilter != "" && info.Schema != o.SchemaFilter {
+		return false
+	}
+	return o.TableFilter == "" || strings.Contains(strings.ToLower(info.Name), strings.ToLower(o.TableFilter))
 }
 
-func dumpTable(filenode uint32, info TableInfo, attrs []AttrInfo, reader FileReader, opts *Options) TableDump {
+// columnTypes lists the type OIDs of all dumped columns
+func columnTypes(tables []TableDump) []int {
+	var oids []int
+	for _, t := range tables {
+		for _, c := range t.Columns {
+			oids = append(oids, c.TypID)
+		}
+	}
+	return oids
+}
+
+func dumpTable(filenode uint32, info TableInfo, attrs []AttrInfo, reader FileReader, dec *Decoder, opts *Options) TableDump {
 	t := TableDump{
 		OID:      info.OID,
+		Schema:   info.Schema,
 		Name:     info.Name,
 		Filenode: filenode,
 		Kind:     info.Kind,
@@ -179,11 +269,15 @@ func dumpTable(filenode uint32, info TableInfo, attrs []AttrInfo, reader FileRea
 	for _, a := range attrs {
 		t.Columns = append(t.Columns, ColumnInfo{
 			Name:  a.Name,
-			Type:  TypeName(a.TypID),
+			Type:  dec.Types.TypeName(a.TypID),
 			TypID: a.TypID,
 		})
 	}
 
+	if opts.SystemColumns {
+		t.Columns = append(t.Columns, systemColumns...)
+	}
+
 	if opts.ListOnly || reader == nil {
 		return t
 	}
@@ -193,12 +287,20 @@ func dumpTable(filenode uint32, info TableInfo, attrs []AttrInfo, reader FileRea
 		return t
 	}
 
-	cols := make([]Column, len(attrs))
-	for i, a := range attrs {
-		cols[i] = Column{Name: a.Name, TypID: a.TypID, Len: a.Len, Num: a.Num, Align: a.Align}
+	if opts.Carve {
+		t.Carved = dec.Carve(data, columnsFromAttrs(attrs))
 	}
 
-	t.Rows = ReadRows(data, cols, true)
+	switch {
+	case opts.History:
+		t.History = dec.ReadHistory(data, columnsFromAttrs(attrs))
+		t.RowCount = len(t.History)
+		return t
+	case opts.IncludeDeleted:
+		t.Rows, t.DeletedRows = dec.ReadRowsWithDeleted(data, columnsFromAttrs(attrs))
+	default:
+		t.Rows = dec.ReadRows(data, columnsFromAttrs(attrs), true)
+	}
 	t.RowCount = len(t.Rows)
 	return t
 }
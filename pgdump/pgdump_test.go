@@ -1,6 +1,7 @@
 package pgdump
 
 import (
+	"encoding/binary"
 	"os"
 	"path/filepath"
 	"testing"
@@ -841,6 +842,10 @@ func TestReadVarlena(t *testing.T) {
 		{"empty", []byte{}, 0, ""},
 		{"short varlena", []byte{0x0d, 'h', 'e', 'l', 'l', 'o'}, 6, "hello"}, // total=6, (6<<1)|1 = 0x0d
 		{"long varlena", []byte{0x18, 0x00, 0x00, 0x00, 't', 'e'}, 6, "te"},  // total=6, header=0x18>>2=6
+		// total=14, header=(14<<2)|2, tcinfo=12 (pglz), then "abc" + back-reference
+		{"compressed pglz", []byte{0x3a, 0x00, 0x00, 0x00, 0x0c, 0x00, 0x00, 0x00, 0x08, 'a', 'b', 'c', 0x06, 0x03}, 14, "abcabcabcabc"},
+		// same length, tcinfo=12|1<<30 (lz4), token 0x35 = 3 literals + match of 9
+		{"compressed lz4", []byte{0x3a, 0x00, 0x00, 0x00, 0x0c, 0x00, 0x00, 0x40, 0x35, 'a', 'b', 'c', 0x03, 0x00}, 14, "abcabcabcabc"},
 	}
 
 	for _, tt := range tests {
@@ -1079,6 +1084,27 @@ func TestDecodeArrayBasic(t *testing.T) {
 	}
 }
 
+func TestDecodeArrayText(t *testing.T) {
+	// 1D array of text: {"ab", "abcabcabcabc"}, the second element compressed
+	data := []byte{
+		0x01, 0x00, 0x00, 0x00, // ndim = 1
+		0x00, 0x00, 0x00, 0x00, // dataoffset = 0 (no nulls)
+		0x19, 0x00, 0x00, 0x00, // elemtype = 25 (text)
+		0x02, 0x00, 0x00, 0x00, // dim[0] = 2
+		0x01, 0x00, 0x00, 0x00, // lbound[0] = 1
+		0x18, 0x00, 0x00, 0x00, 'a', 'b', // "ab", total=6
+		0x00, 0x00, // pad to 4
+		0x3a, 0x00, 0x00, 0x00, 0x0c, 0x00, 0x00, 0x00, 0x08, 'a', 'b', 'c', 0x06, 0x03,
+	}
+	got, ok := DecodeType(data, 1009).([]interface{})
+	if !ok || len(got) != 2 {
+		t.Fatalf("DecodeType(text[]) = %v, want 2 elements", got)
+	}
+	if got[0] != "ab" || got[1] != "abcabcabcabc" {
+		t.Errorf("DecodeType(text[]) = %q", got)
+	}
+}
+
 // === Tuple and Heap tests ===
 
 func TestIsVisible(t *testing.T) {
@@ -1464,6 +1490,51 @@ func TestParsePageInvalidHeader(t *testing.T) {
 	}
 }
 
+func TestParsePageItems(t *testing.T) {
+	columns := []Column{{Name: "id", TypID: OidInt4, Len: 4, Align: 'i', Num: 1}}
+	row := func(xmin uint32, id byte) []byte {
+		return makeHeapTuple(xmin, 0, HeapXminCommitted|HeapXmaxInvalid, 1, nil, []byte{id, 0, 0, 0})
+	}
+	page := makeHeapPage(row(100, 1), row(100, 2), row(100, 3), row(100, 4), row(100, 5))
+	setItem := func(n int, lp uint32) { binary.LittleEndian.PutUint32(page[headerSize+(n-1)*itemIDSize:], lp) }
+	lp := func(n int) uint32 { return binary.LittleEndian.Uint32(page[headerSize+(n-1)*itemIDSize:]) }
+
+	setItem(1, 2|lpRedirect<<15)         // HOT-pruned root -> item 2
+	setItem(3, lp(3)&^(3<<15)|lpDead<<15) // dead, storage intact
+	setItem(4, lp(4)&^(3<<15))            // unused, storage intact
+	copy(page[lp(5)&0x7FFF:], "garbage, not a tuple header")
+	setItem(5, lp(5)&^(3<<15)|lpDead<<15)
+
+	entries := ParsePage(page)
+	if len(entries) != 1 || entries[0].Item != 2 || entries[0].Root != 1 || entries[0].Found != FoundNormal {
+		t.Fatalf("ParsePage = %+v", entries)
+	}
+
+	entries = ParsePageItems(page, true)
+	want := []struct {
+		item  int
+		found string
+	}{{2, FoundNormal}, {3, FoundDead}, {4, FoundUnused}}
+	if len(entries) != len(want) {
+		t.Fatalf("ParsePageItems = %+v", entries)
+	}
+	for i, w := range want {
+		if entries[i].Item != w.item || entries[i].Found != w.found {
+			t.Errorf("entry %d = item %d %s, want item %d %s", i, entries[i].Item, entries[i].Found, w.item, w.found)
+		}
+	}
+
+	// Recovered tuples never count as live, even with stale hint bits
+	dec := &Decoder{DeadItems: true}
+	if rows := dec.ReadRows(page, columns, true); len(rows) != 1 {
+		t.Errorf("visible rows = %v", rows)
+	}
+	_, deleted := dec.ReadRowsWithDeleted(page, columns)
+	if len(deleted) != 2 || deleted[0][StatusColumn] != StatusDeleted || deleted[0][FoundColumn] != FoundDead {
+		t.Errorf("deleted = %v", deleted)
+	}
+}
+
 // === JSONB additional tests ===
 
 func TestDecodeJEntryTypes(t *testing.T) {
@@ -0,0 +1,360 @@
+package pgdump
+
+import (
+	"sort"
+	"strings"
+)
+
+// TypeInfo represents a pg_type entry
+type TypeInfo struct {
+	OID       uint32
+	Name      string
+	Schema    string // resolved from typnamespace via pg_namespace
+	Namespace uint32 // typnamespace
+	Len       int    // typlen (-1 = varlena)
+	Align     byte   // typalign: 'c', 's', 'i', 'd'
+	Kind      byte   // typtype: b=base, c=composite, d=domain, e=enum, p=pseudo, r=range
+	Category  byte   // typcategory: 'A' = array
+	RelID     uint32 // typrelid: pg_class OID of a composite's attributes
+	Elem      uint32 // typelem: array element type
+	BaseType  uint32 // typbasetype: type underlying a domain
+	RowType   bool   // composite is the row type of a table
+}
+
+// EnumInfo represents a pg_enum entry
+type EnumInfo struct {
+	OID       uint32
+	TypeOID   uint32
+	SortOrder float32
+	Label     string
+}
+
+// TypeRegistry resolves user-defined types of one database
+type TypeRegistry struct {
+	Types map[uint32]TypeInfo
+	Enums map[uint32]EnumInfo   // keyed by pg_enum OID, the stored value
+	Attrs map[uint32][]AttrInfo // composite typrelid -> attributes
+}
+
+// TypeDef describes a user-defined type used by dumped columns
+type TypeDef struct {
+	OID        uint32       `json:"oid"`
+	ArrayTypID int          `json:"array_typid,omitempty"`
+	Schema     string       `json:"schema,omitempty"`
+	Name       string       `json:"name"`
+	Kind       string       `json:"kind"` // enum, domain or composite
+	Labels     []string     `json:"labels,omitempty"`
+	BaseType   string       `json:"base_type,omitempty"`
+	BaseTypID  int          `json:"base_typid,omitempty"`
+	Attributes []ColumnInfo `json:"attributes,omitempty"`
+}
+
+var (
+	// PostgreSQL 14+ pg_type structure (typsubscript added)
+	schemaPGTypeV14 = pgTypeSchema(true)
+
+	// PostgreSQL 12-13 pg_type structure
+	schemaPGTypeV13 = pgTypeSchema(false)
+
+	schemaPGEnum = []Column{
+		{Name: "oid", TypID: OidOid, Len: 4},
+		{Name: "enumtypid", TypID: OidOid, Len: 4},
+		{Name: "enumsortorder", TypID: OidFloat4, Len: 4},
+		{Name: "enumlabel", TypID: OidName, Len: 64},
+	}
+)
+
+func pgTypeSchema(subscript bool) []Column {
+	cols := []Column{
+		{Name: "oid", TypID: OidOid, Len: 4},
+		{Name: "typname", TypID: OidName, Len: 64},
+		{Name: "typnamespace", TypID: OidOid, Len: 4},
+		{Name: "typowner", TypID: OidOid, Len: 4},
+		{Name: "typlen", TypID: OidInt2, Len: 2},
+		{Name: "typbyval", TypID: OidBool, Len: 1},
+		{Name: "typtype", TypID: OidChar, Len: 1},
+		{Name: "typcategory", TypID: OidChar, Len: 1},
+		{Name: "typispreferred", TypID: OidBool, Len: 1},
+		{Name: "typisdefined", TypID: OidBool, Len: 1},
+		{Name: "typdelim", TypID: OidChar, Len: 1},
+		{Name: "typrelid", TypID: OidOid, Len: 4},
+	}
+	if subscript {
+		cols = append(cols, Column{Name: "typsubscript", TypID: OidOid, Len: 4})
+	}
+	for _, name := range []string{"typelem", "typarray", "typinput", "typoutput", "typreceive",
+		"typsend", "typmodin", "typmodout", "typanalyze"} {
+		cols = append(cols, Column{Name: name, TypID: OidOid, Len: 4})
+	}
+	return append(cols,
+		Column{Name: "typalign", TypID: OidChar, Len: 1},
+		Column{Name: "typstorage", TypID: OidChar, Len: 1},
+		Column{Name: "typnotnull", TypID: OidBool, Len: 1},
+		Column{Name: "typbasetype", TypID: OidOid, Len: 4},
+	)
+}
+
+// ParsePGType extracts type info from pg_type heap file; xact decides
+// which rows are live (nil = hint bits)
+func ParsePGType(data []byte, pgVersion int, xact *CommitLog) map[uint32]TypeInfo {
+	schema := detectTypeSchema(data, pgVersion)
+	result := make(map[uint32]TypeInfo)
+	for _, row := range catalogRows(data, schema, xact) {
+		oid, name := getOID(row, "oid"), getString(row, "typname")
+		if oid == 0 || name == "" {
+			continue
+		}
+		result[oid] = TypeInfo{
+			OID:       oid,
+			Name:      name,
+			Namespace: getOID(row, "typnamespace"),
+			Len:       toInt(row["typlen"]),
+			Align:     firstByte(getString(row, "typalign")),
+			Kind:      firstByte(getString(row, "typtype")),
+			Category:  firstByte(getString(row, "typcategory")),
+			RelID:     getOID(row, "typrelid"),
+			Elem:      getOID(row, "typelem"),
+			BaseType:  getOID(row, "typbasetype"),
+		}
+	}
+	return result
+}
+
+// detectTypeSchema picks the pg_type layout; without a version hint it
+// checks that _int4 (1007) decodes with typelem = int4
+func detectTypeSchema(data []byte, version int) []Column {
+	if version >= 14 {
+		return schemaPGTypeV14
+	}
+	if version >= 12 {
+		return schemaPGTypeV13
+	}
+	for _, row := range ReadRows(data, schemaPGTypeV14, true) {
+		if getOID(row, "oid") == 1007 {
+			if getOID(row, "typelem") == OidInt4 {
+				return schemaPGTypeV14
+			}
+			return schemaPGTypeV13
+		}
+	}
+	return schemaPGTypeV14
+}
+
+// ParsePGEnum extracts enum labels from pg_enum heap file; xact decides
+// which rows are live (nil = hint bits)
+func ParsePGEnum(data []byte, xact *CommitLog) []EnumInfo {
+	var result []EnumInfo
+	for _, row := range catalogRows(data, schemaPGEnum, xact) {
+		if oid := getOID(row, "oid"); oid > 0 {
+			order, _ := row["enumsortorder"].(float32)
+			result = append(result, EnumInfo{
+				OID:       oid,
+				TypeOID:   getOID(row, "enumtypid"),
+				SortOrder: order,
+				Label:     getString(row, "enumlabel"),
+			})
+		}
+	}
+	return result
+}
+
+// LoadTypes reads pg_type and pg_enum through reader, visibility decided
+// by xact (nil = hint bits). attrs supplies the attributes of composite
+// types; it is the database's ParsePGAttribute result
+func LoadTypes(tables map[uint32]TableInfo, attrs map[uint32][]AttrInfo, reader FileReader, pgVersion int, xact *CommitLog) *TypeRegistry {
+	data, err := readCatalog(tables, reader, PGType)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	reg := &TypeRegistry{Types: ParsePGType(data, pgVersion, xact), Enums: make(map[uint32]EnumInfo), Attrs: attrs}
+	if enumData, err := readCatalog(tables, reader, PGEnum); err == nil {
+		for _, e := range ParsePGEnum(enumData, xact) {
+			reg.Enums[e.OID] = e
+		}
+	}
+
+	names := namespaceNames(tables, reader, xact)
+	relations := make(map[uint32]bool, len(tables))
+	for _, info := range tables {
+		relations[info.OID] = true
+	}
+	for oid, t := range reg.Types {
+		t.Schema = names[t.Namespace]
+		t.RowType = t.Kind == 'c' && relations[t.RelID]
+		reg.Types[oid] = t
+	}
+	return reg
+}
+
+// baseType follows domains down to the type their values are stored as.
+// Types missing from the registry come back with only OID set
+func (r *TypeRegistry) baseType(oid int) TypeInfo {
+	for depth := 0; r != nil && depth < 16; depth++ {
+		t, ok := r.Types[uint32(oid)]
+		if !ok {
+			break
+		}
+		if t.Kind != 'd' {
+			return t
+		}
+		oid = int(t.BaseType)
+	}
+	return TypeInfo{OID: uint32(oid)}
+}
+
+// decode decodes a value, consulting the registry for types DecodeType
+// does not know: domains, enums, composites and arrays of those
+func (d *Decoder) decode(data []byte, typID int) interface{} {
+	if d.Types == nil || len(data) == 0 {
+		return DecodeType(data, typID)
+	}
+	t := d.Types.baseType(typID)
+	switch {
+	case t.isArray():
+		return d.decodeArray(data, int(t.Elem))
+	case t.Kind == 'e':
+		if len(data) < 4 {
+			return nil
+		}
+		if e, ok := d.Types.Enums[u32(data, 0)]; ok {
+			return e.Label
+		}
+		return u32(data, 0)
+	case t.Kind == 'c':
+		return d.decodeRecord(data, t.RelID)
+	}
+	return DecodeType(data, int(t.OID))
+}
+
+// decodeArray decodes an array whose elements may be user-defined types
+func (d *Decoder) decodeArray(data []byte, elem int) []interface{} {
+	elemLen, elemAlign := -1, 4
+	if t, ok := d.Types.Types[uint32(elem)]; ok {
+		elemLen, elemAlign = t.Len, alignFromChar(t.Align)
+		if elemAlign == 0 {
+			elemAlign = typeAlign(elem, elemLen)
+		}
+	} else if n, ok := fixedLengths[elem]; ok {
+		elemLen, elemAlign = n, typeAlign(elem, n)
+	}
+	return decodeArrayWith(data, elemLen, elemAlign, func(b []byte) interface{} {
+		return d.decode(b, elem)
+	})
+}
+
+// decodeRecord decodes a composite value. Its payload is a heap tuple
+// header whose first word is the varlena length stripped by ReadVarlena
+func (d *Decoder) decodeRecord(data []byte, relid uint32) interface{} {
+	tuple := ParseHeapTuple(append(make([]byte, 4), data...))
+	if tuple == nil {
+		return nil
+	}
+	return d.DecodeTuple(tuple, columnsFromAttrs(d.Types.Attrs[relid]))
+}
+
+// columnsFromAttrs converts pg_attribute rows to a decoding schema
+func columnsFromAttrs(attrs []AttrInfo) []Column {
+	cols := make([]Column, len(attrs))
+	for i, a := range attrs {
+		cols[i] = Column{Name: a.Name, TypID: a.TypID, Len: a.Len, Num: a.Num, Align: a.Align, Missing: a.Missing}
+	}
+	return cols
+}
+
+// isArray reports whether values are stored in array format; this also
+// covers int2vector and oidvector
+func (t TypeInfo) isArray() bool {
+	return t.Category == 'A' && t.Len == -1 && t.Elem != 0
+}
+
+// TypeName returns the type name as PostgreSQL prints it: schema-qualified
+// outside public and pg_catalog, with [] for arrays
+func (r *TypeRegistry) TypeName(oid int) string {
+	if _, ok := typeNames[oid]; ok || r == nil {
+		return TypeName(oid)
+	}
+	t, ok := r.Types[uint32(oid)]
+	if !ok {
+		return TypeName(oid)
+	}
+	if t.Category == 'A' && t.Elem != 0 && strings.HasPrefix(t.Name, "_") {
+		return r.TypeName(int(t.Elem)) + "[]"
+	}
+	if t.Schema != "" && t.Schema != "public" && t.Schema != "pg_catalog" {
+		return t.Schema + "." + t.Name
+	}
+	return t.Name
+}
+
+// Definitions returns the user-defined enums, domains and composites the
+// given column types depend on, dependencies first
+func (r *TypeRegistry) Definitions(typIDs []int) []TypeDef {
+	if r == nil {
+		return nil
+	}
+	var defs []TypeDef
+	seen := make(map[uint32]bool)
+	var visit func(oid uint32)
+	visit = func(oid uint32) {
+		t, ok := r.Types[oid]
+		if !ok || seen[oid] {
+			return
+		}
+		seen[oid] = true
+		if t.isArray() {
+			visit(t.Elem)
+			return
+		}
+		def := TypeDef{OID: oid, Schema: t.Schema, Name: t.Name}
+		for aoid, a := range r.Types {
+			if a.Elem == oid && a.isArray() && strings.HasPrefix(a.Name, "_") {
+				def.ArrayTypID = int(aoid)
+			}
+		}
+		switch {
+		case t.Kind == 'e':
+			def.Kind = "enum"
+			def.Labels = r.enumLabels(oid)
+		case t.Kind == 'd':
+			visit(t.BaseType)
+			def.Kind, def.BaseTypID = "domain", int(t.BaseType)
+			def.BaseType = r.TypeName(def.BaseTypID)
+		case t.Kind == 'c' && !t.RowType:
+			def.Kind = "composite"
+			for _, a := range r.Attrs[t.RelID] {
+				visit(uint32(a.TypID))
+				def.Attributes = append(def.Attributes, ColumnInfo{Name: a.Name, Type: r.TypeName(a.TypID), TypID: a.TypID})
+			}
+		default:
+			return
+		}
+		defs = append(defs, def)
+	}
+	for _, oid := range typIDs {
+		visit(uint32(oid))
+	}
+	return defs
+}
+
+// enumLabels returns an enum's labels in enumsortorder
+func (r *TypeRegistry) enumLabels(typOID uint32) []string {
+	var enums []EnumInfo
+	for _, e := range r.Enums {
+		if e.TypeOID == typOID {
+			enums = append(enums, e)
+		}
+	}
+	sort.Slice(enums, func(i, j int) bool { return enums[i].SortOrder < enums[j].SortOrder })
+	labels := make([]string, len(enums))
+	for i, e := range enums {
+		labels[i] = e.Label
+	}
+	return labels
+}
+
+func firstByte(s string) byte {
+	if s == "" {
+		return 0
+	}
+	return s[0]
+}
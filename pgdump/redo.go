@@ -0,0 +1,1012 @@
+package pgdump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedoTarget is where offline redo stops, like recovery_target_lsn and
+// recovery_target_time with recovery_target_inclusive
+type RedoTarget struct {
+	LSN    uint64    // replay records starting at or before this LSN (0 = no limit)
+	Time   time.Time // stop before the first commit or abort after this time (zero = no limit)
+	WALDir string    // WAL to replay, e.g. an archive (default <data dir>/pg_wal)
+}
+
+// RedoResult is what offline redo rebuilt
+type RedoResult struct {
+	Relations []*RedoRelation `json:"relations"`
+	Records   int             `json:"records"`              // records read up to the target
+	LastLSN   string          `json:"last_lsn,omitempty"`   // last record replayed
+	StoppedAt string          `json:"stopped_at,omitempty"` // first record past the target, empty at end of WAL
+
+	xacts *walXacts
+	xids  map[uint32]bool // transactions that wrote records up to the target
+	later map[uint32]bool // transactions that ended after the target
+}
+
+// RedoRelation is the main fork of a relation rebuilt in memory: its file
+// with WAL replayed on top. Blocks without a full-page image in the WAL
+// start from their state on disk, which may be newer than the target
+type RedoRelation struct {
+	Relation RelFileNode `json:"relation"`
+	Path     string      `json:"path"`
+	Blocks   int         `json:"blocks"`
+	Restored int         `json:"restored"` // full-page images installed
+	Applied  int         `json:"applied"`  // heap records replayed
+	Skipped  int         `json:"skipped"`  // records redo does not replay, e.g. index or visibility map changes
+	Errors   []string    `json:"errors,omitempty"`
+
+	pages [][]byte
+}
+
+// xl_heap_* flags and sizes used by redo (heapam_xlog.h)
+const (
+	xlhInsertAllVisibleCleared    = 1 << 0
+	xlhUpdateOldAllVisibleCleared = 1 << 0
+	xlhUpdateNewAllVisibleCleared = 1 << 1
+	xlhDeleteAllVisibleCleared    = 1 << 0
+	xlhDeleteIsSuper              = 1 << 3
+	xlhFreezeXvac                 = 0x02
+	xlhInvalidXvac                = 0x04
+
+	sizeOfHeapLock        = 8
+	sizeOfHeapFreezeTuple = 12 // xl_heap_freeze_tuple before PG 16
+	sizeOfHeapFreezePlan  = 12 // xl_heap_freeze_plan from PG 16
+
+	xlogSmgrTruncate = 0x20
+	smgrTruncateHeap = 0x0001
+)
+
+// Page header flags (pd_flags)
+const (
+	pdHasFreeLines = 0x0001
+	pdAllVisible   = 0x0004
+)
+
+// heapXmaxBits are the infomask bits describing xmax (HEAP_XMAX_BITS)
+const heapXmaxBits = HeapXmaxCommitted | HeapXmaxInvalid | HeapXmaxIsMulti | heapLockMask | HeapXmaxLockOnly
+
+// RedoHeap rebuilds relations by replaying WAL onto their files in
+// dataDir (a live data directory or a copy of a base backup) up to
+// target, entirely in memory. Heap inserts, updates, deletes, locks,
+// pruning, vacuuming and freezing are replayed, as are full-page images
+// and truncation; a record is replayed on a block only if the page LSN
+// is older, as in recovery. A relation's SpcOID may be 0 for its
+// database's default tablespace
+func RedoHeap(dataDir string, rels []RelFileNode, target *RedoTarget) (*RedoResult, error) {
+	if target == nil {
+		target = &RedoTarget{}
+	}
+	walDir := target.WALDir
+	if walDir == "" {
+		walDir = filepath.Join(dataDir, "pg_wal")
+	}
+
+	res := &RedoResult{xacts: newWALXacts(), xids: make(map[uint32]bool), later: make(map[uint32]bool)}
+	e := &redoEngine{rels: make(map[redoKey]*RedoRelation)}
+	locs := make(map[uint32]*relationLocator)
+	for _, rel := range rels {
+		if locs[rel.DbOID] == nil {
+			locs[rel.DbOID] = newLocalLocator(dataDir, rel.DbOID)
+		}
+		r := &RedoRelation{Relation: rel, Path: locs[rel.DbOID].path(rel.SpcOID, rel.RelOID)}
+		data, err := readSegments(r.Path, DefaultSegmentSize, os.ReadFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for off := 0; off+PageSize <= len(data); off += PageSize {
+			r.pages = append(r.pages, data[off:off+PageSize:off+PageSize])
+		}
+		e.rels[redoKey{rel.DbOID, rel.RelOID}] = r
+		res.Relations = append(res.Relations, r)
+	}
+
+	wr, err := NewWALReader(walDir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read WAL: %w", err)
+	}
+	for {
+		rec, err := wr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if res.StoppedAt != "" || target.past(rec) {
+			// Transactions ending after the target had not committed at it
+			if res.StoppedAt == "" {
+				res.StoppedAt = FormatLSN(rec.LSN)
+			}
+			if xids, _, ok := xactEnd(rec); ok {
+				for _, xid := range xids {
+					res.later[xid] = true
+				}
+			}
+			continue
+		}
+		res.Records++
+		res.LastLSN = FormatLSN(rec.LSN)
+		res.xacts.add(rec)
+		if rec.TransactionID != InvalidXID {
+			res.xids[rec.TransactionID] = true
+		}
+		e.apply(rec)
+	}
+	for _, r := range res.Relations {
+		r.Blocks = len(r.pages)
+	}
+	return res, nil
+}
+
+// xactEnd returns the transactions a commit or abort record ends
+func xactEnd(rec *WALRecord) (xids []uint32, at time.Time, ok bool) {
+	if rec.ResourceMgr != RM_XACT_ID {
+		return nil, at, false
+	}
+	switch rec.Info & 0x70 {
+	case XLOG_XACT_COMMIT, XLOG_XACT_ABORT, XLOG_XACT_COMMIT_PREPARED, XLOG_XACT_ABORT_PREPARED:
+		xids, at = parseXactRecord(rec)
+		return xids, at, len(xids) > 0
+	}
+	return nil, at, false
+}
+
+// past reports whether rec lies beyond the target
+func (t *RedoTarget) past(rec *WALRecord) bool {
+	if t.LSN != 0 && rec.LSN > t.LSN {
+		return true
+	}
+	if t.Time.IsZero() {
+		return false
+	}
+	_, at, ok := xactEnd(rec)
+	return ok && at.After(t.Time)
+}
+
+// RedoRelationFile rebuilds one relation file of a data directory or base
+// backup copy. The data directory and relation are found from the path:
+// base/<db>/<filenode>, global/<filenode> or
+// pg_tblspc/<spc>/<version>/<db>/<filenode>
+func RedoRelationFile(path string, target *RedoTarget) (*RedoRelation, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	filenode, seg, ok := parseSegmentName(filepath.Base(abs))
+	if !ok || seg != 0 {
+		return nil, fmt.Errorf("%s is not the first segment of a relation's main fork", path)
+	}
+
+	dir := filepath.Dir(abs)
+	up := func(p string, n int) string {
+		for ; n > 0; n-- {
+			p = filepath.Dir(p)
+		}
+		return p
+	}
+	var rel RelFileNode
+	var dataDir string
+	if filepath.Base(dir) == "global" {
+		rel, dataDir = RelFileNode{SpcOID: GlobalTablespace, RelOID: filenode}, up(dir, 1)
+	} else {
+		db, err := strconv.ParseUint(filepath.Base(dir), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("cannot tell the database of %s", path)
+		}
+		rel = RelFileNode{DbOID: uint32(db), RelOID: filenode}
+		switch {
+		case filepath.Base(up(dir, 1)) == "base":
+			rel.SpcOID, dataDir = DefaultTablespace, up(dir, 2)
+		case filepath.Base(up(dir, 3)) == "pg_tblspc":
+			spc, err := strconv.ParseUint(filepath.Base(up(dir, 2)), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("cannot tell the tablespace of %s", path)
+			}
+			rel.SpcOID, dataDir = uint32(spc), up(dir, 4)
+		default:
+			return nil, fmt.Errorf("cannot tell the data directory of %s", path)
+		}
+	}
+
+	res, err := RedoHeap(dataDir, []RelFileNode{rel}, target)
+	if err != nil {
+		return nil, err
+	}
+	return res.Relations[0], nil
+}
+
+// Data returns the rebuilt relation, all blocks in order
+func (r *RedoRelation) Data() []byte {
+	data := make([]byte, 0, len(r.pages)*PageSize)
+	for _, p := range r.pages {
+		data = append(data, p...)
+	}
+	return data
+}
+
+// DumpBlockRange describes rebuilt blocks the way DumpBlockRange describes
+// the blocks of a file
+func (r *RedoRelation) DumpBlockRange(br *BlockRange) []BlockInfo {
+	start, end := 0, len(r.pages)-1
+	if br != nil && br.Start >= 0 {
+		start = br.Start
+	}
+	if br != nil && br.End >= 0 && br.End < end {
+		end = br.End
+	}
+	var blocks []BlockInfo
+	for b := start; b <= end; b++ {
+		if info := ParseBlockInfo(r.pages[b], uint32(b)); info != nil {
+			blocks = append(blocks, *info)
+		}
+	}
+	return blocks
+}
+
+func (r *RedoRelation) errorf(rec *WALRecord, format string, args ...interface{}) {
+	r.Errors = append(r.Errors, FormatLSN(rec.LSN)+": "+fmt.Sprintf(format, args...))
+}
+
+// setPage stores a block, extending the relation with empty pages
+func (r *RedoRelation) setPage(block uint32, page []byte) {
+	for uint32(len(r.pages)) <= block {
+		r.pages = append(r.pages, make([]byte, PageSize))
+	}
+	r.pages[block] = page
+}
+
+// overlay returns a file reader that serves the rebuilt relations in
+// place of their files
+func (res *RedoResult) overlay(read func(string) ([]byte, error)) func(string) ([]byte, error) {
+	byPath := make(map[string]*RedoRelation)
+	for _, r := range res.Relations {
+		byPath[r.Path] = r
+	}
+	return func(path string) ([]byte, error) {
+		if r := byPath[path]; r != nil {
+			return r.Data(), nil
+		}
+		if i := strings.LastIndexByte(path, '.'); i > 0 && byPath[path[:i]] != nil {
+			return nil, os.ErrNotExist // Data holds every segment
+		}
+		return read(path)
+	}
+}
+
+// commitLog returns a CommitLog for dataDir that reports transactions as
+// they stood at the target: those WAL shows ending by then as committed or
+// aborted, the others that wrote WAL or ended after the target as aborted
+// since they never committed before it
+func (res *RedoResult) commitLog(dataDir string) *CommitLog {
+	status := make(map[uint32]XactStatus)
+	for xid := range res.later {
+		status[xid] = XactAborted
+	}
+	for xid := range res.xids {
+		status[xid] = XactAborted
+		if s, _ := res.xacts.resolve(xid); s == XactCommitted {
+			status[xid] = s
+		}
+	}
+	for xid, s := range res.xacts.status {
+		status[xid] = s
+	}
+
+	return NewCommitLog(func(path string) ([]byte, error) {
+		data, err := os.ReadFile(filepath.Join(dataDir, path))
+		dir, name, _ := strings.Cut(path, "/")
+		segno, perr := strconv.ParseUint(name, 16, 32)
+		if dir != "pg_xact" || perr != nil {
+			return data, err
+		}
+		var patched []byte
+		for xid, s := range status {
+			if uint64(xid/xactsPerSegment) != segno {
+				continue
+			}
+			if patched == nil {
+				patched = append([]byte(nil), data...)
+			}
+			off := int(xid%xactsPerSegment) / 4
+			if off >= len(patched) {
+				patched = append(patched, make([]byte, align(off+1, PageSize)-len(patched))...)
+			}
+			shift := (xid % 4) * 2
+			patched[off] = patched[off]&^(3<<shift) | byte(s)<<shift
+		}
+		if patched == nil {
+			return data, err
+		}
+		return patched, nil
+	})
+}
+
+// redoTables replays WAL onto the tables of a database that opts selects
+// and their TOAST tables. xact reads pg_namespace (nil = hint bits)
+func redoTables(dataDir string, dbOID uint32, tables map[uint32]TableInfo, reader FileReader, xact *CommitLog, opts *Options) (*RedoResult, error) {
+	ResolveSchemas(tables, reader, xact)
+	byOID := make(map[uint32]uint32)
+	for filenode, info := range tables {
+		byOID[info.OID] = filenode
+	}
+	var rels []RelFileNode
+	for filenode, info := range tables {
+		if !opts.wantTable(info) {
+			continue
+		}
+		rels = append(rels, RelFileNode{SpcOID: info.Tablespace, DbOID: dbOID, RelOID: filenode})
+		if toast, ok := byOID[info.ToastRelID]; ok && info.ToastRelID != 0 {
+			rels = append(rels, RelFileNode{SpcOID: tables[toast].Tablespace, DbOID: dbOID, RelOID: toast})
+		}
+	}
+	return RedoHeap(dataDir, rels, opts.Redo)
+}
+
+// redoKey identifies a relation in WAL; tablespaces are left out since
+// pg_class and WAL name the default one differently
+type redoKey struct {
+	db, relnode uint32
+}
+
+type redoEngine struct {
+	rels map[redoKey]*RedoRelation
+}
+
+// relation returns the rebuilt relation a block reference is to, nil for
+// other relations and forks
+func (e *redoEngine) relation(blk *WALBlockRef) *RedoRelation {
+	if blk.RelFileNode == nil || blk.ForkNum != 0 {
+		return nil
+	}
+	return e.rels[redoKey{blk.RelFileNode.DbOID, blk.RelFileNode.RelOID}]
+}
+
+// redoRecord is a record being replayed
+type redoRecord struct {
+	e        *redoEngine
+	rec      *WALRecord
+	restored map[uint8]bool // blocks installed from images
+	applied  map[*RedoRelation]bool
+}
+
+// apply replays one record
+func (e *redoEngine) apply(rec *WALRecord) {
+	if rec.ResourceMgr == RM_SMGR_ID && rec.Info&0x70 == xlogSmgrTruncate {
+		e.truncate(rec)
+		return
+	}
+
+	// Images first: a block restored from one is in its state after the
+	// record (BLK_RESTORED)
+	r := &redoRecord{e: e, rec: rec, restored: make(map[uint8]bool), applied: make(map[*RedoRelation]bool)}
+	var touched []*RedoRelation
+	for i := range rec.Blocks {
+		blk := &rec.Blocks[i]
+		rel := e.relation(blk)
+		if rel == nil {
+			continue
+		}
+		touched = append(touched, rel)
+		if blk.Image == nil || !blk.Image.Apply {
+			continue
+		}
+		page, err := blk.Image.Page()
+		if err != nil {
+			rel.errorf(rec, "block %d: %v", blk.BlockNum, err)
+			continue
+		}
+		if !isZeroPage(page) {
+			setPageLSN(page, rec.end)
+		}
+		rel.setPage(blk.BlockNum, page)
+		rel.Restored++
+		r.restored[blk.ID] = true
+	}
+	if len(touched) == 0 {
+		return
+	}
+
+	if !r.heap() && len(r.restored) == 0 {
+		for _, rel := range touched {
+			rel.Skipped++
+		}
+	}
+	for rel := range r.applied {
+		rel.Applied++
+	}
+}
+
+// truncate replays XLOG_SMGR_TRUNCATE: blkno, relation, flags
+func (e *redoEngine) truncate(rec *WALRecord) {
+	d := rec.MainData
+	if len(d) < 20 || u32(d, 16)&smgrTruncateHeap == 0 {
+		return
+	}
+	if rel := e.rels[redoKey{u32(d, 8), u32(d, 12)}]; rel != nil && int(u32(d, 0)) < len(rel.pages) {
+		rel.pages = rel.pages[:u32(d, 0)]
+		rel.Applied++
+	}
+}
+
+// heap replays heap and heap2 records; false for the records it does not
+// handle
+func (r *redoRecord) heap() bool {
+	op := r.rec.Info & 0x70
+	switch r.rec.ResourceMgr {
+	case RM_HEAP_ID:
+		switch op {
+		case XLOG_HEAP_INSERT:
+			r.insert()
+		case XLOG_HEAP_DELETE:
+			r.delete()
+		case XLOG_HEAP_UPDATE, XLOG_HEAP_HOT_UPDATE:
+			r.update(op == XLOG_HEAP_HOT_UPDATE)
+		case XLOG_HEAP_LOCK:
+			r.lock()
+		default:
+			return false
+		}
+	case RM_HEAP2_ID:
+		// Before PG 14 0x10 was XLOG_HEAP2_CLEAN, laid out like PRUNE, and
+		// 0x20 FREEZE_PAGE
+		switch {
+		case op == XLOG_HEAP2_MULTI_INSERT:
+			r.multiInsert()
+		case op == XLOG_HEAP2_PRUNE:
+			r.prune()
+		case op == XLOG_HEAP2_VACUUM && r.rec.version >= 14:
+			r.vacuum()
+		case op == XLOG_HEAP2_VACUUM || op == XLOG_HEAP2_FREEZE_PAGE && r.rec.version >= 14:
+			r.freeze()
+		case op == XLOG_HEAP2_VISIBLE:
+			r.visible()
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// block returns the page of block reference id to replay the record on,
+// nil when it is not rebuilt, was restored from an image or already has
+// the change (XLogReadBufferForRedo). init formats a new page
+func (r *redoRecord) block(id uint8, init bool) (*RedoRelation, *WALBlockRef, []byte) {
+	blk := r.ref(id)
+	if blk == nil || r.restored[id] {
+		return nil, nil, nil
+	}
+	rel := r.e.relation(blk)
+	if rel == nil {
+		return nil, nil, nil
+	}
+	if init || blk.WillInit {
+		page := make([]byte, PageSize)
+		initHeapPage(page)
+		rel.setPage(blk.BlockNum, page)
+		return rel, blk, page
+	}
+	if int(blk.BlockNum) >= len(rel.pages) {
+		rel.errorf(r.rec, "block %d past the end of the relation", blk.BlockNum)
+		return nil, nil, nil
+	}
+	page := rel.pages[blk.BlockNum]
+	if pageLSN(page) >= r.rec.end {
+		return nil, nil, nil
+	}
+	return rel, blk, page
+}
+
+// ref returns block reference id of the record, nil if it has none
+func (r *redoRecord) ref(id uint8) *WALBlockRef {
+	for i := range r.rec.Blocks {
+		if r.rec.Blocks[i].ID == id {
+			return &r.rec.Blocks[i]
+		}
+	}
+	return nil
+}
+
+// done stamps a page with the record's end LSN
+func (r *redoRecord) done(rel *RedoRelation, page []byte) {
+	setPageLSN(page, r.rec.end)
+	r.applied[rel] = true
+}
+
+// tuple returns the LP_NORMAL tuple at offnum of a page being replayed
+func (r *redoRecord) tuple(rel *RedoRelation, blk *WALBlockRef, page []byte, offnum uint16) []byte {
+	tup := pageTuple(page, offnum)
+	if tup == nil {
+		rel.errorf(r.rec, "block %d: no tuple at item %d", blk.BlockNum, offnum)
+	}
+	return tup
+}
+
+// newTuple builds a tuple written by the record from an xl_heap_header
+// and the bytes after the fixed header
+func (r *redoRecord) newTuple(header, rest []byte, xmax uint32, block uint32, offnum uint16) []byte {
+	tup := walTupleBytes(r.rec.TransactionID, xmax, header, rest)
+	setTupleCtid(tup, block, offnum)
+	return tup
+}
+
+func (r *redoRecord) insert() {
+	d := r.rec.MainData
+	rel, blk, page := r.block(0, r.rec.Info&XLOG_HEAP_INIT_PAGE != 0)
+	if page == nil {
+		return
+	}
+	if len(d) < sizeOfHeapInsert || len(blk.Data) < sizeOfHeapHeader {
+		rel.errorf(r.rec, "insert record truncated")
+		return
+	}
+	offnum := u16(d, 0)
+	tup := r.newTuple(blk.Data, blk.Data[sizeOfHeapHeader:], 0, blk.BlockNum, offnum)
+	if err := pageAddItem(page, offnum, tup); err != nil {
+		rel.errorf(r.rec, "block %d: %v", blk.BlockNum, err)
+		return
+	}
+	if d[2]&xlhInsertAllVisibleCleared != 0 {
+		clearAllVisible(page)
+	}
+	r.done(rel, page)
+}
+
+func (r *redoRecord) multiInsert() {
+	d := r.rec.MainData
+	initPage := r.rec.Info&XLOG_HEAP_INIT_PAGE != 0
+	rel, blk, page := r.block(0, initPage)
+	if page == nil {
+		return
+	}
+	if len(d) < sizeOfHeapMultiInsert {
+		rel.errorf(r.rec, "multi-insert record truncated")
+		return
+	}
+	n := int(u16(d, 2))
+	pos := 0
+	for i := 0; i < n; i++ {
+		offnum := uint16(i + 1)
+		if !initPage {
+			if sizeOfHeapMultiInsert+2*i+2 > len(d) {
+				rel.errorf(r.rec, "multi-insert record truncated")
+				return
+			}
+			offnum = u16(d, sizeOfHeapMultiInsert+2*i)
+		}
+		pos = (pos + 1) &^ 1
+		if pos+sizeOfMultiInsertTuple > len(blk.Data) {
+			rel.errorf(r.rec, "multi-insert tuple %d truncated", i)
+			return
+		}
+		size := int(u16(blk.Data, pos))
+		start := pos + sizeOfMultiInsertTuple
+		if start+size > len(blk.Data) {
+			rel.errorf(r.rec, "multi-insert tuple %d truncated", i)
+			return
+		}
+		tup := r.newTuple(blk.Data[pos+2:], blk.Data[start:start+size], 0, blk.BlockNum, offnum)
+		if err := pageAddItem(page, offnum, tup); err != nil {
+			rel.errorf(r.rec, "block %d: %v", blk.BlockNum, err)
+			return
+		}
+		pos = start + size
+	}
+	if d[0]&xlhInsertAllVisibleCleared != 0 {
+		clearAllVisible(page)
+	}
+	r.done(rel, page)
+}
+
+func (r *redoRecord) delete() {
+	d := r.rec.MainData
+	rel, blk, page := r.block(0, false)
+	if page == nil {
+		return
+	}
+	if len(d) < sizeOfHeapDelete {
+		rel.errorf(r.rec, "delete record truncated")
+		return
+	}
+	xmax, offnum, infobits, flags := u32(d, 0), u16(d, 4), d[6], d[7]
+	tup := r.tuple(rel, blk, page, offnum)
+	if tup == nil {
+		return
+	}
+	setTupleXmax(tup, xmax, infobits)
+	setU16(tup, 18, u16(tup, 18)&^HeapHotUpdated)
+	if flags&xlhDeleteIsSuper != 0 {
+		binary.LittleEndian.PutUint32(tup[0:], InvalidXID)
+	}
+	setTupleCtid(tup, blk.BlockNum, offnum)
+	setPagePrunable(page, r.rec.TransactionID)
+	if flags&xlhDeleteAllVisibleCleared != 0 {
+		clearAllVisible(page)
+	}
+	r.done(rel, page)
+}
+
+// update replays heap_xlog_update: the old version on block 1 (block 0
+// when on the same page) gets xmax, the new one is added to block 0
+func (r *redoRecord) update(hot bool) {
+	d := r.rec.MainData
+	if len(d) < sizeOfHeapUpdate {
+		return
+	}
+	oldXmax, oldOff, infobits, flags := u32(d, 0), u16(d, 4), d[6], d[7]
+	newXmax, newOff := u32(d, 8), u16(d, 12)
+	newBlk, oldID := r.ref(0), uint8(0)
+	if r.ref(1) != nil {
+		oldID = 1
+	}
+	if newBlk == nil {
+		return
+	}
+
+	oldRel, oldBlk, oldPage := r.block(oldID, false)
+	var oldTup []byte
+	if oldPage != nil {
+		if oldTup = r.tuple(oldRel, oldBlk, oldPage, oldOff); oldTup != nil {
+			setTupleXmax(oldTup, oldXmax, infobits)
+			if hot {
+				setU16(oldTup, 18, u16(oldTup, 18)|HeapHotUpdated)
+			} else {
+				setU16(oldTup, 18, u16(oldTup, 18)&^HeapHotUpdated)
+			}
+			setTupleCtid(oldTup, newBlk.BlockNum, newOff)
+			setPagePrunable(oldPage, r.rec.TransactionID)
+			if flags&xlhUpdateOldAllVisibleCleared != 0 {
+				clearAllVisible(oldPage)
+			}
+		}
+	} else if rel := r.e.relation(r.ref(oldID)); rel != nil && int(r.ref(oldID).BlockNum) < len(rel.pages) {
+		// Restored or already replayed: the old version is still there
+		oldTup = pageTuple(rel.pages[r.ref(oldID).BlockNum], oldOff)
+	}
+
+	rel, blk, page := oldRel, oldBlk, oldPage
+	if oldID != 0 {
+		if oldPage != nil {
+			r.done(oldRel, oldPage)
+		}
+		rel, blk, page = r.block(0, r.rec.Info&XLOG_HEAP_INIT_PAGE != 0)
+	}
+	if page == nil {
+		return
+	}
+	data := blk.Data
+	prefix, suffix := 0, 0
+	if flags&xlhUpdatePrefixFromOld != 0 && len(data) >= 2 {
+		prefix, data = int(u16(data, 0)), data[2:]
+	}
+	if flags&xlhUpdateSuffixFromOld != 0 && len(data) >= 2 {
+		suffix, data = int(u16(data, 0)), data[2:]
+	}
+	if len(data) < sizeOfHeapHeader {
+		rel.errorf(r.rec, "update record truncated")
+		return
+	}
+	rest := rebuildUpdatedTuple(data[:sizeOfHeapHeader], data[sizeOfHeapHeader:], oldTup, prefix, suffix)
+	if rest == nil {
+		rel.errorf(r.rec, "block %d: new tuple shares bytes with a missing old tuple", blk.BlockNum)
+		return
+	}
+	tup := r.newTuple(data[:sizeOfHeapHeader], rest, newXmax, blk.BlockNum, newOff)
+	if err := pageAddItem(page, newOff, tup); err != nil {
+		rel.errorf(r.rec, "block %d: %v", blk.BlockNum, err)
+		return
+	}
+	if flags&xlhUpdateNewAllVisibleCleared != 0 {
+		clearAllVisible(page)
+	}
+	r.done(rel, page)
+}
+
+// lock replays heap_xlog_lock: locker, offnum, infobits
+func (r *redoRecord) lock() {
+	d := r.rec.MainData
+	rel, blk, page := r.block(0, false)
+	if page == nil || len(d) < sizeOfHeapLock {
+		return
+	}
+	offnum := u16(d, 4)
+	tup := r.tuple(rel, blk, page, offnum)
+	if tup == nil {
+		return
+	}
+	setTupleXmax(tup, u32(d, 0), d[6])
+	if xmaxLockOnly(u16(tup, 20)) {
+		setU16(tup, 18, u16(tup, 18)&^HeapHotUpdated)
+		setTupleCtid(tup, blk.BlockNum, offnum)
+	}
+	r.done(rel, page)
+}
+
+// prune replays XLOG_HEAP2_PRUNE (CLEAN before PG 14): redirected item
+// pairs, then dead and unused items, then the page is defragmented
+func (r *redoRecord) prune() {
+	d := r.rec.MainData
+	rel, blk, page := r.block(0, false)
+	if page == nil {
+		return
+	}
+	size := 8 // snapshotConflictHorizon, nredirected, ndead; PG 16 adds isCatalogRel
+	if r.rec.version >= 16 {
+		size = 9
+	}
+	if len(d) < size {
+		rel.errorf(r.rec, "prune record truncated")
+		return
+	}
+	nredirected, ndead := int(u16(d, 4)), int(u16(d, 6))
+	offs := blk.Data
+	if len(offs) < 4*nredirected+2*ndead || len(offs)%2 != 0 {
+		rel.errorf(r.rec, "prune record truncated")
+		return
+	}
+	for i := 0; i < len(offs)/2; i++ {
+		var err error
+		switch {
+		case i < 2*nredirected:
+			err = setItemID(page, u16(offs, 2*i), int(u16(offs, 2*i+2)), lpRedirect, 0)
+			i++
+		case i < 2*nredirected+ndead:
+			err = setItemID(page, u16(offs, 2*i), 0, lpDead, 0)
+		default:
+			err = setItemID(page, u16(offs, 2*i), 0, lpUnused, 0)
+		}
+		if err != nil {
+			rel.errorf(r.rec, "block %d: %v", blk.BlockNum, err)
+			return
+		}
+	}
+	repairFragmentation(page)
+	r.done(rel, page)
+}
+
+// vacuum replays XLOG_HEAP2_VACUUM: dead items become unused and the line
+// pointer array loses its unused tail
+func (r *redoRecord) vacuum() {
+	rel, blk, page := r.block(0, false)
+	if page == nil {
+		return
+	}
+	for pos := 0; pos+2 <= len(blk.Data); pos += 2 {
+		if err := setItemID(page, u16(blk.Data, pos), 0, lpUnused, 0); err != nil {
+			rel.errorf(r.rec, "block %d: %v", blk.BlockNum, err)
+			return
+		}
+	}
+	truncateLinePointers(page)
+	r.done(rel, page)
+}
+
+// freeze replays XLOG_HEAP2_FREEZE_PAGE: per tuple xl_heap_freeze_tuple
+// entries before PG 16, freeze plans followed by their offsets since
+func (r *redoRecord) freeze() {
+	rel, blk, page := r.block(0, false)
+	if page == nil {
+		return
+	}
+	d, data := r.rec.MainData, blk.Data
+	if len(d) < 6 {
+		rel.errorf(r.rec, "freeze record truncated")
+		return
+	}
+	n := int(u16(d, 4))
+	apply := func(offnum uint16, xmax uint32, infomask2, infomask uint16, flags uint8) bool {
+		tup := r.tuple(rel, blk, page, offnum)
+		if tup == nil {
+			return false
+		}
+		setU16(tup, 18, infomask2)
+		setU16(tup, 20, infomask)
+		binary.LittleEndian.PutUint32(tup[4:], xmax)
+		if flags&xlhFreezeXvac != 0 {
+			binary.LittleEndian.PutUint32(tup[8:], FrozenXID)
+		}
+		if flags&xlhInvalidXvac != 0 {
+			binary.LittleEndian.PutUint32(tup[8:], InvalidXID)
+		}
+		return true
+	}
+
+	if r.rec.version < 16 {
+		if len(data) < n*sizeOfHeapFreezeTuple {
+			rel.errorf(r.rec, "freeze record truncated")
+			return
+		}
+		for i := 0; i < n; i++ {
+			e := data[i*sizeOfHeapFreezeTuple:]
+			if !apply(u16(e, 4), u32(e, 0), u16(e, 6), u16(e, 8), e[10]) {
+				return
+			}
+		}
+	} else {
+		offs := n * sizeOfHeapFreezePlan
+		if len(data) < offs {
+			rel.errorf(r.rec, "freeze record truncated")
+			return
+		}
+		for i := 0; i < n; i++ {
+			plan := data[i*sizeOfHeapFreezePlan:]
+			for j := 0; j < int(u16(plan, 10)); j++ {
+				if offs+2 > len(data) {
+					rel.errorf(r.rec, "freeze record truncated")
+					return
+				}
+				if !apply(u16(data, offs), u32(plan, 0), u16(plan, 4), u16(plan, 6), plan[8]) {
+					return
+				}
+				offs += 2
+			}
+		}
+	}
+	r.done(rel, page)
+}
+
+// visible replays the heap page half of XLOG_HEAP2_VISIBLE (block 1;
+// block 0 is the visibility map page)
+func (r *redoRecord) visible() {
+	if rel, _, page := r.block(1, false); page != nil {
+		setU16(page, 10, u16(page, 10)|pdAllVisible)
+		r.done(rel, page)
+	}
+}
+
+// pageLSN reads pd_lsn, stored as two uint32 halves (PageXLogRecPtr)
+func pageLSN(page []byte) uint64 {
+	return uint64(u32(page, 0))<<32 | uint64(u32(page, 4))
+}
+
+func setPageLSN(page []byte, lsn uint64) {
+	binary.LittleEndian.PutUint32(page[0:], uint32(lsn>>32))
+	binary.LittleEndian.PutUint32(page[4:], uint32(lsn))
+}
+
+// initHeapPage formats an empty heap page (PageInit)
+func initHeapPage(page []byte) {
+	clear(page)
+	setU16(page, 12, headerSize)
+	setU16(page, 14, PageSize)
+	setU16(page, 16, PageSize)
+	setU16(page, 18, PageSize|4)
+}
+
+func clearAllVisible(page []byte) {
+	setU16(page, 10, u16(page, 10)&^pdAllVisible)
+}
+
+// setPagePrunable records xid in pd_prune_xid if older than what it holds
+func setPagePrunable(page []byte, xid uint32) {
+	if old := u32(page, 20); old == InvalidXID || xidPrecedesOrEquals(xid, old) {
+		binary.LittleEndian.PutUint32(page[20:], xid)
+	}
+}
+
+func setU16(data []byte, off int, v uint16) {
+	binary.LittleEndian.PutUint16(data[off:], v)
+}
+
+// lineCount returns the number of line pointers of a page
+func lineCount(page []byte) int {
+	return (int(u16(page, 12)) - headerSize) / itemIDSize
+}
+
+// setItemID sets line pointer offnum
+func setItemID(page []byte, offnum uint16, off, flags, length int) error {
+	if offnum == 0 || int(offnum) > lineCount(page) {
+		return fmt.Errorf("item %d past %d line pointers", offnum, lineCount(page))
+	}
+	binary.LittleEndian.PutUint32(page[headerSize+(int(offnum)-1)*itemIDSize:], uint32(off)|uint32(flags)<<15|uint32(length)<<17)
+	return nil
+}
+
+// pageAddItem places a tuple at offnum like PageAddItem with
+// PAI_OVERWRITE in heap redo: offnum is an unused line pointer or the one
+// after the last
+func pageAddItem(page []byte, offnum uint16, item []byte) error {
+	lower, upper, n := int(u16(page, 12)), int(u16(page, 14)), lineCount(page)
+	switch {
+	case offnum == 0 || int(offnum) > n+1:
+		return fmt.Errorf("item %d past %d line pointers", offnum, n)
+	case int(offnum) <= n:
+		if lp := u32(page, headerSize+(int(offnum)-1)*itemIDSize); (lp>>15)&0x03 != lpUnused || lp>>17 != 0 {
+			return fmt.Errorf("item %d is in use", offnum)
+		}
+	default:
+		lower += itemIDSize
+	}
+	upper -= align(len(item), 8)
+	if upper < lower || upper+len(item) > PageSize {
+		return fmt.Errorf("no room for a %d-byte tuple", len(item))
+	}
+	copy(page[upper:], item)
+	setU16(page, 12, uint16(lower))
+	setU16(page, 14, uint16(upper))
+	return setItemID(page, offnum, upper, lpNormal, len(item))
+}
+
+// repairFragmentation packs the tuples of LP_NORMAL items against the
+// special space, as PageRepairFragmentation does after pruning. Free
+// space keeps the bytes it had
+func repairFragmentation(page []byte) {
+	type item struct {
+		offnum      uint16
+		off, length int
+	}
+	var items []item
+	unused := false
+	for i := 1; i <= lineCount(page); i++ {
+		lp := u32(page, headerSize+(i-1)*itemIDSize)
+		off, flags, length := int(lp&0x7FFF), int(lp>>15)&0x03, int(lp>>17)
+		switch {
+		case flags == lpNormal && length > 0 && off+length <= PageSize:
+			items = append(items, item{uint16(i), off, length})
+		case flags == lpUnused:
+			unused = true
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].off > items[j].off })
+
+	orig := append([]byte(nil), page...)
+	upper := int(u16(page, 16))
+	for _, it := range items {
+		upper -= align(it.length, 8)
+		copy(page[upper:], orig[it.off:it.off+it.length])
+		setItemID(page, it.offnum, upper, lpNormal, it.length)
+	}
+	setU16(page, 14, uint16(upper))
+	flags := u16(page, 10) &^ pdHasFreeLines
+	if unused {
+		flags |= pdHasFreeLines
+	}
+	setU16(page, 10, flags)
+}
+
+// truncateLinePointers drops the unused line pointers at the end of the
+// array (PageTruncateLinePointerArray)
+func truncateLinePointers(page []byte) {
+	n := lineCount(page)
+	for n > 0 && u32(page, headerSize+(n-1)*itemIDSize) == 0 {
+		n--
+	}
+	setU16(page, 12, uint16(headerSize+n*itemIDSize))
+}
+
+// setTupleXmax sets a tuple's xmax the way delete, update and lock redo
+// do: xmax bits cleared, then set from the record's infobits
+// (fix_infomask_from_infobits), and cmax FirstCommandId
+func setTupleXmax(tup []byte, xmax uint32, infobits uint8) {
+	infomask := u16(tup, 20) &^ (heapXmaxBits | HeapMovedOff | HeapMovedIn | HeapComboCID)
+	infomask2 := u16(tup, 18) &^ HeapKeysUpdated
+	for bit, mask := range map[uint8]uint16{0x01: HeapXmaxIsMulti, 0x02: HeapXmaxLockOnly, 0x04: HeapXmaxExclLock, 0x08: HeapXmaxKeyShrLock} {
+		if infobits&bit != 0 {
+			infomask |= mask
+		}
+	}
+	if infobits&0x10 != 0 {
+		infomask2 |= HeapKeysUpdated
+	}
+	setU16(tup, 18, infomask2)
+	setU16(tup, 20, infomask)
+	binary.LittleEndian.PutUint32(tup[4:], xmax)
+	binary.LittleEndian.PutUint32(tup[8:], 0)
+}
+
+// setTupleCtid sets t_ctid: block as two uint16 halves, then offnum
+func setTupleCtid(tup []byte, block uint32, offnum uint16) {
+	setU16(tup, 12, uint16(block>>16))
+	setU16(tup, 14, uint16(block))
+	setU16(tup, 16, offnum)
+}
@@ -0,0 +1,146 @@
+package pgdump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRedoHeap(t *testing.T) {
+	le16 := func(v uint16) []byte { return binary.LittleEndian.AppendUint16(nil, v) }
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	le64 := func(v int64) []byte { return binary.LittleEndian.AppendUint64(nil, uint64(v)) }
+	cat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	live := uint16(HeapXminCommitted | HeapXmaxInvalid)
+	user := func(id uint32, name string) []byte {
+		return makeHeapTuple(0, 0, 0, 2, nil, cat(le32(id), makeVarlena([]byte(name))))
+	}
+	logged := func(tuple []byte) []byte { return tuple[18:] }
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	commit := func(xid uint32, at time.Duration) []byte {
+		return walRecord(xid, RM_XACT_ID, XLOG_XACT_COMMIT, le64(start.Add(at).Sub(pgEpoch).Microseconds()))
+	}
+
+	// On disk: alice, written before the WAL kept here
+	dataDir := t.TempDir()
+	writeFiles(t, dataDir, map[string][]byte{
+		"global/1262": makeHeapPage(catalogTuple(1, 0, live, schemaPGDatabase, map[string][]byte{
+			"oid": le32(16384), "datname": nameDatum("shop"),
+		})),
+		"base/16384/1259": makeHeapPage(catalogTuple(100, 0, live, schemaPGClass, map[string][]byte{
+			"oid": le32(16385), "relname": nameDatum("users"), "relnamespace": le32(2200),
+			"relfilenode": le32(16385), "relkind": {'r'},
+		})),
+		"base/16384/1249": makeHeapPage(
+			catalogTuple(100, 0, live, schemaPGAttrV15, map[string][]byte{
+				"attrelid": le32(16385), "attname": nameDatum("id"), "atttypid": le32(OidInt4),
+				"attlen": le16(4), "attnum": le16(1), "attbyval": {1}, "attalign": {'i'},
+			}),
+			catalogTuple(100, 0, live, schemaPGAttrV15, map[string][]byte{
+				"attrelid": le32(16385), "attname": nameDatum("name"), "atttypid": le32(OidText),
+				"attlen": le16(0xFFFF), "attnum": le16(2), "attalign": {'i'},
+			}),
+		),
+		"base/16384/16385": makeHeapPage(makeHeapTuple(690, 0, live, 2, nil, cat(le32(1), makeVarlena([]byte("alice"))))),
+	})
+
+	users := RelFileNode{SpcOID: 1663, DbOID: 16384, RelOID: 16385}
+	b := newWALBuilder(DefaultWALSegSize, 1)
+	b.add(walBlockRecord(700, RM_HEAP_ID, XLOG_HEAP_INSERT, cat(le16(2), []byte{0}),
+		walBlock{users, 0, logged(user(2, "bob"))}))
+	b.add(commit(700, 0))
+	b.add(walBlockRecord(701, RM_HEAP_ID, XLOG_HEAP_HOT_UPDATE, cat(le32(701), le16(1), []byte{0, 0}, le32(0), le16(3)),
+		walBlock{users, 0, logged(user(1, "carol"))}))
+	commit701 := b.add(commit(701, time.Hour))
+	delete702 := b.add(walBlockRecord(702, RM_HEAP_ID, XLOG_HEAP_DELETE, cat(le32(702), le16(2), []byte{0, 0}),
+		walBlock{users, 0, nil}))
+	commit702 := b.add(commit(702, 2*time.Hour))
+	// Pruning leaves bob's item dead and compacts alice's old version and
+	// carol; dave is inserted by a transaction that never ends
+	b.add(walBlockRecord(0, RM_HEAP2_ID, XLOG_HEAP2_PRUNE, cat(le32(702), le16(0), le16(1), []byte{0}),
+		walBlock{users, 0, le16(2)}))
+	b.add(walBlockRecord(703, RM_HEAP_ID, XLOG_HEAP_INSERT|XLOG_HEAP_INIT_PAGE, cat(le16(1), []byte{0}),
+		walBlock{users, 1, logged(user(4, "dave"))}))
+	b.add(walBlockRecord(703, RM_HEAP_ID, XLOG_HEAP_INSERT, cat(le16(2), []byte{0}),
+		walBlock{users, 1, logged(user(5, "erin"))}))
+	writeFiles(t, filepath.Join(dataDir, "pg_wal"), b.files())
+
+	res, err := RedoHeap(dataDir, []RelFileNode{users}, &RedoTarget{LSN: commit701})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel := res.Relations[0]
+	if res.StoppedAt != FormatLSN(delete702) || rel.Blocks != 1 || rel.Applied != 2 || len(rel.Errors) != 0 {
+		t.Errorf("redo to %s = %+v, relation %+v", FormatLSN(commit701), res, rel)
+	}
+	page := rel.pages[0]
+	if lineCount(page) != 3 || pageLSN(page) != commit701 {
+		t.Errorf("block 0: %d items, LSN %s", lineCount(page), FormatLSN(pageLSN(page)))
+	}
+	if alice := pageTuple(page, 1); alice == nil || u32(alice, 4) != 701 || u16(alice, 18)&HeapHotUpdated == 0 || u16(alice, 16) != 3 {
+		t.Errorf("updated tuple = %x", alice)
+	}
+
+	res, err = RedoHeap(dataDir, []RelFileNode{users}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel = res.Relations[0]
+	if res.StoppedAt != "" || rel.Blocks != 2 || rel.Applied != 6 || len(rel.Errors) != 0 {
+		t.Errorf("full redo = %+v, relation %+v", res, rel)
+	}
+	if items := parseItems(rel.pages[0], parseHeader(rel.pages[0])); items[1].Flags != lpDead || u16(rel.pages[0], 10)&pdHasFreeLines != 0 {
+		t.Errorf("pruned items = %+v", items)
+	}
+	if blocks := rel.DumpBlockRange(&BlockRange{Start: 1, End: -1}); len(blocks) != 1 || blocks[0].BlockNumber != 1 {
+		t.Errorf("DumpBlockRange(1:) = %+v", blocks)
+	}
+	// The relation file itself is untouched
+	if data, _ := ReadRelationFile(filepath.Join(dataDir, "base/16384/16385")); len(data) != PageSize || lineCount(data) != 1 {
+		t.Error("redo changed the relation file")
+	}
+
+	dump := func(target *RedoTarget) []string {
+		t.Helper()
+		result, err := DumpDataDir(dataDir, &Options{TableFilter: "users", SkipSystemTables: true, Redo: target})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, db := range result.Databases {
+			for _, tbl := range db.Tables {
+				for _, row := range tbl.Rows {
+					names = append(names, row["name"].(string))
+				}
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+	for _, tt := range []struct {
+		target *RedoTarget
+		want   []string
+	}{
+		{&RedoTarget{LSN: commit701}, []string{"bob", "carol"}},
+		// The delete is replayed, but 702 commits after the target
+		{&RedoTarget{Time: start.Add(90 * time.Minute)}, []string{"bob", "carol"}},
+		{&RedoTarget{LSN: commit702}, []string{"carol"}},
+		{&RedoTarget{}, []string{"carol"}},
+	} {
+		if got := dump(tt.target); !slices.Equal(got, tt.want) {
+			t.Errorf("rows as of %+v = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+
+	file, err := RedoRelationFile(filepath.Join(dataDir, "base/16384/16385"), &RedoTarget{LSN: commit702})
+	if err != nil || file.Relation != users || file.Blocks != 1 {
+		t.Errorf("RedoRelationFile = %+v, %v", file, err)
+	}
+	if _, err := RedoRelationFile(filepath.Join(dataDir, "16385"), nil); err == nil {
+		t.Error("RedoRelationFile accepted a path outside a data directory")
+	}
+}
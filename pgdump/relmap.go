@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 )
 
 // RelMapMagic is the magic number for pg_filenode.map files
@@ -128,6 +129,48 @@ func (rm *RelMapFile) GetFilenode(oid uint32) uint32 {
 	return 0
 }
 
+// mappedFilenode looks up a catalog OID in the given relmaps, 0 if unmapped
+func mappedFilenode(oid uint32, maps []*RelMapFile) uint32 {
+	for _, rm := range maps {
+		if rm == nil {
+			continue
+		}
+		if fn := rm.GetFilenode(oid); fn != 0 {
+			return fn
+		}
+	}
+	return 0
+}
+
+// catalogFilenode returns the current filenode of a mapped catalog. It
+// differs from the OID once VACUUM FULL or CLUSTER rewrote the catalog
+func catalogFilenode(oid uint32, maps ...*RelMapFile) uint32 {
+	if fn := mappedFilenode(oid, maps); fn != 0 {
+		return fn
+	}
+	return oid
+}
+
+// loadRelMap parses a pg_filenode.map, nil if missing or invalid
+func loadRelMap(data []byte, err error) *RelMapFile {
+	if err != nil {
+		return nil
+	}
+	rm, err := ParseRelMapFile(data)
+	if err != nil {
+		return nil
+	}
+	return rm
+}
+
+// readGlobalCatalog reads a shared catalog (pg_database, pg_authid, ...)
+// at its current filenode in global/
+func readGlobalCatalog(dataDir string, oid uint32) ([]byte, error) {
+	rm := loadRelMap(os.ReadFile(filepath.Join(dataDir, "global", "pg_filenode.map")))
+	fn := strconv.FormatUint(uint64(catalogFilenode(oid, rm)), 10)
+	return ReadRelationFile(filepath.Join(dataDir, "global", fn))
+}
+
 // GetOID returns the OID for a filenode, or 0 if not found
 func (rm *RelMapFile) GetOID(filenode uint32) uint32 {
 	for _, m := range rm.Mappings {
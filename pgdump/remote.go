@@ -3,6 +3,7 @@ package pgdump
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -17,6 +18,12 @@ type RemoteClient struct {
 		databases []DatabaseInfo
 		tables    map[uint32]map[uint32]TableInfo
 		columns   map[uint32]map[uint32][]AttrInfo
+		toast     map[uint32]*TOASTReader
+		types     map[uint32]*TypeRegistry
+		locators  map[uint32]*relationLocator
+		spcs      []TablespaceInfo
+		xact      *CommitLog
+		xactSet   bool
 	}
 }
 
@@ -25,6 +32,9 @@ func NewRemoteClient(reader RemoteReader) *RemoteClient {
 	c := &RemoteClient{reader: reader}
 	c.cache.tables = make(map[uint32]map[uint32]TableInfo)
 	c.cache.columns = make(map[uint32]map[uint32][]AttrInfo)
+	c.cache.toast = make(map[uint32]*TOASTReader)
+	c.cache.types = make(map[uint32]*TypeRegistry)
+	c.cache.locators = make(map[uint32]*relationLocator)
 	if data, err := reader("PG_VERSION"); err == nil {
 		fmt.Sscanf(strings.TrimSpace(string(data)), "%d", &c.version)
 	}
@@ -75,6 +85,17 @@ func (d DatabasesResult) String() string {
 	return b.String()
 }
 
+type TablespacesResult []TablespaceInfo
+
+func (t TablespacesResult) String() string {
+	var b strings.Builder
+	b.WriteString("NAME                 OID\n")
+	for _, spc := range t {
+		b.WriteString(fmt.Sprintf("%-20s %d\n", spc.Name, spc.OID))
+	}
+	return b.String()
+}
+
 type TablesResult []TableInfo
 
 func (t TablesResult) String() string {
@@ -82,7 +103,7 @@ func (t TablesResult) String() string {
 	b.WriteString("NAME                           KIND   OID\n")
 	for _, tbl := range t {
 		if tbl.Kind == "r" {
-			b.WriteString(fmt.Sprintf("%-30s table  %d\n", tbl.Name, tbl.OID))
+			b.WriteString(fmt.Sprintf("%-30s table  %d\n", qualifiedName(tbl.Schema, tbl.Name), tbl.OID))
 		}
 	}
 	return b.String()
@@ -256,7 +277,7 @@ func (c *RemoteClient) Databases() []DatabaseInfo {
 		return c.cache.databases
 	}
 	if data, err := c.reader(fmt.Sprintf("global/%d", PGDatabase)); err == nil {
-		c.cache.databases = ParsePGDatabase(data)
+		c.cache.databases = parsePGDatabase(data, c.commitLog())
 	}
 	return c.cache.databases
 }
@@ -270,24 +291,103 @@ func (c *RemoteClient) Database(name string) *DatabaseInfo {
 	return nil
 }
 
+func (c *RemoteClient) Tablespaces() []TablespaceInfo {
+	if c.cache.spcs != nil {
+		return c.cache.spcs
+	}
+	if data, err := c.reader(fmt.Sprintf("global/%d", PGTablespace)); err == nil {
+		c.cache.spcs = ParsePGTablespace(data)
+	}
+	return c.cache.spcs
+}
+
+// locator resolves a database's directories remotely. The tablespace
+// version directory comes from PG_VERSION and pg_control since the
+// symlinks themselves cannot be listed
+func (c *RemoteClient) locator(dbOID uint32) *relationLocator {
+	if l, ok := c.cache.locators[dbOID]; ok {
+		return l
+	}
+	base := fmt.Sprintf("base/%d", dbOID)
+	l := &relationLocator{dbDir: base, baseDir: base, globalDir: "global", spcDirs: make(map[uint32]string)}
+	if ctrl := c.Control(); ctrl != nil {
+		versionDir := TablespaceVersionDir(c.Version(), ctrl.CatalogVersionNo)
+		for _, spc := range c.Tablespaces() {
+			if spc.OID != DefaultTablespace && spc.OID != GlobalTablespace {
+				l.spcDirs[spc.OID] = fmt.Sprintf("pg_tblspc/%d/%s/%d", spc.OID, versionDir, dbOID)
+			}
+		}
+	}
+	// Every database directory holds a PG_VERSION file
+	if _, err := c.reader(base + "/PG_VERSION"); err != nil {
+		for _, spc := range sortedKeys(l.spcDirs) {
+			if _, err := c.reader(l.spcDirs[spc] + "/PG_VERSION"); err == nil {
+				l.dbDir = l.spcDirs[spc]
+				break
+			}
+		}
+	}
+	c.cache.locators[dbOID] = l
+	return l
+}
+
 func (c *RemoteClient) loadCatalog(dbOID uint32) {
 	if _, ok := c.cache.tables[dbOID]; ok {
 		return
 	}
-	base := fmt.Sprintf("base/%d", dbOID)
-	classData, err := c.reader(fmt.Sprintf("%s/%d", base, PGClass))
+	loc := c.locator(dbOID)
+	dbMap := loadRelMap(c.reader(loc.dbDir + "/pg_filenode.map"))
+	globalMap := loadRelMap(c.reader("global/pg_filenode.map"))
+	classData, err := ReadRemoteRelation(c.reader, loc.path(0, catalogFilenode(PGClass, dbMap)))
 	if err != nil {
 		c.cache.tables[dbOID] = make(map[uint32]TableInfo)
 		c.cache.columns[dbOID] = make(map[uint32][]AttrInfo)
 		return
 	}
-	c.cache.tables[dbOID] = ParsePGClass(classData)
-	attrData, err := c.reader(fmt.Sprintf("%s/%d", base, PGAttribute))
+	xact := c.commitLog()
+	c.cache.tables[dbOID] = ParsePGClassWithRelMap(classData, xact, dbMap, globalMap)
+	ResolveSchemas(c.cache.tables[dbOID], loc.reader(c.cache.tables[dbOID], c.reader), xact)
+	attrData, err := ReadRemoteRelation(c.reader, loc.path(0, catalogFilenode(PGAttribute, dbMap)))
 	if err != nil {
 		c.cache.columns[dbOID] = make(map[uint32][]AttrInfo)
 		return
 	}
-	c.cache.columns[dbOID] = ParsePGAttribute(attrData, c.version)
+	c.cache.columns[dbOID] = parseAttributes(attrData, c.version, xact, true)
+}
+
+// toastReader returns the per-database TOAST reader, fetching TOAST
+// relations through the remote reader on first use
+func (c *RemoteClient) toastReader(dbOID uint32) *TOASTReader {
+	if r, ok := c.cache.toast[dbOID]; ok {
+		return r
+	}
+	c.loadCatalog(dbOID)
+	tables := c.cache.tables[dbOID]
+	r := NewTOASTReaderFromFiles(tables, c.locator(dbOID).reader(tables, c.reader), c.commitLog())
+	c.cache.toast[dbOID] = r
+	return r
+}
+
+// types returns the per-database type registry, read on first use
+func (c *RemoteClient) types(dbOID uint32) *TypeRegistry {
+	if r, ok := c.cache.types[dbOID]; ok {
+		return r
+	}
+	c.loadCatalog(dbOID)
+	tables := c.cache.tables[dbOID]
+	r := LoadTypes(tables, c.cache.columns[dbOID], c.locator(dbOID).reader(tables, c.reader), c.version, c.commitLog())
+	c.cache.types[dbOID] = r
+	return r
+}
+
+// commitLog returns the remote pg_xact reader, or nil when it cannot be
+// read and visibility falls back to hint bits
+func (c *RemoteClient) commitLog() *CommitLog {
+	if !c.cache.xactSet {
+		c.cache.xact = commitLogFor(NewCommitLog(c.reader), VisibilityAuto)
+		c.cache.xactSet = true
+	}
+	return c.cache.xact
 }
 
 func (c *RemoteClient) Tables(dbOID uint32) []TableInfo {
@@ -306,14 +406,43 @@ func (c *RemoteClient) TablesByName(dbName string) []TableInfo {
 	return nil
 }
 
-func (c *RemoteClient) Table(dbOID uint32, tableName string) *TableInfo {
+// Table finds a table by name; "schema.table" selects a schema, otherwise
+// the table in public is preferred and a name found only in several other
+// schemas is ambiguous. Without schemas the table with the lowest OID wins
+func (c *RemoteClient) Table(dbOID uint32, tableName string) (*TableInfo, error) {
 	c.loadCatalog(dbOID)
+	schema, name, qualified := strings.Cut(tableName, ".")
+	if !qualified {
+		schema, name = "", tableName
+	}
+	var matches []TableInfo
 	for _, t := range c.cache.tables[dbOID] {
-		if strings.EqualFold(t.Name, tableName) {
-			return &t
+		if !strings.EqualFold(t.Name, name) || (qualified && !strings.EqualFold(t.Schema, schema)) {
+			continue
+		}
+		if !qualified && t.Schema == "public" {
+			return &t, nil
 		}
+		matches = append(matches, t)
 	}
-	return nil
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("table %q not found", tableName)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].OID < matches[j].OID })
+	schemas := make([]string, len(matches))
+	for i, t := range matches {
+		if t.Schema == "" {
+			// pg_namespace was unreadable: qualifying cannot help, so the
+			// oldest table wins as before schemas were resolved
+			return &matches[0], nil
+		}
+		schemas[i] = t.Schema
+	}
+	if len(matches) == 1 {
+		return &matches[0], nil
+	}
+	sort.Strings(schemas)
+	return nil, fmt.Errorf("table %q is ambiguous, qualify it with one of: %s", tableName, strings.Join(schemas, ", "))
 }
 
 func (c *RemoteClient) Columns(dbOID, tableOID uint32) []AttrInfo {
@@ -332,24 +461,23 @@ func (c *RemoteClient) ColumnNames(dbOID, tableOID uint32) []string {
 }
 
 type QueryOptions struct {
-	Columns []string
-	Limit   int
+	Columns       []string
+	Limit         int
+	SystemColumns bool // add ctid, xmin, xmax, ... (see Options.SystemColumns)
 }
 
 func (c *RemoteClient) Query(dbOID uint32, table *TableInfo, opts *QueryOptions) []map[string]any {
 	if table == nil || table.Filenode == 0 {
 		return nil
 	}
-	data, err := c.reader(fmt.Sprintf("base/%d/%d", dbOID, table.Filenode))
+	data, err := ReadRemoteRelation(c.reader, c.locator(dbOID).path(table.Tablespace, table.Filenode))
 	if err != nil {
 		return nil
 	}
-	attrs := c.Columns(dbOID, table.OID)
-	cols := make([]Column, len(attrs))
-	for i, a := range attrs {
-		cols[i] = Column{Name: a.Name, TypID: a.TypID, Len: a.Len, Num: a.Num, Align: a.Align}
-	}
-	rows := ReadRows(data, cols, true)
+	cols := columnsFromAttrs(c.Columns(dbOID, table.OID))
+	dec := &Decoder{TOAST: c.toastReader(dbOID), Types: c.types(dbOID), Xact: c.commitLog()}
+	dec.SystemColumns = opts != nil && opts.SystemColumns
+	rows := dec.ReadRows(data, cols, true)
 	if opts != nil && len(opts.Columns) > 0 {
 		filtered := make([]map[string]any, 0, len(rows))
 		for _, row := range rows {
@@ -371,7 +499,7 @@ func (c *RemoteClient) Query(dbOID uint32, table *TableInfo, opts *QueryOptions)
 
 func (c *RemoteClient) QueryByName(dbName, tableName string, opts *QueryOptions) []map[string]any {
 	if db := c.Database(dbName); db != nil {
-		if table := c.Table(db.OID, tableName); table != nil {
+		if table, err := c.Table(db.OID, tableName); err == nil {
 			return c.Query(db.OID, table, opts)
 		}
 	}
@@ -386,10 +514,10 @@ func (c *RemoteClient) DumpTable(dbOID uint32, table *TableInfo) *TableDump {
 	var cols []ColumnInfo
 	for _, a := range c.Columns(dbOID, table.OID) {
 		if a.Num > 0 {
-			cols = append(cols, ColumnInfo{Name: a.Name, TypID: a.TypID, Type: TypeName(a.TypID)})
+			cols = append(cols, ColumnInfo{Name: a.Name, TypID: a.TypID, Type: c.types(dbOID).TypeName(a.TypID)})
 		}
 	}
-	return &TableDump{OID: table.OID, Name: table.Name, Filenode: table.Filenode, Kind: table.Kind, Columns: cols, Rows: rows, RowCount: len(rows)}
+	return &TableDump{OID: table.OID, Schema: table.Schema, Name: table.Name, Filenode: table.Filenode, Kind: table.Kind, Columns: cols, Rows: rows, RowCount: len(rows)}
 }
 
 func (c *RemoteClient) DumpDatabase(dbOID uint32) *DatabaseDump {
@@ -399,13 +527,14 @@ func (c *RemoteClient) DumpDatabase(dbOID uint32) *DatabaseDump {
 	}
 	dump := &DatabaseDump{OID: dbOID, Name: db.Name}
 	for _, t := range c.Tables(dbOID) {
-		if strings.HasPrefix(t.Name, "pg_") || strings.HasPrefix(t.Name, "sql_") {
+		if strings.HasPrefix(t.Name, "pg_") || strings.HasPrefix(t.Name, "sql_") || isSystemSchema(t.Schema) {
 			continue
 		}
 		if td := c.DumpTable(dbOID, &t); td != nil && len(td.Rows) > 0 {
 			dump.Tables = append(dump.Tables, *td)
 		}
 	}
+	dump.Types = c.types(dbOID).Definitions(columnTypes(dump.Tables))
 	return dump
 }
 
@@ -471,6 +600,8 @@ func (c *RemoteClient) Exec(args []string) Result {
 		return CredsResult(c.Credentials())
 	case "dbs", "databases":
 		return DatabasesResult(c.Databases())
+	case "tablespaces":
+		return TablespacesResult(c.Tablespaces())
 	case "tables":
 		if len(args) < 2 {
 			return ErrorResult("usage: tables <database>")
@@ -484,16 +615,24 @@ func (c *RemoteClient) Exec(args []string) Result {
 		if db == nil {
 			return ErrorResult("database not found")
 		}
-		table := c.Table(db.OID, args[2])
-		if table == nil {
-			return ErrorResult("table not found")
+		table, err := c.Table(db.OID, args[2])
+		if err != nil {
+			return ErrorResult(err.Error())
 		}
 		return ColumnsResult(c.Columns(db.OID, table.OID))
 	case "query":
 		if len(args) < 3 {
 			return ErrorResult("usage: query <database> <table>")
 		}
-		return QueryResult(c.QueryByName(args[1], args[2], &QueryOptions{Limit: 20}))
+		db := c.Database(args[1])
+		if db == nil {
+			return ErrorResult("database not found")
+		}
+		table, err := c.Table(db.OID, args[2])
+		if err != nil {
+			return ErrorResult(err.Error())
+		}
+		return QueryResult(c.Query(db.OID, table, &QueryOptions{Limit: 20}))
 	case "dump":
 		if len(args) >= 2 {
 			return DumpDatabaseResult{c.DumpDatabaseByName(args[1])}
@@ -0,0 +1,295 @@
+package pgdump
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// firstNormalObjectID is the first OID assigned to user objects
+const firstNormalObjectID = 16384
+
+// Kinds of SchemaChange
+const (
+	ChangeRenameTable  = "rename_table"
+	ChangeRewrite      = "rewrite" // new relfilenode (ALTER COLUMN TYPE, VACUUM FULL, TRUNCATE)
+	ChangeAddColumn    = "add_column"
+	ChangeDropColumn   = "drop_column"
+	ChangeRenameColumn = "rename_column"
+	ChangeAlterType    = "alter_type"
+)
+
+// SchemaHistory is the sequence of definitions a table went through, as
+// the catalog row versions left in pg_class and pg_attribute record them
+type SchemaHistory struct {
+	OID       uint32          `json:"oid"`
+	Name      string          `json:"name"`                 // latest name
+	DroppedBy uint32          `json:"dropped_by,omitempty"` // transaction that dropped the table
+	Versions  []SchemaVersion `json:"versions"`
+}
+
+// SchemaVersion is the table definition one transaction committed
+type SchemaVersion struct {
+	Xmin       uint32         `json:"xmin"`
+	CommitTime *time.Time     `json:"commit_time,omitempty"` // with track_commit_timestamp
+	Name       string         `json:"name"`
+	Filenode   uint32         `json:"filenode"`
+	Columns    []ColumnInfo   `json:"columns"`
+	Changes    []SchemaChange `json:"changes,omitempty"` // against the previous version
+	columns    []Column       // including dropped columns, for decoding
+}
+
+// SchemaChange is one difference between consecutive SchemaVersions
+type SchemaChange struct {
+	Kind   string `json:"kind"`
+	Column string `json:"column,omitempty"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+// ReadSchemaHistory reconstructs the schema history of every user table of
+// a database, dropped ones included while their catalog rows survive
+func ReadSchemaHistory(dataDir, dbName string) ([]SchemaHistory, error) {
+	dbOID, err := lookupDatabase(dataDir, dbName)
+	if err != nil {
+		return nil, err
+	}
+	loc := newLocalLocator(dataDir, dbOID)
+	dbMap := loadRelMap(os.ReadFile(filepath.Join(loc.dbDir, "pg_filenode.map")))
+	classData, err := ReadRelationFile(loc.path(0, catalogFilenode(PGClass, dbMap)))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read pg_class: %w", err)
+	}
+	attrData, err := ReadRelationFile(loc.path(0, catalogFilenode(PGAttribute, dbMap)))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read pg_attribute: %w", err)
+	}
+	return BuildSchemaHistory(classData, attrData, commitLogFor(OpenCommitLog(dataDir), VisibilityAuto), 0), nil
+}
+
+// BuildSchemaHistory orders the pg_class and pg_attribute row versions of
+// each user table by xmin and keeps the definitions that differ. xact
+// decides which row versions each transaction saw (nil = hint bits) and
+// supplies commit timestamps
+func BuildSchemaHistory(classData, attrData []byte, xact *CommitLog, pgVersion int) []SchemaHistory {
+	histories := schemaHistories(classData, attrData, xact, pgVersion)
+	result := make([]SchemaHistory, 0, len(histories))
+	for _, h := range histories {
+		result = append(result, *h)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].OID < result[j].OID })
+	return result
+}
+
+// catalogRow is one decoded catalog tuple version
+type catalogRow struct {
+	header *HeapTupleHeader
+	values map[string]interface{}
+}
+
+func schemaHistories(classData, attrData []byte, xact *CommitLog, pgVersion int) map[uint32]*SchemaHistory {
+	classes := make(map[uint32][]catalogRow)
+	for _, e := range ReadTuples(classData, false) {
+		row := DecodeTuple(e.Tuple, schemaPGClass)
+		if oid := getOID(row, "oid"); oid >= firstNormalObjectID && getString(row, "relkind") == "r" {
+			classes[oid] = append(classes[oid], catalogRow{e.Tuple.Header, row})
+		}
+	}
+
+	// Complete rows, to reach attalign and attisdropped where they really are
+	layout := detectAttrLayout(attrData, pgVersion)
+	attrs := make(map[uint32][]catalogRow)
+	for _, e := range ReadTuples(attrData, false) {
+		row := DecodeTuple(e.Tuple, layout)
+		if relid := getOID(row, "attrelid"); classes[relid] != nil && toInt(row["attnum"]) > 0 {
+			attrs[relid] = append(attrs[relid], catalogRow{e.Tuple.Header, row})
+		}
+	}
+
+	histories := make(map[uint32]*SchemaHistory)
+	for oid, rows := range classes {
+		if h := buildHistory(oid, rows, attrs[oid], xact); len(h.Versions) > 0 {
+			histories[oid] = h
+		}
+	}
+	return histories
+}
+
+// buildHistory replays the catalog row versions of one table: the
+// definition committed by each transaction is what it left visible
+func buildHistory(oid uint32, classes, attrs []catalogRow, xact *CommitLog) *SchemaHistory {
+	var xids []uint32
+	seen := make(map[uint32]bool)
+	for _, rows := range [][]catalogRow{classes, attrs} {
+		for _, r := range rows {
+			if xmin := r.header.Xmin; !seen[xmin] && xact.XminCommitted(r.header) {
+				seen[xmin] = true
+				xids = append(xids, xmin)
+			}
+		}
+	}
+	sort.Slice(xids, func(i, j int) bool { return xids[i] != xids[j] && xidPrecedesOrEquals(xids[i], xids[j]) })
+
+	h := &SchemaHistory{OID: oid}
+	var prev *SchemaVersion
+	for _, xid := range xids {
+		v, ok := schemaAt(classes, attrs, xid, xact)
+		if !ok {
+			continue
+		}
+		if prev != nil {
+			if v.Changes = diffSchemas(prev, &v); len(v.Changes) == 0 {
+				continue // e.g. ANALYZE updating relpages
+			}
+		}
+		if t, ok := xact.CommitTime(xid); ok {
+			v.CommitTime = &t
+		}
+		h.Versions = append(h.Versions, v)
+		prev = &h.Versions[len(h.Versions)-1]
+	}
+	if prev != nil {
+		h.Name = prev.Name
+	}
+
+	// Dropped when no pg_class row version is live any more
+	var newest *HeapTupleHeader
+	for _, r := range classes {
+		if xact.IsVisible(r.header) {
+			return h
+		}
+		if newest == nil || xidPrecedesOrEquals(newest.Xmin, r.header.Xmin) {
+			newest = r.header
+		}
+	}
+	if newest != nil {
+		h.DroppedBy, _ = xact.deleter(newest)
+	}
+	return h
+}
+
+// schemaAt returns the definition a snapshot taken right after xid saw
+func schemaAt(classes, attrs []catalogRow, xid uint32, xact *CommitLog) (SchemaVersion, bool) {
+	var v SchemaVersion
+	found := false
+	for _, r := range classes {
+		if xact.VisibleAt(r.header, xid) {
+			v.Xmin, v.Name, v.Filenode = xid, getString(r.values, "relname"), getOID(r.values, "relfilenode")
+			found = true
+			break
+		}
+	}
+	if !found {
+		return v, false
+	}
+
+	byNum := make(map[int]map[string]interface{})
+	for _, r := range attrs {
+		if xact.VisibleAt(r.header, xid) {
+			byNum[toInt(r.values["attnum"])] = r.values
+		}
+	}
+	nums := make([]int, 0, len(byNum))
+	for num := range byNum {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		row := byNum[num]
+		col := Column{
+			Name:  getString(row, "attname"),
+			TypID: int(getOID(row, "atttypid")),
+			Len:   toInt(row["attlen"]),
+			Num:   num,
+			Align: 'i',
+		}
+		if align := getString(row, "attalign"); len(align) > 0 {
+			col.Align = align[0]
+		}
+		v.columns = append(v.columns, col)
+		if dropped, _ := row["attisdropped"].(bool); !dropped {
+			v.Columns = append(v.Columns, ColumnInfo{Name: col.Name, Type: TypeName(col.TypID), TypID: col.TypID})
+		}
+	}
+	return v, true
+}
+
+// diffSchemas lists what changed between two versions, matching columns
+// by attnum
+func diffSchemas(old, cur *SchemaVersion) []SchemaChange {
+	var changes []SchemaChange
+	if old.Name != cur.Name {
+		changes = append(changes, SchemaChange{Kind: ChangeRenameTable, Old: old.Name, New: cur.Name})
+	}
+	if old.Filenode != cur.Filenode {
+		changes = append(changes, SchemaChange{
+			Kind: ChangeRewrite, Old: fmt.Sprint(old.Filenode), New: fmt.Sprint(cur.Filenode),
+		})
+	}
+
+	oldCols := make(map[int]Column)
+	for _, c := range liveColumns(old) {
+		oldCols[c.Num] = c
+	}
+	for _, c := range liveColumns(cur) {
+		o, ok := oldCols[c.Num]
+		delete(oldCols, c.Num)
+		switch {
+		case !ok:
+			changes = append(changes, SchemaChange{Kind: ChangeAddColumn, Column: c.Name, New: TypeName(c.TypID)})
+		case o.Name != c.Name:
+			changes = append(changes, SchemaChange{Kind: ChangeRenameColumn, Column: c.Name, Old: o.Name, New: c.Name})
+		}
+		if ok && o.TypID != c.TypID {
+			changes = append(changes, SchemaChange{
+				Kind: ChangeAlterType, Column: c.Name, Old: TypeName(o.TypID), New: TypeName(c.TypID),
+			})
+		}
+	}
+	for _, c := range liveColumns(old) {
+		if _, ok := oldCols[c.Num]; ok {
+			changes = append(changes, SchemaChange{Kind: ChangeDropColumn, Column: c.Name, Old: TypeName(c.TypID)})
+		}
+	}
+	return changes
+}
+
+// liveColumns returns a version's columns without the dropped ones
+func liveColumns(v *SchemaVersion) []Column {
+	var cols []Column
+	for _, c := range v.columns {
+		if !droppedColumnRegex.MatchString(c.Name) {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+// ColumnsAt returns the columns a tuple of filenode with this xmin was
+// written with: the last version of that filenode committed no later than
+// xmin. Tuples older than the filenode were copied into it by a rewrite,
+// which keeps xmin, and take its first version. nil when no version
+// stored its rows in filenode
+func (h *SchemaHistory) ColumnsAt(filenode, xmin uint32) []Column {
+	var cols []Column
+	for _, v := range h.Versions {
+		if v.Filenode != filenode {
+			continue
+		}
+		if cols != nil && !xidPrecedesOrEquals(v.Xmin, xmin) {
+			break
+		}
+		cols = v.columns
+	}
+	return cols
+}
+
+// decoder returns a copy of d decoding each tuple of filenode with the
+// columns current at its xmin
+func (h *SchemaHistory) decoder(d *Decoder, filenode uint32) *Decoder {
+	hd := *d
+	hd.ColumnsAt = func(xmin uint32) []Column { return h.ColumnsAt(filenode, xmin) }
+	return &hd
+}
@@ -0,0 +1,145 @@
+package pgdump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestBuildSchemaHistory(t *testing.T) {
+	le16 := func(v uint16) []byte { return binary.LittleEndian.AppendUint16(nil, v) }
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	infomask := func(xmax uint32) uint16 {
+		if xmax == 0 {
+			return HeapXminCommitted | HeapXmaxInvalid
+		}
+		return HeapXminCommitted | HeapXmaxCommitted
+	}
+	class := func(xmin, xmax, oid, filenode uint32, name string) []byte {
+		return catalogTuple(xmin, xmax, infomask(xmax), schemaPGClass, map[string][]byte{
+			"oid": le32(oid), "relname": nameDatum(name), "relfilenode": le32(filenode), "relkind": {'r'},
+		})
+	}
+	// Full rows as PostgreSQL writes them: attcacheoff -1, attstorage
+	// beside attalign, and a dropped column keeping its length and alignment
+	attr := func(layout []Column, xmin, xmax, relid uint32, name string, num uint16, typid uint32, length uint16, align byte) []byte {
+		values := map[string][]byte{
+			"attrelid": le32(relid), "attname": nameDatum(name), "atttypid": le32(typid),
+			"attlen": le16(length), "attnum": le16(num), "attcacheoff": le32(0xFFFFFFFF),
+			"attstorage": {'p'}, "attalign": {align}, "attislocal": {1},
+		}
+		if droppedColumnRegex.MatchString(name) {
+			values["attisdropped"] = []byte{1}
+		}
+		return catalogTuple(xmin, xmax, infomask(xmax), layout, values)
+	}
+
+	// items(id, price int4) created by 100, analyzed by 105, "note" added
+	// by 110, then renamed to products and price changed to int8 by 120.
+	// events was created by 100 with a column dropped since, then dropped
+	// by 130
+	classData := makeHeapPage(
+		class(100, 105, 16400, 16400, "items"),
+		class(105, 110, 16400, 16400, "items"),
+		class(110, 120, 16400, 16400, "items"),
+		class(120, 0, 16400, 16410, "products"),
+		class(100, 130, 16500, 16500, "events"),
+	)
+	layouts := []struct {
+		name     string
+		versions []int // 0 detects the layout from the rows
+		columns  []Column
+	}{
+		{"v12", []int{12, 0}, schemaPGAttrFullV12},
+		{"v14", []int{15, 0}, schemaPGAttrFullV14},
+		{"v16", []int{16}, schemaPGAttrFullV16},
+		{"v17", []int{17}, schemaPGAttrFullV17},
+	}
+	for _, l := range layouts {
+		attrData := makeHeapPage(
+			attr(l.columns, 100, 0, 16400, "id", 1, OidInt4, 4, 'i'),
+			attr(l.columns, 100, 120, 16400, "price", 2, OidInt4, 4, 'i'),
+			attr(l.columns, 120, 0, 16400, "price", 2, OidInt8, 8, 'd'),
+			attr(l.columns, 110, 0, 16400, "note", 3, OidText, 0xFFFF, 'i'),
+			attr(l.columns, 100, 130, 16500, "at", 1, OidInt8, 8, 'd'),
+			attr(l.columns, 100, 130, 16500, "........pg.dropped.2........", 2, 0, 8, 'd'),
+		)
+		for _, version := range l.versions {
+			t.Run(fmt.Sprintf("%s/%d", l.name, version), func(t *testing.T) {
+				checkSchemaHistory(t, BuildSchemaHistory(classData, attrData, nil, version))
+			})
+		}
+	}
+}
+
+func checkSchemaHistory(t *testing.T, histories []SchemaHistory) {
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	if len(histories) != 2 {
+		t.Fatalf("got %d histories, want 2", len(histories))
+	}
+	items, events := histories[0], histories[1]
+	if items.Name != "products" || items.DroppedBy != 0 || len(items.Versions) != 3 {
+		t.Fatalf("items history = %+v", items)
+	}
+	if events.DroppedBy != 130 || len(events.Versions) != 1 || len(events.Versions[0].Columns) != 1 {
+		t.Errorf("events history = %+v", events)
+	}
+	if got := events.ColumnsAt(16500, 101); len(got) != 2 || got[1].Align != 'd' {
+		t.Errorf("events columns = %+v, want the dropped int8 column aligned on 'd'", got)
+	}
+
+	want := [][]SchemaChange{
+		nil,
+		{{Kind: ChangeAddColumn, Column: "note", New: "text"}},
+		{
+			{Kind: ChangeRenameTable, Old: "items", New: "products"},
+			{Kind: ChangeRewrite, Old: "16400", New: "16410"},
+			{Kind: ChangeAlterType, Column: "price", Old: "int4", New: "int8"},
+		},
+	}
+	for i, v := range items.Versions {
+		if len(v.Changes) != len(want[i]) {
+			t.Errorf("version %d (xmin %d) changes = %+v, want %+v", i, v.Xmin, v.Changes, want[i])
+			continue
+		}
+		for j, c := range v.Changes {
+			if c != want[i][j] {
+				t.Errorf("version %d change %d = %+v, want %+v", i, j, c, want[i][j])
+			}
+		}
+	}
+
+	// The schema a tuple was written with depends on its filenode and xmin
+	tests := []struct {
+		filenode, xmin uint32
+		ncols          int
+	}{
+		{16400, 101, 2},
+		{16400, 115, 3},
+		{16410, 50, 3}, // copied by the rewrite, keeps its xmin
+		{16410, 125, 3},
+		{999, 125, 0},
+	}
+	for _, tt := range tests {
+		if got := items.ColumnsAt(tt.filenode, tt.xmin); len(got) != tt.ncols {
+			t.Errorf("ColumnsAt(%d, %d) = %v, want %d columns", tt.filenode, tt.xmin, got, tt.ncols)
+		}
+	}
+	if got := items.ColumnsAt(16410, 125)[1]; got.TypID != OidInt8 {
+		t.Errorf("price in 16410 has type %d, want int8", got.TypID)
+	}
+
+	// Rows of the old filenode decode as int4 even against the int8 schema
+	hinted := uint16(HeapXminCommitted | HeapXmaxInvalid)
+	page := makeHeapPage(
+		makeHeapTuple(101, 0, hinted, 2, nil, append(le32(1), le32(250)...)),
+	)
+	current := []Column{
+		{Name: "id", TypID: OidInt4, Len: 4, Align: 'i', Num: 1},
+		{Name: "price", TypID: OidInt8, Len: 8, Align: 'd', Num: 2},
+	}
+	dec := items.decoder(&Decoder{}, 16400)
+	if rows := dec.ReadRows(page, current, true); len(rows) != 1 || rows[0]["price"] != int32(250) {
+		t.Errorf("old rows = %v, want price int4 250", rows)
+	}
+}
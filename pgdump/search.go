@@ -17,10 +17,12 @@ type SearchResult struct {
 
 // SearchOptions configures the search behavior
 type SearchOptions struct {
-	Pattern       string // Regex pattern to search for
-	CaseSensitive bool   // Case-sensitive search
-	IncludeRow    bool   // Include full row in results
-	MaxResults    int    // Maximum results (0 = unlimited)
+	Pattern       string         // Regex pattern to search for
+	CaseSensitive bool           // Case-sensitive search
+	IncludeRow    bool           // Include full row in results
+	MaxResults    int            // Maximum results (0 = unlimited)
+	Visibility    VisibilityMode // Hint bits or pg_xact (see Options)
+	AsOfXID       uint32         // Search rows as they were after this xid (0 = current)
 }
 
 // Search searches across all databases and tables for a pattern
@@ -40,7 +42,11 @@ func Search(dataDir string, opts *SearchOptions) ([]SearchResult, error) {
 	}
 
 	// Dump everything
-	result, err := DumpDataDir(dataDir, &Options{SkipSystemTables: true})
+	result, err := DumpDataDir(dataDir, &Options{
+		SkipSystemTables: true,
+		Visibility:       opts.Visibility,
+		AsOfXID:          opts.AsOfXID,
+	})
 	if err != nil {
 		return nil, err
 	}
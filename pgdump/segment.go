@@ -20,6 +20,7 @@ type SegmentOptions struct {
 // SegmentInfo contains information about a file segment
 type SegmentInfo struct {
 	BasePath      string `json:"base_path"`
+	Fork          string `json:"fork"`
 	SegmentNumber int    `json:"segment_number"`
 	SegmentSize   int    `json:"segment_size"`
 	FileSize      int64  `json:"file_size"`
@@ -67,6 +68,7 @@ func GetSegmentInfo(path string, opts *SegmentOptions) (*SegmentInfo, error) {
 
 	return &SegmentInfo{
 		BasePath:      path,
+		Fork:          forkOf(path),
 		SegmentNumber: segNum,
 		SegmentSize:   segSize,
 		FileSize:      stat.Size(),
@@ -75,14 +77,17 @@ func GetSegmentInfo(path string, opts *SegmentOptions) (*SegmentInfo, error) {
 	}, nil
 }
 
-// ListSegments finds all segments for a given base file
+// ListSegments finds all segments for a given base file, which can be
+// any fork (16384, 16384_fsm, 16384_vm)
 func ListSegments(basePath string) ([]SegmentInfo, error) {
 	var segments []SegmentInfo
+	fork := forkOf(basePath)
 	
 	// Check base file (segment 0)
 	if stat, err := os.Stat(basePath); err == nil {
 		segments = append(segments, SegmentInfo{
 			BasePath:      basePath,
+			Fork:          fork,
 			SegmentNumber: 0,
 			SegmentSize:   DefaultSegmentSize,
 			FileSize:      stat.Size(),
@@ -101,6 +106,7 @@ func ListSegments(basePath string) ([]SegmentInfo, error) {
 		
 		segments = append(segments, SegmentInfo{
 			BasePath:      segPath,
+			Fork:          fork,
 			SegmentNumber: i,
 			SegmentSize:   DefaultSegmentSize,
 			FileSize:      stat.Size(),
@@ -112,6 +118,59 @@ func ListSegments(basePath string) ([]SegmentInfo, error) {
 	return segments, nil
 }
 
+// forkOf returns the fork a relation file belongs to, ForkMain for names
+// that do not follow the relation file naming
+func forkOf(path string) string {
+	if f, ok := parseRelationFileName(filepath.Base(path)); ok {
+		return f.Fork
+	}
+	return ForkMain
+}
+
+// ReadRelationFile reads every segment of a relation (<path>, <path>.1, ...)
+// into one buffer so block numbers in the result are global
+func ReadRelationFile(path string) ([]byte, error) {
+	return readSegments(path, DefaultSegmentSize, os.ReadFile)
+}
+
+// ReadRemoteRelation is ReadRelationFile over a RemoteReader
+func ReadRemoteRelation(reader RemoteReader, path string) ([]byte, error) {
+	return readSegments(path, DefaultSegmentSize, reader)
+}
+
+// readSegments concatenates segments while the previous one is full; a
+// relation only spills into <path>.N once segment N-1 reached the size limit
+func readSegments(path string, segSize int, read func(string) ([]byte, error)) ([]byte, error) {
+	data, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	for seg := 1; len(data) == seg*segSize; seg++ {
+		next, err := read(fmt.Sprintf("%s.%d", path, seg))
+		if err != nil || len(next) == 0 {
+			break
+		}
+		data = append(data, next...)
+	}
+	return data, nil
+}
+
+// parseSegmentName splits a relation file name ("16384" or "16384.2")
+// into filenode and segment number
+func parseSegmentName(name string) (filenode uint32, segment int, ok bool) {
+	base, suffix, hasSeg := strings.Cut(name, ".")
+	fn, err := strconv.ParseUint(base, 10, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	if hasSeg {
+		if segment, err = strconv.Atoi(suffix); err != nil || segment <= 0 {
+			return 0, 0, false
+		}
+	}
+	return uint32(fn), segment, true
+}
+
 // ReadSegmentBlock reads a specific block from a segment
 func ReadSegmentBlock(path string, blockNum int, opts *SegmentOptions) ([]byte, error) {
 	segInfo, err := GetSegmentInfo(path, opts)
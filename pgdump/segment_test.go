@@ -1,6 +1,8 @@
 package pgdump
 
 import (
+	"bytes"
+	"os"
 	"testing"
 )
 
@@ -92,3 +94,67 @@ func TestDefaultSegmentSize(t *testing.T) {
 		t.Errorf("DefaultSegmentSize = %d, want %d", DefaultSegmentSize, expected)
 	}
 }
+
+func TestReadSegments(t *testing.T) {
+	files := map[string][]byte{
+		"16384":   bytes.Repeat([]byte{1}, 2*PageSize),
+		"16384.1": bytes.Repeat([]byte{2}, 2*PageSize),
+		"16384.2": bytes.Repeat([]byte{3}, PageSize),
+		"16385":   bytes.Repeat([]byte{4}, PageSize),
+		"16385.1": bytes.Repeat([]byte{5}, PageSize), // stale: segment 0 is not full
+	}
+	var fetched []string
+	read := func(path string) ([]byte, error) {
+		fetched = append(fetched, path)
+		if data, ok := files[path]; ok {
+			return data, nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	data, err := readSegments("16384", 2*PageSize, read)
+	if err != nil {
+		t.Fatalf("readSegments error: %v", err)
+	}
+	if len(data) != 5*PageSize {
+		t.Fatalf("len = %d, want %d", len(data), 5*PageSize)
+	}
+	for blk, want := range []byte{1, 1, 2, 2, 3} {
+		if data[blk*PageSize] != want {
+			t.Errorf("block %d from segment byte %d, want %d", blk, data[blk*PageSize], want)
+		}
+	}
+
+	fetched = nil
+	data, _ = readSegments("16385", 2*PageSize, read)
+	if len(data) != PageSize || len(fetched) != 1 {
+		t.Errorf("partial segment 0: len = %d, fetched %v", len(data), fetched)
+	}
+
+	if _, err := readSegments("99999", 2*PageSize, read); err == nil {
+		t.Error("expected error for missing relation")
+	}
+}
+
+func TestParseSegmentName(t *testing.T) {
+	tests := []struct {
+		name     string
+		filenode uint32
+		segment  int
+		ok       bool
+	}{
+		{"16384", 16384, 0, true},
+		{"16384.1", 16384, 1, true},
+		{"16384.12", 16384, 12, true},
+		{"16384_fsm", 0, 0, false},
+		{"16384.", 0, 0, false},
+		{"pg_filenode.map", 0, 0, false},
+	}
+	for _, tt := range tests {
+		fn, seg, ok := parseSegmentName(tt.name)
+		if fn != tt.filenode || seg != tt.segment || ok != tt.ok {
+			t.Errorf("parseSegmentName(%q) = (%d, %d, %v), want (%d, %d, %v)",
+				tt.name, fn, seg, ok, tt.filenode, tt.segment, tt.ok)
+		}
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 )
 
@@ -225,10 +224,11 @@ func FindSequences(dataDir, dbName string) ([]SequenceData, error) {
 		return nil, fmt.Errorf("database %q not found", dbName)
 	}
 
-	basePath := filepath.Join(dataDir, "base", strconv.FormatUint(uint64(dbOID), 10))
+	loc := newLocalLocator(dataDir, dbOID)
 
 	// Read pg_class to find sequences (relkind = 'S')
-	classData, err := os.ReadFile(filepath.Join(basePath, "1259"))
+	dbMap := loadRelMap(os.ReadFile(filepath.Join(loc.dbDir, "pg_filenode.map")))
+	classData, err := ReadRelationFile(loc.path(0, catalogFilenode(PGClass, dbMap)))
 	if err != nil {
 		return nil, err
 	}
@@ -243,8 +243,7 @@ func FindSequences(dataDir, dbName string) ([]SequenceData, error) {
 		}
 
 		// Read the sequence file
-		seqPath := filepath.Join(basePath, strconv.FormatUint(uint64(filenode), 10))
-		seqData, err := os.ReadFile(seqPath)
+		seqData, err := ReadRelationFile(loc.path(info.Tablespace, filenode))
 		if err != nil {
 			continue
 		}
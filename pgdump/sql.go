@@ -26,8 +26,33 @@ func (r *DumpResult) ToSQL(w io.Writer) error {
 
 // ToSQL writes a single database dump as SQL statements.
 func (d *DatabaseDump) ToSQL(w io.Writer) error {
+	seen := map[string]bool{"": true, "public": true}
+	schemas := make([]string, 0, len(d.Types)+len(d.Tables))
+	for _, typ := range d.Types {
+		schemas = append(schemas, typ.Schema)
+	}
+	for _, table := range d.Tables {
+		schemas = append(schemas, table.Schema)
+	}
+	for _, schema := range schemas {
+		if !seen[schema] {
+			seen[schema] = true
+			fmt.Fprintf(w, "CREATE SCHEMA IF NOT EXISTS %s;\n\n", quoteIdent(schema))
+		}
+	}
+
+	types := make(map[int]*TypeDef)
+	for i := range d.Types {
+		typ := &d.Types[i]
+		types[int(typ.OID)] = typ
+		if typ.ArrayTypID != 0 {
+			types[typ.ArrayTypID] = typ
+		}
+		typ.writeSQL(w, types)
+	}
+
 	for _, table := range d.Tables {
-		if err := table.ToSQL(w); err != nil {
+		if err := table.writeSQL(w, types); err != nil {
 			return err
 		}
 		fmt.Fprintln(w)
@@ -35,16 +60,65 @@ func (d *DatabaseDump) ToSQL(w io.Writer) error {
 	return nil
 }
 
+// writeSQL writes the CREATE TYPE or CREATE DOMAIN statement for a type
+func (typ *TypeDef) writeSQL(w io.Writer, types map[int]*TypeDef) {
+	switch typ.Kind {
+	case "enum":
+		labels := make([]string, len(typ.Labels))
+		for i, l := range typ.Labels {
+			labels[i] = quoteLiteral(l)
+		}
+		fmt.Fprintf(w, "CREATE TYPE %s AS ENUM (%s);\n\n", typ.ident(), strings.Join(labels, ", "))
+	case "domain":
+		fmt.Fprintf(w, "CREATE DOMAIN %s AS %s;\n\n", typ.ident(), sqlColumnType(typ.BaseType, typ.BaseTypID, types))
+	case "composite":
+		attrs := make([]string, len(typ.Attributes))
+		for i, a := range typ.Attributes {
+			attrs[i] = quoteIdent(a.Name) + " " + sqlColumnType(a.Type, a.TypID, types)
+		}
+		fmt.Fprintf(w, "CREATE TYPE %s AS (%s);\n\n", typ.ident(), strings.Join(attrs, ", "))
+	}
+}
+
+// ident returns the quoted, schema-qualified type identifier
+func (typ *TypeDef) ident() string {
+	if typ.Schema == "" || typ.Schema == "public" {
+		return quoteIdent(typ.Name)
+	}
+	return quoteIdent(typ.Schema) + "." + quoteIdent(typ.Name)
+}
+
+// sqlColumnType returns the SQL type of a column, using the dumped
+// definition for user-defined types and their arrays
+func sqlColumnType(typeName string, typID int, types map[int]*TypeDef) string {
+	typ := types[typID]
+	if typ == nil {
+		return pgTypeToSQL(typeName, typID)
+	}
+	if typID == typ.ArrayTypID {
+		return typ.ident() + "[]"
+	}
+	return typ.ident()
+}
+
 // ToSQL writes a single table as CREATE TABLE and INSERT statements.
 func (t *TableDump) ToSQL(w io.Writer) error {
+	return t.writeSQL(w, nil)
+}
+
+// writeSQL writes the table; types holds the user-defined types dumped
+// with it, so their values can be cast and composites written as ROW()
+func (t *TableDump) writeSQL(w io.Writer, types map[int]*TypeDef) error {
+	columns, system := t.splitSystemColumns()
+
 	// CREATE TABLE
-	fmt.Fprintf(w, "-- Table: %s (%d rows)\n", t.Name, t.RowCount)
-	fmt.Fprintf(w, "CREATE TABLE IF NOT EXISTS %s (\n", quoteIdent(t.Name))
+	fmt.Fprintf(w, "-- Table: %s (%d rows)\n", qualifiedName(t.Schema, t.Name), t.RowCount)
+	fmt.Fprintf(w, "CREATE TABLE IF NOT EXISTS %s (\n", t.qualifiedIdent())
 
-	for i, col := range t.Columns {
-		sqlType := pgTypeToSQL(col.Type, col.TypID)
+	for i, col := range columns {
+		sqlType := sqlColumnType(col.Type, col.TypID, types)
 		fmt.Fprintf(w, "    %s %s", quoteIdent(col.Name), sqlType)
-		if i < len(t.Columns)-1 {
+		if i < len(columns)-1 {
 			fmt.Fprint(w, ",")
 		}
 		fmt.Fprintln(w)
@@ -53,43 +127,110 @@ func (t *TableDump) ToSQL(w io.Writer) error {
 	fmt.Fprintln(w)
 
 	// INSERT statements
-	if len(t.Rows) == 0 {
+	if len(t.Rows) == 0 && len(t.DeletedRows) == 0 {
 		return nil
 	}
 
 	// Get column names in order
-	colNames := make([]string, len(t.Columns))
-	for i, col := range t.Columns {
+	colNames := make([]string, len(columns))
+	for i, col := range columns {
 		colNames[i] = quoteIdent(col.Name)
 	}
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES", t.qualifiedIdent(), strings.Join(colNames, ", "))
 
-	fmt.Fprintf(w, "INSERT INTO %s (%s) VALUES\n", quoteIdent(t.Name), strings.Join(colNames, ", "))
-
-	for i, row := range t.Rows {
-		values := make([]string, len(t.Columns))
-		for j, col := range t.Columns {
-			val, ok := row[col.Name]
-			if !ok || val == nil {
-				values[j] = "NULL"
+	if len(t.Rows) > 0 {
+		fmt.Fprintln(w, insert)
+		for i, row := range t.Rows {
+			fmt.Fprintf(w, "    (%s)", sqlValues(row, columns, types))
+			if i < len(t.Rows)-1 {
+				fmt.Fprint(w, ",")
 			} else {
-				values[j] = formatSQLValue(val, col.TypID)
+				fmt.Fprint(w, ";")
 			}
+			fmt.Fprintln(w, systemComment(row, system))
 		}
-		fmt.Fprintf(w, "    (%s)", strings.Join(values, ", "))
-		if i < len(t.Rows)-1 {
-			fmt.Fprintln(w, ",")
-		} else {
-			fmt.Fprintln(w, ";")
+	}
+
+	// Recovered rows are commented out so a restore only brings back live
+	// data; uncomment the ones to resurrect
+	if len(t.DeletedRows) > 0 {
+		if len(t.Rows) > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "-- Recovered rows: %s (%d rows, not vacuumed)\n", qualifiedName(t.Schema, t.Name), len(t.DeletedRows))
+		for _, row := range t.DeletedRows {
+			status, _ := row[StatusColumn].(string)
+			if found, ok := row[FoundColumn].(string); ok {
+				status += ", " + found
+			}
+			stmt := fmt.Sprintf("%s (%s);", insert, sqlValues(row, columns, types))
+			fmt.Fprintf(w, "-- [%s] %s%s\n", status, commentLines.Replace(stmt), systemComment(row, system))
 		}
 	}
 
 	return nil
 }
 
+// splitSystemColumns separates table columns from the system columns that
+// can only be written as comments
+func (t *TableDump) splitSystemColumns() (columns, system []ColumnInfo) {
+	for _, col := range t.Columns {
+		if col.System {
+			system = append(system, col)
+		} else {
+			columns = append(columns, col)
+		}
+	}
+	return columns, system
+}
+
+// systemComment formats a row's system columns as a trailing comment
+func systemComment(row map[string]interface{}, system []ColumnInfo) string {
+	if len(system) == 0 {
+		return ""
+	}
+	parts := make([]string, len(system))
+	for i, col := range system {
+		val := row[col.Name]
+		if flags, ok := val.([]string); ok {
+			val = strings.Join(flags, "|")
+		}
+		parts[i] = fmt.Sprintf("%s=%v", col.Name, val)
+	}
+	return " -- " + strings.Join(parts, " ")
+}
+
+// commentLines keeps multi-line values inside a -- comment
+var commentLines = strings.NewReplacer("\r\n", "\r\n-- ", "\n", "\n-- ", "\r", "\r-- ")
+
+// sqlValues formats a row's values in column order
+func sqlValues(row map[string]interface{}, columns []ColumnInfo, types map[int]*TypeDef) string {
+	values := make([]string, len(columns))
+	for j, col := range columns {
+		val, ok := row[col.Name]
+		if !ok || val == nil {
+			values[j] = "NULL"
+		} else if types[col.TypID] != nil {
+			values[j] = formatTypedValue(val, col.TypID, types) + "::" + sqlColumnType(col.Type, col.TypID, types)
+		} else {
+			values[j] = formatSQLValue(val, col.TypID)
+		}
+	}
+	return strings.Join(values, ", ")
+}
+
+// qualifiedIdent returns the quoted, schema-qualified table identifier
+func (t *TableDump) qualifiedIdent() string {
+	if t.Schema == "" {
+		return quoteIdent(t.Name)
+	}
+	return quoteIdent(t.Schema) + "." + quoteIdent(t.Name)
+}
+
 // quoteIdent quotes a PostgreSQL identifier
 func quoteIdent(name string) string {
 	// Simple quoting - escape double quotes
-	if strings.ContainsAny(name, " \t\n\"") || isReservedWord(name) {
+	if strings.ContainsAny(name, " \t\n\".") || isReservedWord(name) {
 		return "\"" + strings.ReplaceAll(name, "\"", "\"\"") + "\""
 	}
 	return name
@@ -123,6 +264,9 @@ func formatSQLValue(val interface{}, typID int) string {
 		for i, elem := range v {
 			elements[i] = formatSQLValue(elem, 0)
 		}
+		if len(elements) == 0 {
+			return "'{}'"
+		}
 		return "ARRAY[" + strings.Join(elements, ", ") + "]"
 
 	case map[string]interface{}:
@@ -134,6 +278,36 @@ func formatSQLValue(val interface{}, typID int) string {
 	}
 }
 
+// formatTypedValue formats a value of a user-defined type, writing
+// composites as ROW() in attribute order
+func formatTypedValue(val interface{}, typID int, types map[int]*TypeDef) string {
+	typ := types[typID]
+	if val == nil || typ == nil {
+		return formatSQLValue(val, typID)
+	}
+	if v, ok := val.([]interface{}); ok && typID == typ.ArrayTypID {
+		elements := make([]string, len(v))
+		for i, elem := range v {
+			elements[i] = formatTypedValue(elem, int(typ.OID), types)
+		}
+		if len(elements) == 0 {
+			return "'{}'"
+		}
+		return "ARRAY[" + strings.Join(elements, ", ") + "]"
+	}
+	if typ.Kind == "domain" {
+		return formatTypedValue(val, typ.BaseTypID, types)
+	}
+	if v, ok := val.(map[string]interface{}); ok && typ.Kind == "composite" {
+		fields := make([]string, len(typ.Attributes))
+		for i, a := range typ.Attributes {
+			fields[i] = formatTypedValue(v[a.Name], a.TypID, types)
+		}
+		return "ROW(" + strings.Join(fields, ", ") + ")"
+	}
+	return formatSQLValue(val, typID)
+}
+
 // quoteLiteral quotes a string for use as a SQL literal
 func quoteLiteral(s string) string {
 	// Use dollar quoting if string contains single quotes and backslashes
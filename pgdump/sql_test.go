@@ -96,6 +96,81 @@ func TestDatabaseToSQL(t *testing.T) {
 	}
 }
 
+func TestDatabaseToSQLSchemas(t *testing.T) {
+	db := DatabaseDump{
+		Name: "app",
+		Tables: []TableDump{
+			{Schema: "public", Name: "users", Columns: []ColumnInfo{{Name: "id", Type: "int4", TypID: OidInt4}}},
+			{Schema: "audit", Name: "users", Columns: []ColumnInfo{{Name: "id", Type: "int4", TypID: OidInt4}},
+				Rows: []map[string]interface{}{{"id": int32(1)}}, RowCount: 1},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := db.ToSQL(&buf); err != nil {
+		t.Fatalf("ToSQL failed: %v", err)
+	}
+	sql := buf.String()
+
+	for _, want := range []string{
+		"CREATE SCHEMA IF NOT EXISTS audit;",
+		"CREATE TABLE IF NOT EXISTS public.users",
+		"CREATE TABLE IF NOT EXISTS audit.users",
+		"INSERT INTO audit.users (id) VALUES",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("missing %q in:\n%s", want, sql)
+		}
+	}
+	if strings.Contains(sql, "CREATE SCHEMA IF NOT EXISTS public") {
+		t.Error("public schema should not be created")
+	}
+}
+
+func TestDatabaseToSQLUserTypes(t *testing.T) {
+	db := DatabaseDump{
+		Name: "app",
+		Types: []TypeDef{
+			{OID: 16500, ArrayTypID: 16501, Schema: "app", Name: "mood", Kind: "enum", Labels: []string{"sad", "happy"}},
+			{OID: 16520, Schema: "public", Name: "pair", Kind: "composite", Attributes: []ColumnInfo{
+				{Name: "x", Type: "int4", TypID: OidInt4},
+				{Name: "label", Type: "text", TypID: OidText},
+			}},
+		},
+		Tables: []TableDump{{
+			Schema: "public",
+			Name:   "events",
+			Columns: []ColumnInfo{
+				{Name: "moods", Type: "app.mood[]", TypID: 16501},
+				{Name: "p", Type: "pair", TypID: 16520},
+			},
+			Rows: []map[string]interface{}{
+				{"moods": []interface{}{"happy"}, "p": map[string]interface{}{"x": int32(7), "label": "hi"}},
+			},
+			RowCount: 1,
+		}},
+	}
+
+	var buf bytes.Buffer
+	if err := db.ToSQL(&buf); err != nil {
+		t.Fatalf("ToSQL failed: %v", err)
+	}
+	sql := buf.String()
+
+	for _, want := range []string{
+		"CREATE SCHEMA IF NOT EXISTS app;",
+		"CREATE TYPE app.mood AS ENUM ('sad', 'happy');",
+		"CREATE TYPE pair AS (x INTEGER, label TEXT);",
+		"moods app.mood[]",
+		"p pair",
+		"(ARRAY['happy']::app.mood[], ROW(7, 'hi')::pair)",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("missing %q in:\n%s", want, sql)
+		}
+	}
+}
+
 func TestDumpResultToSQL(t *testing.T) {
 	result := DumpResult{
 		Databases: []DatabaseDump{
@@ -234,6 +309,58 @@ func TestPgTypeToSQL(t *testing.T) {
 	}
 }
 
+func TestTableToSQLDeletedRows(t *testing.T) {
+	table := TableDump{
+		Name:    "users",
+		Columns: []ColumnInfo{{Name: "id", Type: "int4", TypID: OidInt4}, {Name: "note", Type: "text", TypID: OidText}},
+		Rows:    []map[string]interface{}{{"id": int32(1), "note": "kept"}},
+		DeletedRows: []map[string]interface{}{
+			{"id": int32(2), "note": "line1\nDROP TABLE users;", StatusColumn: StatusDeleted},
+		},
+		RowCount: 1,
+	}
+	var buf bytes.Buffer
+	if err := table.ToSQL(&buf); err != nil {
+		t.Fatalf("ToSQL failed: %v", err)
+	}
+	sql := buf.String()
+	if !strings.Contains(sql, "(1, 'kept');") {
+		t.Errorf("missing live row:\n%s", sql)
+	}
+	if !strings.Contains(sql, "-- [deleted] INSERT INTO users (id, note) VALUES (2, 'line1") {
+		t.Errorf("missing recovered row:\n%s", sql)
+	}
+	for _, line := range strings.Split(sql, "\n") {
+		if strings.Contains(line, "DROP TABLE") && !strings.HasPrefix(line, "-- ") {
+			t.Errorf("recovered value escaped its comment: %q", line)
+		}
+	}
+}
+
+func TestTableToSQLSystemColumns(t *testing.T) {
+	table := TableDump{
+		Name:    "users",
+		Columns: append([]ColumnInfo{{Name: "id", Type: "int4", TypID: OidInt4}}, systemColumns...),
+		Rows: []map[string]interface{}{{
+			"id": int32(1), "ctid": "(0,1)", "xmin": uint32(100), "xmax": uint32(0), "cmin": uint32(0),
+			"cmax": uint32(0), "t_infomask": []string{"XMIN_COMMITTED", "XMAX_INVALID"}, "t_ctid": "(0,1)",
+		}},
+		RowCount: 1,
+	}
+	var buf bytes.Buffer
+	if err := table.ToSQL(&buf); err != nil {
+		t.Fatalf("ToSQL failed: %v", err)
+	}
+	sql := buf.String()
+	if strings.Contains(sql, "ctid tid") || !strings.Contains(sql, "INSERT INTO users (id) VALUES") {
+		t.Errorf("system columns written as table columns:\n%s", sql)
+	}
+	want := "(1); -- ctid=(0,1) xmin=100 xmax=0 cmin=0 cmax=0 t_infomask=XMIN_COMMITTED|XMAX_INVALID t_ctid=(0,1)"
+	if !strings.Contains(sql, want) {
+		t.Errorf("missing system column comment %q in:\n%s", want, sql)
+	}
+}
+
 func TestEmptyTable(t *testing.T) {
 	table := TableDump{
 		Name: "empty",
@@ -0,0 +1,222 @@
+package pgdump
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tablespaces created by initdb
+const (
+	DefaultTablespace = 1663 // pg_default (base/)
+	GlobalTablespace  = 1664 // pg_global (global/)
+)
+
+// TablespaceInfo represents a pg_tablespace entry
+type TablespaceInfo struct {
+	OID  uint32 `json:"oid"`
+	Name string `json:"name"`
+}
+
+var schemaPGTablespace = []Column{
+	{Name: "oid", TypID: OidOid, Len: 4},
+	{Name: "spcname", TypID: OidName, Len: 64},
+}
+
+// ParsePGTablespace extracts tablespaces from pg_tablespace heap file
+func ParsePGTablespace(data []byte) []TablespaceInfo {
+	var result []TablespaceInfo
+	for _, row := range ReadRows(data, schemaPGTablespace, true) {
+		if oid, name := getOID(row, "oid"), getString(row, "spcname"); oid > 0 && name != "" {
+			result = append(result, TablespaceInfo{OID: oid, Name: name})
+		}
+	}
+	return result
+}
+
+// ParseTablespaceMap parses the tablespace_map file of a base backup,
+// which records each tablespace's location in place of its pg_tblspc
+// symlink: one "<oid> <path>" line per tablespace, a backslash escaping
+// the next character of the path
+func ParseTablespaceMap(data []byte) map[uint32]string {
+	spcs := make(map[uint32]string)
+	var line []byte
+	for i := 0; i <= len(data); i++ {
+		if i < len(data) && data[i] == '\\' && i+1 < len(data) {
+			i++
+			line = append(line, data[i])
+			continue
+		}
+		if i < len(data) && data[i] != '\n' {
+			line = append(line, data[i])
+			continue
+		}
+		if oid, path, ok := strings.Cut(string(line), " "); ok && path != "" {
+			if n, err := strconv.ParseUint(oid, 10, 32); err == nil {
+				spcs[uint32(n)] = path
+			}
+		}
+		line = line[:0]
+	}
+	return spcs
+}
+
+// TablespaceVersionDir returns the per-version directory PostgreSQL creates
+// inside each tablespace, e.g. PG_16_202307071
+func TablespaceVersionDir(pgVersion string, catalogVersion uint32) string {
+	return fmt.Sprintf("PG_%s_%d", pgVersion, catalogVersion)
+}
+
+// relationLocator maps a database's relations to their directories,
+// following reltablespace into pg_tblspc/<spc>/PG_<ver>_<catver>/<db>
+type relationLocator struct {
+	dbDir     string            // reltablespace = 0 (database default)
+	baseDir   string            // pg_default, when it is not the database default
+	globalDir string            // pg_global (shared catalogs)
+	spcDirs   map[uint32]string // tablespace OID -> database directory in it
+}
+
+// path returns the main fork path of a relation
+func (l *relationLocator) path(tablespace, filenode uint32) string {
+	dir := l.dbDir
+	switch tablespace {
+	case 0:
+	case DefaultTablespace:
+		dir = l.baseDir
+	case GlobalTablespace:
+		dir = l.globalDir
+	default:
+		if d, ok := l.spcDirs[tablespace]; ok {
+			dir = d
+		}
+	}
+	return fmt.Sprintf("%s/%d", dir, filenode)
+}
+
+// reader returns a FileReader that routes each filenode through pg_class
+func (l *relationLocator) reader(tables map[uint32]TableInfo, read func(string) ([]byte, error)) FileReader {
+	return func(fn uint32) ([]byte, error) {
+		return readSegments(l.path(tables[fn].Tablespace, fn), DefaultSegmentSize, read)
+	}
+}
+
+// newLocalLocator resolves a database's directories under dataDir. The
+// version directory is found by listing each tablespace, which also works
+// for copies where pg_tblspc holds plain directories instead of symlinks
+func newLocalLocator(dataDir string, dbOID uint32) *relationLocator {
+	db := strconv.FormatUint(uint64(dbOID), 10)
+	base := filepath.Join(dataDir, "base", db)
+	l := &relationLocator{
+		dbDir:     base,
+		baseDir:   base,
+		globalDir: filepath.Join(dataDir, "global"),
+		spcDirs:   make(map[uint32]string),
+	}
+
+	want := ""
+	if cf, err := ReadControlFile(dataDir); err == nil {
+		if v, err := os.ReadFile(filepath.Join(dataDir, "PG_VERSION")); err == nil {
+			want = TablespaceVersionDir(strings.TrimSpace(string(v)), cf.CatalogVersionNo)
+		}
+	}
+
+	for _, dir := range tablespaceVersionDirs(dataDir, want) {
+		if _, ok := l.spcDirs[dir.spc]; !ok {
+			l.spcDirs[dir.spc] = filepath.Join(dir.path, db)
+		}
+	}
+
+	// Database created with a non-default TABLESPACE
+	if _, err := os.Stat(base); err != nil {
+		for _, spc := range sortedKeys(l.spcDirs) {
+			if _, err := os.Stat(l.spcDirs[spc]); err == nil {
+				l.dbDir = l.spcDirs[spc]
+				break
+			}
+		}
+	}
+	return l
+}
+
+// tablespaceDir is a PG_<ver>_<catver> directory of a tablespace
+type tablespaceDir struct {
+	spc  uint32
+	path string
+}
+
+// tablespaceVersionDirs lists pg_tblspc/<spc>/PG_* directories, putting
+// the one named want first when several versions are present. A base
+// backup has no pg_tblspc symlinks: tablespaces missing there are looked
+// up at the locations its tablespace_map records
+func tablespaceVersionDirs(dataDir, want string) []tablespaceDir {
+	spcRoot := filepath.Join(dataDir, "pg_tblspc")
+	roots := make(map[uint32][]string)
+	entries, _ := os.ReadDir(spcRoot)
+	for _, e := range entries {
+		if spc, err := strconv.ParseUint(e.Name(), 10, 32); err == nil {
+			roots[uint32(spc)] = append(roots[uint32(spc)], filepath.Join(spcRoot, e.Name()))
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dataDir, "tablespace_map")); err == nil {
+		for spc, path := range ParseTablespaceMap(data) {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dataDir, path)
+			}
+			roots[spc] = append(roots[spc], path)
+		}
+	}
+
+	var dirs []tablespaceDir
+	for _, spc := range sortedKeys(roots) {
+		for _, spcPath := range roots[spc] {
+			versions, err := os.ReadDir(spcPath) // follows the symlink
+			if err != nil {
+				continue
+			}
+			sort.SliceStable(versions, func(i, j int) bool {
+				return versions[i].Name() == want && versions[j].Name() != want
+			})
+			for _, v := range versions {
+				if strings.HasPrefix(v.Name(), "PG_") {
+					dirs = append(dirs, tablespaceDir{spc, filepath.Join(spcPath, v.Name())})
+				}
+			}
+			break
+		}
+	}
+	return dirs
+}
+
+// databaseDirs lists every directory holding database relations: base/<db>
+// and pg_tblspc/<spc>/PG_<ver>_<catver>/<db>
+func databaseDirs(dataDir string) []string {
+	var dirs []string
+	roots := []string{filepath.Join(dataDir, "base")}
+	for _, dir := range tablespaceVersionDirs(dataDir, "") {
+		roots = append(roots, dir.path)
+	}
+	for _, root := range roots {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if _, err := strconv.ParseUint(e.Name(), 10, 32); err == nil && e.IsDir() {
+				dirs = append(dirs, filepath.Join(root, e.Name()))
+			}
+		}
+	}
+	return dirs
+}
+
+func sortedKeys[V any](m map[uint32]V) []uint32 {
+	keys := make([]uint32, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
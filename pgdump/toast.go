@@ -13,11 +13,12 @@ import (
 )
 
 // TOASTPointer represents a TOAST pointer in PostgreSQL
-// Format: varatt_external (18 bytes):
+// On disk it is a 1-byte varlena header (0x01), a vartag byte and, for
+// VARTAG_ONDISK, a varatt_external (16 bytes):
 //
-//	va_rawsize (4), va_extsize (4), va_valueid (4), va_toastrelid (4)
+//	va_rawsize (4), va_extinfo (4), va_valueid (4), va_toastrelid (4)
 type TOASTPointer struct {
-	RawSize      uint32 // Original uncompressed size
+	RawSize      uint32 // Original uncompressed size (including varlena header)
 	ExtSize      uint32 // External (compressed) size
 	ValueID      uint32 // chunk_id in TOAST table
 	ToastRelID   uint32 // OID of TOAST table
@@ -38,46 +39,47 @@ const (
 	ToastCompressionLZ4  = 1
 )
 
-// TOAST varlena tags
+// TOAST varlena tags (second byte of an external datum)
 const (
-	VarTagExternal           = 0x01
-	VarTagCompressedExternal = 0x02
-	VarTagIndirect           = 0x01 // With high bit of size
+	VarTagIndirect   = 0x01
+	VarTagExpandedRO = 0x02
+	VarTagExpandedRW = 0x03
+	VarTagOnDisk     = 0x12
 )
 
-// ParseTOASTPointer extracts TOAST pointer info from a varlena value
-func ParseTOASTPointer(data []byte) *TOASTPointer {
-	if len(data) < 18 {
-		return nil
+// varlenaExternalSize returns the on-disk size of an external datum
+// (header byte + tag byte + pointer payload), or 0 if the tag is unknown
+func varlenaExternalSize(data []byte) int {
+	if len(data) < 2 || data[0] != 0x01 {
+		return 0
 	}
-
-	// Check for TOAST pointer indicator
-	tag := data[0]
-	
-	// External TOAST: first byte is 0x01 (uncompressed) or 0x02 (compressed)
-	// With 1-byte header format
-	if tag != 0x01 && tag != 0x02 && tag != 0x12 {
-		return nil
+	switch data[1] {
+	case VarTagOnDisk:
+		return 2 + 16
+	case VarTagIndirect, VarTagExpandedRO, VarTagExpandedRW:
+		return 2 + 8 // in-memory pointer, never written to disk
 	}
+	return 0
+}
 
-	// varatt_external structure starts at byte 1 (after the tag)
-	offset := 1
-	if len(data) < offset+16 {
+// ParseTOASTPointer extracts TOAST pointer info from a varlena value
+func ParseTOASTPointer(data []byte) *TOASTPointer {
+	if len(data) < 18 || data[0] != 0x01 || data[1] != VarTagOnDisk {
 		return nil
 	}
 
+	// varatt_external structure starts after the header and tag bytes
+	offset := 2
 	ptr := &TOASTPointer{
-		IsCompressed: tag == 0x02 || tag == 0x12,
+		RawSize: binary.LittleEndian.Uint32(data[offset : offset+4]),
 	}
-
-	// va_rawsize includes compression method in high 2 bits
-	rawSizeField := binary.LittleEndian.Uint32(data[offset : offset+4])
-	ptr.RawSize = rawSizeField & 0x3FFFFFFF
-	ptr.CompressionMethod = int(rawSizeField >> 30)
 	offset += 4
 
-	// va_extsize (external/compressed size)
-	ptr.ExtSize = binary.LittleEndian.Uint32(data[offset : offset+4])
+	// va_extinfo holds the external size; since PG14 the top 2 bits
+	// carry the compression method
+	extInfo := binary.LittleEndian.Uint32(data[offset : offset+4])
+	ptr.ExtSize = extInfo & 0x3FFFFFFF
+	ptr.CompressionMethod = int(extInfo >> 30)
 	offset += 4
 
 	// va_valueid (chunk_id)
@@ -87,25 +89,40 @@ func ParseTOASTPointer(data []byte) *TOASTPointer {
 	// va_toastrelid
 	ptr.ToastRelID = binary.LittleEndian.Uint32(data[offset : offset+4])
 
+	// VARATT_EXTERNAL_IS_COMPRESSED: stored size smaller than raw payload
+	ptr.IsCompressed = ptr.RawSize >= 4 && ptr.ExtSize < ptr.RawSize-4
+
 	return ptr
 }
 
 // IsTOASTPointer checks if data is a TOAST pointer
 func IsTOASTPointer(data []byte) bool {
-	if len(data) < 2 {
-		return false
-	}
-	first := data[0]
-	return first == 0x01 || first == 0x02 || first == 0x12
+	return varlenaExternalSize(data) > 0
 }
 
 // ReadTOASTTable reads all chunks from a TOAST table file
 func ReadTOASTTable(data []byte) []TOASTChunk {
+	return readTOASTTable(data, nil)
+}
+
+// readTOASTTable reads chunks whatever their visibility: deleting or
+// updating a row deletes its TOAST values in the same transaction, and
+// deleted rows and older snapshots still point at them. Value OIDs are
+// unique on their own, so one chunk is kept per (chunk_id, chunk_seq),
+// preferring one whose inserter committed according to xact (nil = hint
+// bits)
+func readTOASTTable(data []byte, xact *CommitLog) []TOASTChunk {
 	var chunks []TOASTChunk
+	type chunkKey struct {
+		id  uint32
+		seq int32
+	}
+	seen := make(map[chunkKey]int)
+	var committed []bool
 
 	// TOAST table schema:
 	// chunk_id (oid/4), chunk_seq (int4/4), chunk_data (bytea/varlena)
-	for _, entry := range ReadTuples(data, true) {
+	for _, entry := range ReadTuples(data, false) {
 		tuple := entry.Tuple
 		if tuple == nil || len(tuple.Data) < 8 {
 			continue
@@ -129,9 +146,20 @@ func ReadTOASTTable(data []byte) []TOASTChunk {
 			chunk.Data = chunkData
 		}
 
-		if len(chunk.Data) > 0 {
-			chunks = append(chunks, chunk)
+		if len(chunk.Data) == 0 {
+			continue
 		}
+		ok := xact.XminCommitted(tuple.Header)
+		key := chunkKey{chunk.ChunkID, chunk.ChunkSeq}
+		if i, dup := seen[key]; dup {
+			if ok && !committed[i] {
+				chunks[i], committed[i] = chunk, true
+			}
+			continue
+		}
+		seen[key] = len(chunks)
+		chunks = append(chunks, chunk)
+		committed = append(committed, ok)
 	}
 
 	return chunks
@@ -164,30 +192,11 @@ func ReassembleTOAST(chunks []TOASTChunk, valueID uint32, ptr *TOASTPointer) []b
 
 	data := buf.Bytes()
 
-	// Decompress if needed
-	if ptr != nil && ptr.IsCompressed && len(data) > 0 {
-		rawSize := int(ptr.RawSize)
-		
-		// Try LZ4 first if compression method indicates it
-		if ptr.CompressionMethod == ToastCompressionLZ4 {
-			if decompressed, err := decompressLZ4(data, rawSize); err == nil {
-				return decompressed
-			}
-		}
-		
-		// Try pglz
-		if decompressed, err := decompressPGLZ(data, rawSize); err == nil && len(decompressed) > 0 {
+	// Compressed values keep their 4-byte tcinfo word in front of the payload
+	if ptr != nil && ptr.IsCompressed && len(data) > 4 {
+		if decompressed, err := decompressVarlena(data); err == nil {
 			return decompressed
 		}
-		
-		// Try zlib as fallback
-		if r, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
-			defer r.Close()
-			if decompressed, err := io.ReadAll(r); err == nil {
-				return decompressed
-			}
-		}
-		
 		// Return compressed data if decompression fails
 		return data
 	}
@@ -195,7 +204,42 @@ func ReassembleTOAST(chunks []TOASTChunk, valueID uint32, ptr *TOASTPointer) []b
 	return data
 }
 
-// decompressPGLZ decompresses PostgreSQL's pglz format
+// decompressVarlena decompresses a compressed datum body: a tcinfo word
+// (raw size in the low 30 bits, method in the top 2) followed by the payload
+func decompressVarlena(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("compressed datum too short")
+	}
+	tcinfo := u32(data, 0)
+	return decompressDatum(data[4:], int(tcinfo&0x3FFFFFFF), int(tcinfo>>30))
+}
+
+// decompressDatum decompresses a pglz or lz4 payload to rawSize bytes
+func decompressDatum(data []byte, rawSize, method int) ([]byte, error) {
+	if method == ToastCompressionLZ4 {
+		if decompressed, err := decompressLZ4(data, rawSize); err == nil && len(decompressed) == rawSize {
+			return decompressed, nil
+		}
+	}
+
+	if decompressed, err := decompressPGLZ(data, rawSize); err == nil && len(decompressed) == rawSize {
+		return decompressed, nil
+	}
+
+	// Try zlib as fallback
+	if r, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+		defer r.Close()
+		if decompressed, err := io.ReadAll(r); err == nil {
+			return decompressed, nil
+		}
+	}
+
+	return nil, fmt.Errorf("cannot decompress %d bytes (method %d)", len(data), method)
+}
+
+// decompressPGLZ decompresses PostgreSQL's pglz format to exactly rawSize
+// bytes, failing on truncated or leftover input (pglz_decompress with
+// check_complete)
 func decompressPGLZ(data []byte, rawSize int) ([]byte, error) {
 	if len(data) < 4 {
 		return nil, fmt.Errorf("data too short")
@@ -212,18 +256,27 @@ func decompressPGLZ(data []byte, rawSize int) ([]byte, error) {
 			if ctrl&(1<<bit) != 0 {
 				// Back-reference
 				if pos+1 >= len(data) {
-					break
+					return nil, fmt.Errorf("back-reference truncated at %d", pos)
 				}
 				b1, b2 := data[pos], data[pos+1]
 				pos += 2
 
-				offset := int(b1) | (int(b2&0xF0) << 4)
-				length := int(b2&0x0F) + 3
+				// Tag: 4 bits length-3, 12 bits offset, optional extra length byte
+				length := int(b1&0x0F) + 3
+				offset := int(b1&0xF0)<<4 | int(b2)
+				if length == 18 {
+					if pos >= len(data) {
+						return nil, fmt.Errorf("back-reference truncated at %d", pos)
+					}
+					length += int(data[pos])
+					pos++
+				}
 
 				if offset == 0 || offset > len(result) {
-					continue
+					return nil, fmt.Errorf("invalid back-reference offset %d", offset)
 				}
 
+				// A reference may overlap what it produces
 				start := len(result) - offset
 				for i := 0; i < length && len(result) < rawSize; i++ {
 					result = append(result, result[start+i%offset])
@@ -235,10 +288,17 @@ func decompressPGLZ(data []byte, rawSize int) ([]byte, error) {
 		}
 	}
 
+	if pos != len(data) {
+		return nil, fmt.Errorf("%d bytes left over", len(data)-pos)
+	}
+	if len(result) != rawSize {
+		return nil, fmt.Errorf("decompressed to %d bytes, want %d", len(result), rawSize)
+	}
 	return result, nil
 }
 
-// decompressLZ4 decompresses LZ4 compressed data
+// decompressLZ4 decompresses LZ4 compressed data to exactly rawSize bytes,
+// failing on input that overruns it (LZ4_decompress_safe)
 // LZ4 block format: https://github.com/lz4/lz4/blob/dev/doc/lz4_Block_format.md
 func decompressLZ4(data []byte, rawSize int) ([]byte, error) {
 	if len(data) < 1 {
@@ -248,7 +308,22 @@ func decompressLZ4(data []byte, rawSize int) ([]byte, error) {
 	result := make([]byte, 0, rawSize)
 	pos := 0
 
-	for pos < len(data) && len(result) < rawSize {
+	// Lengths of 15 continue in bytes up to the first that is not 255
+	extend := func(n int) (int, error) {
+		for {
+			if pos >= len(data) {
+				return 0, fmt.Errorf("length truncated at %d", pos)
+			}
+			extra := int(data[pos])
+			pos++
+			n += extra
+			if extra != 255 {
+				return n, nil
+			}
+		}
+	}
+
+	for pos < len(data) {
 		// Read token
 		token := data[pos]
 		pos++
@@ -256,31 +331,27 @@ func decompressLZ4(data []byte, rawSize int) ([]byte, error) {
 		// Literal length
 		literalLen := int(token >> 4)
 		if literalLen == 15 {
-			for pos < len(data) {
-				extra := int(data[pos])
-				pos++
-				literalLen += extra
-				if extra != 255 {
-					break
-				}
+			var err error
+			if literalLen, err = extend(literalLen); err != nil {
+				return nil, err
 			}
 		}
 
 		// Copy literals
-		if pos+literalLen > len(data) {
-			literalLen = len(data) - pos
+		if pos+literalLen > len(data) || len(result)+literalLen > rawSize {
+			return nil, fmt.Errorf("literals overrun at %d", pos)
 		}
 		result = append(result, data[pos:pos+literalLen]...)
 		pos += literalLen
 
-		// Check if we've reached the end
-		if pos >= len(data) || len(result) >= rawSize {
+		// The last sequence has literals only
+		if pos == len(data) {
 			break
 		}
 
 		// Read match offset (little-endian 16-bit)
 		if pos+2 > len(data) {
-			break
+			return nil, fmt.Errorf("match offset truncated at %d", pos)
 		}
 		offset := int(data[pos]) | (int(data[pos+1]) << 8)
 		pos += 2
@@ -290,37 +361,43 @@ func decompressLZ4(data []byte, rawSize int) ([]byte, error) {
 		}
 
 		// Match length
-		matchLen := int(token & 0x0F) + 4
-		if matchLen == 19 {
-			for pos < len(data) {
-				extra := int(data[pos])
-				pos++
-				matchLen += extra
-				if extra != 255 {
-					break
-				}
+		matchLen := int(token & 0x0F)
+		if matchLen == 15 {
+			var err error
+			if matchLen, err = extend(matchLen); err != nil {
+				return nil, err
 			}
 		}
+		matchLen += 4
 
 		// Copy match
 		if offset > len(result) {
 			return nil, fmt.Errorf("offset too large")
 		}
-		
+		if len(result)+matchLen > rawSize {
+			return nil, fmt.Errorf("match overruns %d-byte output", rawSize)
+		}
+
 		start := len(result) - offset
-		for i := 0; i < matchLen && len(result) < rawSize; i++ {
+		for i := 0; i < matchLen; i++ {
 			result = append(result, result[start+i%offset])
 		}
 	}
 
+	if len(result) != rawSize {
+		return nil, fmt.Errorf("decompressed to %d bytes, want %d", len(result), rawSize)
+	}
 	return result, nil
 }
 
 // TOASTReader provides TOAST-aware value reading
 type TOASTReader struct {
-	chunks   map[uint32][]TOASTChunk // keyed by ToastRelID
-	dataDir  string
-	dbOID    uint32
+	chunks    map[uint32][]TOASTChunk // keyed by ToastRelID
+	dataDir   string
+	dbOID     uint32
+	reader    FileReader        // fetches TOAST relations by filenode
+	filenodes map[uint32]uint32 // TOAST relation OID -> filenode
+	xact      *CommitLog        // decides chunk visibility (nil = hint bits)
 }
 
 // NewTOASTReader creates a new TOAST reader
@@ -339,9 +416,27 @@ func NewTOASTReaderForDB(dataDir string, dbOID uint32) *TOASTReader {
 	}
 }
 
+// NewTOASTReaderFromFiles creates a TOAST reader that fetches TOAST relations
+// through reader, resolving their filenodes from parsed pg_class entries.
+// xact decides which chunks are live (nil = hint bits)
+func NewTOASTReaderFromFiles(tables map[uint32]TableInfo, reader FileReader, xact *CommitLog) *TOASTReader {
+	r := &TOASTReader{
+		chunks:    make(map[uint32][]TOASTChunk),
+		reader:    reader,
+		filenodes: make(map[uint32]uint32),
+		xact:      xact,
+	}
+	for filenode, info := range tables {
+		if info.Kind == "t" {
+			r.filenodes[info.OID] = filenode
+		}
+	}
+	return r
+}
+
 // LoadTOASTTable loads chunks from a TOAST table
 func (r *TOASTReader) LoadTOASTTable(toastRelID uint32, data []byte) {
-	r.chunks[toastRelID] = ReadTOASTTable(data)
+	r.chunks[toastRelID] = readTOASTTable(data, r.xact)
 }
 
 // LoadTOASTTableFromFile loads a TOAST table from the data directory
@@ -353,7 +448,7 @@ func (r *TOASTReader) LoadTOASTTableFromFile(toastRelID uint32) error {
 	basePath := filepath.Join(r.dataDir, "base", strconv.FormatUint(uint64(r.dbOID), 10))
 	toastPath := filepath.Join(basePath, strconv.FormatUint(uint64(toastRelID), 10))
 	
-	data, err := os.ReadFile(toastPath)
+	data, err := ReadRelationFile(toastPath)
 	if err != nil {
 		return err
 	}
@@ -371,9 +466,7 @@ func (r *TOASTReader) ReadValue(data []byte) []byte {
 
 	// Try to load TOAST table if not already loaded
 	if _, ok := r.chunks[ptr.ToastRelID]; !ok {
-		if r.dataDir != "" {
-			r.LoadTOASTTableFromFile(ptr.ToastRelID)
-		}
+		r.load(ptr.ToastRelID)
 	}
 
 	chunks, ok := r.chunks[ptr.ToastRelID]
@@ -384,6 +477,28 @@ func (r *TOASTReader) ReadValue(data []byte) []byte {
 	return ReassembleTOAST(chunks, ptr.ValueID, ptr)
 }
 
+// load fetches a TOAST relation on first use. A failed load is cached as
+// empty so a missing relation is not re-read for every value.
+func (r *TOASTReader) load(toastRelID uint32) {
+	switch {
+	case r.reader != nil:
+		filenode, ok := r.filenodes[toastRelID]
+		if !ok {
+			filenode = toastRelID // never rewritten: filenode still equals OID
+		}
+		data, err := r.reader(filenode)
+		if err != nil {
+			r.chunks[toastRelID] = nil
+			return
+		}
+		r.LoadTOASTTable(toastRelID, data)
+	case r.dataDir != "":
+		if r.LoadTOASTTableFromFile(toastRelID) != nil {
+			r.chunks[toastRelID] = nil
+		}
+	}
+}
+
 // GetTOASTInfo returns information about TOAST pointers in a table
 type TOASTInfo struct {
 	TableName    string   `json:"table_name"`
@@ -508,23 +623,25 @@ func AnalyzeTOAST(dataDir, dbName string) ([]TOASTInfo, error) {
 	
 	var results []TOASTInfo
 	
-	// Parse pg_class with extended schema to get reltoastrelid
-	for _, entry := range ReadTuples(classData, true) {
-		tuple := entry.Tuple
-		if tuple == nil || len(tuple.Data) < 60 {
+	// Map each TOAST relation to its owning table via reltoastrelid
+	tables := ParsePGClass(classData)
+	for _, info := range tables {
+		if info.ToastRelID == 0 {
 			continue
 		}
-		
-		// Check if this table has a TOAST table (reltoastrelid at offset ~48-52)
-		// This is approximate - proper parsing would use full schema
-		toastRelID := u32(tuple.Data, 48)
-		if toastRelID == 0 {
-			continue
+
+		// Resolve the TOAST relation's filenode (differs from its OID after rewrites)
+		toastFilenode := info.ToastRelID
+		for filenode, t := range tables {
+			if t.OID == info.ToastRelID {
+				toastFilenode = filenode
+				break
+			}
 		}
-		
+
 		// Try to read the TOAST table
-		toastPath := filepath.Join(basePath, strconv.FormatUint(uint64(toastRelID), 10))
-		toastData, err := os.ReadFile(toastPath)
+		toastPath := filepath.Join(basePath, strconv.FormatUint(uint64(toastFilenode), 10))
+		toastData, err := ReadRelationFile(toastPath)
 		if err != nil {
 			continue
 		}
@@ -543,7 +660,8 @@ func AnalyzeTOAST(dataDir, dbName string) ([]TOASTInfo, error) {
 		}
 		
 		results = append(results, TOASTInfo{
-			ToastRelID:   toastRelID,
+			TableName:    info.Name,
+			ToastRelID:   info.ToastRelID,
 			TotalChunks:  len(chunks),
 			UniqueValues: len(uniqueValues),
 			TotalSize:    totalSize,
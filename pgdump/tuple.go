@@ -1,12 +1,80 @@
 package pgdump
 
+import "fmt"
+
 const tupleHeaderSize = 23
 
+// t_infomask bits (access/htup_details.h)
+const (
+	HeapHasNull        = 0x0001
+	HeapHasVarWidth    = 0x0002
+	HeapHasExternal    = 0x0004
+	HeapXmaxKeyShrLock = 0x0010
+	HeapComboCID       = 0x0020
+	HeapXmaxExclLock   = 0x0040
+	HeapXmaxLockOnly   = 0x0080
+	HeapXminCommitted  = 0x0100
+	HeapXminInvalid    = 0x0200
+	HeapXminFrozen     = HeapXminCommitted | HeapXminInvalid
+	HeapXmaxCommitted  = 0x0400
+	HeapXmaxInvalid    = 0x0800
+	HeapXmaxIsMulti    = 0x1000
+	HeapUpdated        = 0x2000
+	HeapMovedOff       = 0x4000
+	HeapMovedIn        = 0x8000
+
+	heapLockMask = HeapXmaxExclLock | HeapXmaxKeyShrLock
+)
+
+// t_infomask2 bits
+const (
+	HeapNattsMask   = 0x07FF
+	HeapKeysUpdated = 0x2000
+	HeapHotUpdated  = 0x4000
+	HeapOnlyTuple   = 0x8000
+)
+
+// infomaskFlags names t_infomask bits in the order pageinspect lists them
+var infomaskFlags = []struct {
+	bit  uint16
+	name string
+}{
+	{HeapHasNull, "HAS_NULL"},
+	{HeapHasVarWidth, "HAS_VARWIDTH"},
+	{HeapHasExternal, "HAS_EXTERNAL"},
+	{HeapXmaxKeyShrLock, "XMAX_KEYSHR_LOCK"},
+	{HeapComboCID, "COMBOCID"},
+	{HeapXmaxExclLock, "XMAX_EXCL_LOCK"},
+	{HeapXmaxLockOnly, "XMAX_LOCK_ONLY"},
+	{HeapXminCommitted, "XMIN_COMMITTED"},
+	{HeapXminInvalid, "XMIN_INVALID"},
+	{HeapXmaxCommitted, "XMAX_COMMITTED"},
+	{HeapXmaxInvalid, "XMAX_INVALID"},
+	{HeapXmaxIsMulti, "XMAX_IS_MULTI"},
+	{HeapUpdated, "UPDATED"},
+	{HeapMovedOff, "MOVED_OFF"},
+	{HeapMovedIn, "MOVED_IN"},
+}
+
+var infomask2Flags = []struct {
+	bit  uint16
+	name string
+}{
+	{HeapKeysUpdated, "KEYS_UPDATED"},
+	{HeapHotUpdated, "HOT_UPDATED"},
+	{HeapOnlyTuple, "HEAP_ONLY"},
+}
+
 // HeapTupleHeader contains tuple metadata
 type HeapTupleHeader struct {
+	Xmin, Xmax    uint32 // inserting and deleting/locking transaction
+	Cid           uint32 // t_cid: command ID, combo CID or xvac
+	CtidBlock     uint32 // t_ctid: this tuple or its newer version
+	CtidOffset    uint16
 	THoff         uint8
 	Natts         int
 	Infomask      uint16
+	Infomask2     uint16
 	XminCommitted bool
 	XmaxInvalid   bool
 	XmaxCommitted bool
@@ -35,13 +103,19 @@ func ParseHeapTuple(data []byte) *HeapTupleData {
 	}
 
 	header := &HeapTupleHeader{
+		Xmin:          u32(data, 0),
+		Xmax:          u32(data, 4),
+		Cid:           u32(data, 8),
+		CtidBlock:     uint32(u16(data, 12))<<16 | uint32(u16(data, 14)),
+		CtidOffset:    u16(data, 16),
 		THoff:         hoff,
-		Natts:         int(infomask2 & 0x07FF),
+		Natts:         int(infomask2 & HeapNattsMask),
 		Infomask:      infomask,
-		HasNull:       infomask&0x0001 != 0,
-		XminCommitted: infomask&0x0100 != 0,
-		XmaxCommitted: infomask&0x0400 != 0,
-		XmaxInvalid:   infomask&0x0800 != 0,
+		Infomask2:     infomask2,
+		HasNull:       infomask&HeapHasNull != 0,
+		XminCommitted: infomask&HeapXminCommitted != 0,
+		XmaxCommitted: infomask&HeapXmaxCommitted != 0,
+		XmaxInvalid:   infomask&HeapXmaxInvalid != 0,
 	}
 
 	tuple := &HeapTupleData{
@@ -59,7 +133,35 @@ func ParseHeapTuple(data []byte) *HeapTupleData {
 	return tuple
 }
 
-// IsVisible checks if tuple is visible (committed and not deleted)
+// Ctid returns t_ctid as PostgreSQL prints a tid, e.g. "(0,1)"
+func (h *HeapTupleHeader) Ctid() string {
+	return fmt.Sprintf("(%d,%d)", h.CtidBlock, h.CtidOffset)
+}
+
+// Flags names the t_infomask and t_infomask2 bits that are set, with
+// XMIN_FROZEN in place of XMIN_COMMITTED|XMIN_INVALID
+func (h *HeapTupleHeader) Flags() []string {
+	flags := []string{}
+	frozen := h.Infomask&HeapXminFrozen == HeapXminFrozen
+	for _, f := range infomaskFlags {
+		if h.Infomask&f.bit == 0 || frozen && f.bit&HeapXminFrozen != 0 {
+			continue
+		}
+		flags = append(flags, f.name)
+	}
+	if frozen {
+		flags = append(flags, "XMIN_FROZEN")
+	}
+	for _, f := range infomask2Flags {
+		if h.Infomask2&f.bit != 0 {
+			flags = append(flags, f.name)
+		}
+	}
+	return flags
+}
+
+// IsVisible checks if tuple is visible (committed and not deleted),
+// trusting hint bits only; see CommitLog.IsVisible for pg_xact lookups
 func (t *HeapTupleData) IsVisible() bool {
 	h := t.Header
 	return h.XminCommitted && (h.XmaxInvalid || !h.XmaxCommitted)
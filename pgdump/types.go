@@ -144,6 +144,9 @@ func TypeName(oid int) string {
 	if name, ok := typeNames[oid]; ok {
 		return name
 	}
+	if elem, ok := arrayElemTypes[oid]; ok {
+		return TypeName(elem) + "[]"
+	}
 	return fmt.Sprintf("oid:%d", oid)
 }
 
@@ -559,6 +562,18 @@ func decodeInet(data []byte) string {
 }
 
 func decodeArray(raw []byte, elemOid int) []interface{} {
+	elemLen, fixed := fixedLengths[elemOid]
+	if !fixed {
+		elemLen = -1
+	}
+	return decodeArrayWith(raw, elemLen, typeAlign(elemOid, elemLen), func(b []byte) interface{} {
+		return DecodeType(b, elemOid)
+	})
+}
+
+// decodeArrayWith decodes an array datum given its element storage;
+// elemLen is -1 for varlena elements
+func decodeArrayWith(raw []byte, elemLen, elemAlign int, decode func([]byte) interface{}) []interface{} {
 	if len(raw) < 20 {
 		return nil
 	}
@@ -579,46 +594,43 @@ func decodeArray(raw []byte, elemOid int) []interface{} {
 	var nullBitmap []byte
 	dataStart := 12 + ndim*8
 	if dataoff > 0 {
+		if dataoff < dataStart+4 || int(dataStart+(total+7)/8) > len(raw) {
+			return nil
+		}
 		nullBitmap = raw[dataStart : dataStart+(total+7)/8]
-		dataStart = dataoff
+		dataStart = dataoff - 4 // dataoffset counts the varlena header
 	}
 
-	elemLen, fixed := fixedLengths[elemOid]
-	return parseArrayElements(raw, int(dataStart), int(total), elemOid, elemLen, fixed, nullBitmap)
+	return parseArrayElements(raw, int(dataStart), int(total), elemLen, elemAlign, nullBitmap, decode)
 }
 
-func parseArrayElements(raw []byte, off, count, elemOid, elemLen int, fixed bool, nulls []byte) []interface{} {
+// parseArrayElements walks array elements, aligning each one to the
+// element type's alignment. raw starts after the 4-byte varlena header,
+// so offsets are aligned as if it were still there
+func parseArrayElements(raw []byte, off, count, elemLen, elemAlign int, nulls []byte, decode func([]byte) interface{}) []interface{} {
 	elems := make([]interface{}, 0, count)
 	for i := 0; i < count; i++ {
 		if nulls != nil && nulls[i/8]&(1<<(i%8)) == 0 {
 			elems = append(elems, nil)
 			continue
 		}
-		if fixed {
+		off = align(off+4, elemAlign) - 4
+		if elemLen > 0 {
 			if off+elemLen > len(raw) {
 				break
 			}
-			elems = append(elems, DecodeType(raw[off:off+elemLen], elemOid))
+			elems = append(elems, decode(raw[off:off+elemLen]))
 			off += elemLen
 		} else {
-			if i > 0 {
-				off = align(off, 4)
-			}
 			if off >= len(raw) {
 				break
 			}
-			if hdr := raw[off]; hdr&1 == 1 {
-				n := int(hdr >> 1)
-				elems = append(elems, DecodeType(raw[off+1:off+n], elemOid))
-				off += n
-			} else {
-				if off+4 > len(raw) {
-					break
-				}
-				n := int(u32(raw, off) >> 2)
-				elems = append(elems, DecodeType(raw[off+4:off+n], elemOid))
-				off += n
+			val, n := ReadVarlena(raw[off:])
+			if n == 0 {
+				break
 			}
+			elems = append(elems, decode(val))
+			off += n
 		}
 	}
 	return elems
@@ -628,7 +640,10 @@ func parseArrayElements(raw []byte, off, count, elemOid, elemLen int, fixed bool
 // PostgreSQL varlena format:
 // - Short varlena: first byte has bit0=1, length = (first_byte >> 1), includes header
 // - Long varlena: 4-byte header with bit0=0, length = (header >> 2), includes header
-// - TOAST pointer: first byte = 0x01 (external storage)
+// - Compressed in-line: 4-byte header with low bits 10, then a tcinfo word
+//   (raw size and method); the payload is decompressed before returning
+// - TOAST pointer: first byte = 0x01 (external storage), followed by a vartag
+//   byte; returns nil data and the pointer size so callers can resolve it
 func ReadVarlena(data []byte) ([]byte, int) {
 	if len(data) == 0 {
 		return nil, 0
@@ -645,8 +660,11 @@ func ReadVarlena(data []byte) ([]byte, int) {
 		return data[1:totalLen], totalLen
 	}
 	
-	// Check for TOAST pointer (external storage, treat as null)
+	// Check for TOAST pointer (external storage, resolved by the caller)
 	if first == 1 {
+		if size := varlenaExternalSize(data); size > 0 && len(data) >= size {
+			return nil, size
+		}
 		return nil, 1
 	}
 	
@@ -659,6 +677,13 @@ func ReadVarlena(data []byte) ([]byte, int) {
 	if totalLen < 4 || len(data) < totalLen {
 		return nil, 4
 	}
+	if first&0x03 == 0x02 {
+		decompressed, err := decompressVarlena(data[4:totalLen])
+		if err != nil {
+			return nil, totalLen
+		}
+		return decompressed, totalLen
+	}
 	return data[4:totalLen], totalLen
 }
 
@@ -3,9 +3,9 @@ package pgdump
 import (
 	"encoding/binary"
 	"fmt"
-	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -71,6 +71,27 @@ const (
 	XLOG_HEAP_INPLACE      = 0x70
 )
 
+// Heap2 operation info bits
+const (
+	XLOG_HEAP2_REWRITE      = 0x00
+	XLOG_HEAP2_PRUNE        = 0x10
+	XLOG_HEAP2_VACUUM       = 0x20
+	XLOG_HEAP2_FREEZE_PAGE  = 0x30
+	XLOG_HEAP2_VISIBLE      = 0x40
+	XLOG_HEAP2_MULTI_INSERT = 0x50
+	XLOG_HEAP2_LOCK_UPDATED = 0x60
+	XLOG_HEAP2_NEW_CID      = 0x70
+)
+
+// XLOG_HEAP_INIT_PAGE marks heap records that start a new page
+const XLOG_HEAP_INIT_PAGE = 0x80
+
+// XLOG records made of full-page images only
+const (
+	XLOG_FPI_FOR_HINT = 0xA0
+	XLOG_FPI          = 0xB0
+)
+
 // Transaction operation info bits
 const (
 	XLOG_XACT_COMMIT          = 0x00
@@ -106,8 +127,16 @@ type WALRecord struct {
 	LSN           uint64 `json:"lsn"`
 	RMName        string `json:"rm_name"`
 	Operation     string `json:"operation"`
+	Origin        uint16 `json:"origin,omitempty"`       // replication origin
+	ToplevelXID   uint32 `json:"toplevel_xid,omitempty"` // set for subtransactions (PG 14+)
 	// Block references
 	Blocks        []WALBlockRef `json:"blocks,omitempty"`
+	// Main data, interpreted by the resource manager
+	MainData      []byte `json:"-"`
+	MainDataLen   int    `json:"main_data_len,omitempty"`
+
+	version int    // PostgreSQL major version that wrote it
+	end     uint64 // where the next record may start (EndRecPtr), set by WALReader
 }
 
 // WALBlockRef represents a block reference in a WAL record
@@ -117,6 +146,23 @@ type WALBlockRef struct {
 	Flags       uint16 `json:"flags"`
 	RelFileNode *RelFileNode `json:"relfilenode,omitempty"`
 	BlockNum    uint32 `json:"block_num"`
+	WillInit    bool   `json:"will_init,omitempty"` // redo reinitializes the page
+	Image       *WALBlockImage `json:"image,omitempty"`
+	// Block data, interpreted by the resource manager
+	Data        []byte `json:"-"`
+	DataLen     int    `json:"data_len,omitempty"`
+}
+
+// WALBlockImage is a full-page image stored in a record, without its hole
+// and possibly compressed
+type WALBlockImage struct {
+	Length      uint16 `json:"length"` // bytes stored
+	HoleOffset  uint16 `json:"hole_offset,omitempty"`
+	HoleLength  uint16 `json:"hole_length,omitempty"`
+	Info        uint8  `json:"info"`
+	Apply       bool   `json:"apply"`                 // restored by redo, not only kept for wal_consistency_checking
+	Compression string `json:"compression,omitempty"` // pglz, lz4 or zstd
+	Data        []byte `json:"-"`
 }
 
 // RelFileNode identifies a relation file
@@ -146,74 +192,25 @@ type TransactionInfo struct {
 	Operations int   `json:"operations"`
 }
 
-// ParseWALFile parses a single WAL segment file
+// ParseWALFile parses a single WAL segment file. Records continuing into
+// the next segment are left out
 func ParseWALFile(data []byte) ([]WALRecord, error) {
 	if len(data) < LongHeaderSize {
 		return nil, fmt.Errorf("WAL file too small")
 	}
-
-	var records []WALRecord
-	pageNum := 0
-
-	for offset := 0; offset+WALPageSize <= len(data); offset += WALPageSize {
-		pageData := data[offset : offset+WALPageSize]
-		pageRecords, err := parseWALPage(pageData, uint64(offset), pageNum)
-		if err != nil {
-			continue // Skip invalid pages
-		}
-		records = append(records, pageRecords...)
-		pageNum++
-	}
-
-	return records, nil
-}
-
-func parseWALPage(data []byte, baseOffset uint64, pageNum int) ([]WALRecord, error) {
-	if len(data) < ShortHeaderSize {
-		return nil, fmt.Errorf("page too small")
-	}
-
-	header := parsePageHeader(data)
-	
-	// Validate magic
-	if !isValidMagic(header.Magic) {
-		return nil, fmt.Errorf("invalid magic: 0x%04X", header.Magic)
-	}
-
-	headerSize := ShortHeaderSize
-	if header.Info&XLP_LONG_HEADER != 0 {
-		headerSize = LongHeaderSize
+	if h := parsePageHeader(data); !isValidMagic(h.Magic) {
+		return nil, fmt.Errorf("invalid magic: 0x%04X", h.Magic)
 	}
 
 	var records []WALRecord
-	pos := headerSize
-
-	// If this page continues a record from previous page, skip the continuation
-	if header.Info&XLP_FIRST_IS_CONTRECORD != 0 && header.RemLen > 0 {
-		pos += int(header.RemLen)
-		pos = align8(pos)
-	}
-
-	// Parse records
-	for pos+XLogRecordSize <= len(data) {
-		// Check for padding (zeros)
-		if isZeroPadding(data[pos:]) {
-			break
-		}
-
-		rec, consumed := parseXLogRecord(data[pos:], baseOffset+uint64(pos))
-		if consumed == 0 {
+	r := newWALDataReader(data)
+	for {
+		rec, err := r.Next()
+		if err != nil {
 			break
 		}
-
-		if rec != nil {
-			records = append(records, *rec)
-		}
-
-		pos += consumed
-		pos = align8(pos)
+		records = append(records, *rec)
 	}
-
 	return records, nil
 }
 
@@ -235,15 +232,19 @@ func parsePageHeader(data []byte) *WALPageHeader {
 	return h
 }
 
-func parseXLogRecord(data []byte, lsn uint64) (*WALRecord, int) {
+// parseXLogRecord decodes a complete record of WAL format version (the
+// PostgreSQL major version, from the page magic). A record whose xl_crc
+// does not match is an error
+func parseXLogRecord(data []byte, lsn uint64, version int) (*WALRecord, error) {
 	if len(data) < XLogRecordSize {
-		return nil, 0
+		return nil, fmt.Errorf("record too short")
 	}
 
 	totalLen := binary.LittleEndian.Uint32(data[0:4])
-	if totalLen < XLogRecordSize || totalLen > WALPageSize*2 {
-		return nil, 0
+	if totalLen < XLogRecordSize || int(totalLen) > len(data) {
+		return nil, fmt.Errorf("invalid record length %d", totalLen)
 	}
+	data = data[:totalLen]
 
 	rec := &WALRecord{
 		TotalLen:      totalLen,
@@ -253,90 +254,26 @@ func parseXLogRecord(data []byte, lsn uint64) (*WALRecord, int) {
 		ResourceMgr:   data[17],
 		CRC:           binary.LittleEndian.Uint32(data[20:24]),
 		LSN:           lsn,
+		version:       version,
 	}
 
 	rec.RMName = rmgrName(rec.ResourceMgr)
 	rec.Operation = operationName(rec.ResourceMgr, rec.Info)
 
-	// Parse block references if present
-	if int(totalLen) > XLogRecordSize && int(totalLen) <= len(data) {
-		rec.Blocks = parseBlockRefs(data[XLogRecordSize:totalLen])
+	// The CRC covers the body, then the header up to xl_crc
+	crc := updateCRC32C(0xFFFFFFFF, data[XLogRecordSize:])
+	crc = updateCRC32C(crc, data[:20])
+	if crc^0xFFFFFFFF != rec.CRC {
+		return nil, fmt.Errorf("incorrect record checksum")
 	}
 
-	return rec, int(totalLen)
-}
-
-func parseBlockRefs(data []byte) []WALBlockRef {
-	var blocks []WALBlockRef
-	pos := 0
-
-	for pos < len(data) {
-		if pos+1 > len(data) {
-			break
-		}
-
-		blockID := data[pos]
-		pos++
-
-		// Check for XLR_BLOCK_ID_DATA_SHORT or XLR_BLOCK_ID_DATA_LONG
-		if blockID == 0xFF || blockID == 0xFE {
-			break // End of block references
-		}
-
-		if blockID > 32 {
-			break // Invalid block ID
-		}
-
-		if pos+1 > len(data) {
-			break
-		}
-
-		forkFlags := data[pos]
-		pos++
-
-		block := WALBlockRef{
-			ID:      blockID,
-			ForkNum: forkFlags & 0x0F,
-			Flags:   uint16(forkFlags),
-		}
-
-		// Check if BKPBLOCK_HAS_DATA
-		hasImage := (forkFlags & 0x10) != 0
-		hasData := (forkFlags & 0x20) != 0
-		hasSameRel := (forkFlags & 0x40) != 0
-
-		if !hasSameRel && pos+12 <= len(data) {
-			block.RelFileNode = &RelFileNode{
-				SpcOID: binary.LittleEndian.Uint32(data[pos : pos+4]),
-				DbOID:  binary.LittleEndian.Uint32(data[pos+4 : pos+8]),
-				RelOID: binary.LittleEndian.Uint32(data[pos+8 : pos+12]),
-			}
-			pos += 12
-		}
-
-		if pos+4 <= len(data) {
-			block.BlockNum = binary.LittleEndian.Uint32(data[pos : pos+4])
-			pos += 4
-		}
-
-		// Skip image data
-		if hasImage && pos+2 <= len(data) {
-			imageLen := binary.LittleEndian.Uint16(data[pos : pos+2])
-			pos += 2 + int(imageLen)
-		}
-
-		// Skip block data
-		if hasData && pos+2 <= len(data) {
-			dataLen := binary.LittleEndian.Uint16(data[pos : pos+2])
-			pos += 2 + int(dataLen)
-		}
-
-		blocks = append(blocks, block)
+	if err := decodeRecordBody(rec, data[XLogRecordSize:], version); err != nil {
+		return rec, err
 	}
-
-	return blocks
+	return rec, nil
 }
 
+
 func isValidMagic(magic uint16) bool {
 	switch magic {
 	case WAL_MAGIC_16, WAL_MAGIC_15, WAL_MAGIC_14, WAL_MAGIC_13, WAL_MAGIC_12:
@@ -361,6 +298,12 @@ func pgVersionFromMagic(magic uint16) string {
 	return "unknown"
 }
 
+// walVersion returns the PostgreSQL major version writing WAL with magic
+func walVersion(magic uint16) int {
+	v, _ := strconv.Atoi(pgVersionFromMagic(magic))
+	return v
+}
+
 func isZeroPadding(data []byte) bool {
 	for i := 0; i < 8 && i < len(data); i++ {
 		if data[i] != 0 {
@@ -428,19 +371,21 @@ func operationName(rmid, info uint8) string {
 		}
 	case RM_HEAP2_ID:
 		switch info & 0x70 {
-		case 0x00:
+		case XLOG_HEAP2_REWRITE:
+			return "REWRITE"
+		case XLOG_HEAP2_PRUNE:
 			return "PRUNE"
-		case 0x10:
+		case XLOG_HEAP2_VACUUM:
 			return "VACUUM"
-		case 0x20:
+		case XLOG_HEAP2_FREEZE_PAGE:
 			return "FREEZE_PAGE"
-		case 0x30:
+		case XLOG_HEAP2_VISIBLE:
 			return "VISIBLE"
-		case 0x40:
+		case XLOG_HEAP2_MULTI_INSERT:
 			return "MULTI_INSERT"
-		case 0x50:
+		case XLOG_HEAP2_LOCK_UPDATED:
 			return "LOCK_UPDATED"
-		case 0x60:
+		case XLOG_HEAP2_NEW_CID:
 			return "NEW_CID"
 		}
 	case RM_XACT_ID:
@@ -480,7 +425,11 @@ func operationName(rmid, info uint8) string {
 			return "FPW_CHANGE"
 		case 0x90:
 			return "END_OF_RECOVERY"
-		case 0xA0:
+		case XLOG_FPI_FOR_HINT:
+			return "FPI_FOR_HINT"
+		case XLOG_FPI:
+			return "FPI"
+		case 0xD0:
 			return "OVERWRITE_CONTRECORD"
 		}
 	case RM_SMGR_ID:
@@ -523,10 +472,20 @@ func FormatLSN(lsn uint64) string {
 	return fmt.Sprintf("%X/%X", lsn>>32, lsn&0xFFFFFFFF)
 }
 
+// ParseLSN parses an LSN written the way FormatLSN writes it
+func ParseLSN(s string) (uint64, error) {
+	hi, lo, ok := strings.Cut(s, "/")
+	h, err1 := strconv.ParseUint(hi, 16, 32)
+	l, err2 := strconv.ParseUint(lo, 16, 32)
+	if !ok || err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("invalid LSN %q (want e.g. 0/16B3748)", s)
+	}
+	return h<<32 | l, nil
+}
+
 // ScanWALDirectory scans pg_wal directory and returns summary
 func ScanWALDirectory(dataDir string) (*WALSummary, error) {
-	walDir := filepath.Join(dataDir, "pg_wal")
-	entries, err := os.ReadDir(walDir)
+	r, err := NewWALReader(filepath.Join(dataDir, "pg_wal"))
 	if err != nil {
 		return nil, fmt.Errorf("cannot read pg_wal: %w", err)
 	}
@@ -540,74 +499,46 @@ func ScanWALDirectory(dataDir string) (*WALSummary, error) {
 	txnStatus := make(map[uint32]string)
 	var firstLSN, lastLSN uint64
 
-	// Sort WAL files by name
-	var walFiles []string
-	for _, e := range entries {
-		name := e.Name()
-		if !e.IsDir() && len(name) == 24 && !strings.HasSuffix(name, ".history") {
-			walFiles = append(walFiles, name)
-		}
-	}
-	sort.Strings(walFiles)
-
-	for _, name := range walFiles {
-		data, err := os.ReadFile(filepath.Join(walDir, name))
+	for {
+		rec, err := r.Next()
 		if err != nil {
-			continue
-		}
-
-		records, err := ParseWALFile(data)
-		if err != nil {
-			continue
-		}
-
-		summary.SegmentCount++
-
-		// Get PG version from first page
-		if summary.PGVersion == "" && len(data) >= 2 {
-			magic := binary.LittleEndian.Uint16(data[0:2])
-			summary.PGVersion = pgVersionFromMagic(magic)
+			break
 		}
+		summary.RecordCount++
 
-		// Get timeline from first page
-		if summary.TimelineID == 0 && len(data) >= 8 {
-			summary.TimelineID = binary.LittleEndian.Uint32(data[4:8])
+		if firstLSN == 0 {
+			firstLSN = rec.LSN
 		}
-
-		for _, rec := range records {
-			summary.RecordCount++
-
-			if firstLSN == 0 || rec.LSN < firstLSN {
-				firstLSN = rec.LSN
-			}
-			if rec.LSN > lastLSN {
-				lastLSN = rec.LSN
-			}
-
-			summary.Operations[rec.Operation]++
-
-			// Track transactions
-			if rec.TransactionID != 0 {
-				txnOps[rec.TransactionID]++
-				if rec.ResourceMgr == RM_XACT_ID {
-					if strings.Contains(rec.Operation, "COMMIT") {
-						txnStatus[rec.TransactionID] = "COMMIT"
-					} else if strings.Contains(rec.Operation, "ABORT") {
-						txnStatus[rec.TransactionID] = "ABORT"
-					}
+		lastLSN = rec.LSN
+
+		summary.Operations[rec.Operation]++
+
+		// Track transactions
+		if rec.TransactionID != 0 {
+			txnOps[rec.TransactionID]++
+			if rec.ResourceMgr == RM_XACT_ID {
+				if strings.Contains(rec.Operation, "COMMIT") {
+					txnStatus[rec.TransactionID] = "COMMIT"
+				} else if strings.Contains(rec.Operation, "ABORT") {
+					txnStatus[rec.TransactionID] = "ABORT"
 				}
 			}
+		}
 
-			// Track affected tables
-			for _, block := range rec.Blocks {
-				if block.RelFileNode != nil && block.RelFileNode.RelOID != 0 {
-					key := fmt.Sprintf("%d/%d", block.RelFileNode.DbOID, block.RelFileNode.RelOID)
-					summary.AffectedTables[key]++
-				}
+		// Track affected tables
+		for _, block := range rec.Blocks {
+			if block.RelFileNode != nil && block.RelFileNode.RelOID != 0 {
+				key := fmt.Sprintf("%d/%d", block.RelFileNode.DbOID, block.RelFileNode.RelOID)
+				summary.AffectedTables[key]++
 			}
 		}
 	}
 
+	summary.SegmentCount = r.SegmentsRead()
+	if h := r.Header(); h != nil {
+		summary.PGVersion = pgVersionFromMagic(h.Magic)
+		summary.TimelineID = h.TimelineID
+	}
 	summary.FirstLSN = FormatLSN(firstLSN)
 	summary.LastLSN = FormatLSN(lastLSN)
 
@@ -632,41 +563,46 @@ func ScanWALDirectory(dataDir string) (*WALSummary, error) {
 	return summary, nil
 }
 
-// GetRecentWALRecords returns the most recent WAL records
+// GetRecentWALRecords returns the most recent WAL records. Segments are
+// read from the newest back, twice as many each pass, until they hold
+// limit records
 func GetRecentWALRecords(dataDir string, limit int) ([]WALRecord, error) {
-	walDir := filepath.Join(dataDir, "pg_wal")
-	entries, err := os.ReadDir(walDir)
+	if limit <= 0 {
+		return nil, nil
+	}
+	r, err := NewWALReader(filepath.Join(dataDir, "pg_wal"))
 	if err != nil {
 		return nil, err
 	}
 
-	var walFiles []string
-	for _, e := range entries {
-		name := e.Name()
-		if !e.IsDir() && len(name) == 24 && !strings.HasSuffix(name, ".history") {
-			walFiles = append(walFiles, name)
+	for n := 1; ; n *= 2 {
+		records, total := lastWALRecords(r.tail(n), limit)
+		if total >= limit || n >= len(r.segs) {
+			return records, nil
 		}
 	}
-	sort.Strings(walFiles)
-
-	var allRecords []WALRecord
+}
 
-	// Read from newest files first
-	for i := len(walFiles) - 1; i >= 0 && len(allRecords) < limit; i-- {
-		data, err := os.ReadFile(filepath.Join(walDir, walFiles[i]))
+// lastWALRecords reads r to the end and returns the last limit records,
+// oldest first, and how many records it read
+func lastWALRecords(r *WALReader, limit int) ([]WALRecord, int) {
+	ring := make([]WALRecord, 0, limit)
+	n := 0
+	for {
+		rec, err := r.Next()
 		if err != nil {
-			continue
+			break
 		}
-		records, err := ParseWALFile(data)
-		if err != nil {
-			continue
+		if len(ring) < limit {
+			ring = append(ring, *rec)
+		} else {
+			ring[n%limit] = *rec
 		}
-		allRecords = append(records, allRecords...)
+		n++
 	}
-
-	if len(allRecords) > limit {
-		allRecords = allRecords[len(allRecords)-limit:]
+	if n <= limit {
+		return ring, n
 	}
-
-	return allRecords, nil
+	start := n % limit
+	return append(ring[start:], ring[:start]...), n
 }
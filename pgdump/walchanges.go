@@ -0,0 +1,696 @@
+package pgdump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Kinds of WALChange
+const (
+	WALInsert = "insert"
+	WALUpdate = "update"
+	WALDelete = "delete"
+)
+
+// xl_heap_insert, xl_heap_update and xl_heap_delete flags
+const (
+	xlhUpdateContainsOldTuple = 1 << 2
+	xlhUpdateContainsOldKey   = 1 << 3
+	xlhUpdatePrefixFromOld    = 1 << 5
+	xlhUpdateSuffixFromOld    = 1 << 6
+	xlhDeleteContainsOldTuple = 1 << 1
+	xlhDeleteContainsOldKey   = 1 << 2
+)
+
+// Record layouts (heapam_xlog.h)
+const (
+	sizeOfHeapHeader       = 5 // t_infomask2, t_infomask, t_hoff
+	sizeOfHeapInsert       = 3
+	sizeOfHeapDelete       = 8
+	sizeOfHeapUpdate       = 14
+	sizeOfHeapMultiInsert  = 4
+	sizeOfMultiInsertTuple = 7
+)
+
+// WALChange is a row-level change decoded from a heap WAL record, the way
+// logical decoding (wal2json) reports it
+type WALChange struct {
+	LSN        string                 `json:"lsn"`
+	XID        uint32                 `json:"xid"`
+	Status     string                 `json:"status"` // committed, aborted or in_progress at the end of WAL
+	CommitTime *time.Time             `json:"commit_time,omitempty"`
+	Kind       string                 `json:"kind"`
+	Database   string                 `json:"database,omitempty"`
+	Table      string                 `json:"table,omitempty"` // empty when no pg_class row names the filenode
+	Filenode   uint32                 `json:"filenode"`
+	Ctid       string                 `json:"ctid"` // the new row version; the deleted one for a delete
+	OldCtid    string                 `json:"old_ctid,omitempty"`
+	Old        map[string]interface{} `json:"old,omitempty"`
+	New        map[string]interface{} `json:"new,omitempty"`
+	Error      string                 `json:"error,omitempty"` // why an image is missing
+}
+
+// ReadWALChanges decodes the heap records in pg_wal into row changes and
+// passes them to emit in LSN order. Rows are decoded with the pg_attribute
+// rows of the relation owning the filenode, dropped relations included,
+// so rows inserted and then vacuumed away come back. Old row images come
+// from the record (REPLICA IDENTITY FULL or key), a full-page image, an
+// earlier record, or the tuple still on disk when its xmax matches.
+// Commit status comes from the transaction records in WAL, then pg_xact.
+// opts filters by database, schema, table and system tables
+func ReadWALChanges(dataDir string, opts *Options, emit func(*WALChange) error) error {
+	opts = withDefaults(opts)
+	walDir := filepath.Join(dataDir, "pg_wal")
+
+	xacts, err := scanWALXacts(walDir)
+	if err != nil {
+		return fmt.Errorf("cannot read pg_wal: %w", err)
+	}
+	xacts.clog = commitLogFor(OpenCommitLog(dataDir), opts.Visibility)
+
+	dbData, err := readGlobalCatalog(dataDir, PGDatabase)
+	if err != nil {
+		return fmt.Errorf("cannot read pg_database: %w", err)
+	}
+	s := &walChangeStream{
+		dataDir:   dataDir,
+		opts:      opts,
+		xacts:     xacts,
+		dbNames:   make(map[uint32]string),
+		catalogs:  make(map[uint32]*walCatalog),
+		pages:     newWALPages(),
+		globalMap: loadRelMap(os.ReadFile(filepath.Join(dataDir, "global", "pg_filenode.map"))),
+	}
+	found := opts.DatabaseFilter == ""
+	for _, db := range ParsePGDatabase(dbData) {
+		s.dbNames[db.OID] = db.Name
+		found = found || db.Name == opts.DatabaseFilter
+	}
+	if !found {
+		return fmt.Errorf("database %q not found", opts.DatabaseFilter)
+	}
+
+	r, err := NewWALReader(walDir)
+	if err != nil {
+		return fmt.Errorf("cannot read pg_wal: %w", err)
+	}
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, c := range s.decode(rec) {
+			if err := emit(c); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// walXacts is what WAL and pg_xact say about transactions
+type walXacts struct {
+	status map[uint32]XactStatus
+	time   map[uint32]time.Time
+	parent map[uint32]uint32 // subtransaction -> top-level transaction
+	clog   *CommitLog
+}
+
+func newWALXacts() *walXacts {
+	return &walXacts{
+		status: make(map[uint32]XactStatus),
+		time:   make(map[uint32]time.Time),
+		parent: make(map[uint32]uint32),
+	}
+}
+
+// scanWALXacts reads every commit, abort and assignment record
+func scanWALXacts(walDir string) (*walXacts, error) {
+	r, err := NewWALReader(walDir)
+	if err != nil {
+		return nil, err
+	}
+	x := newWALXacts()
+	for {
+		rec, err := r.Next()
+		if err != nil {
+			return x, nil
+		}
+		x.add(rec)
+	}
+}
+
+// add records the outcome a transaction record reports
+func (x *walXacts) add(rec *WALRecord) {
+	if rec.ToplevelXID != 0 {
+		x.parent[rec.TransactionID] = rec.ToplevelXID
+	}
+	if rec.ResourceMgr != RM_XACT_ID {
+		return
+	}
+	d := rec.MainData
+	switch rec.Info & 0x70 {
+	case XLOG_XACT_ASSIGNMENT:
+		if len(d) < 8 {
+			return
+		}
+		top, n := u32(d, 0), int(i32(d, 4))
+		for i := 0; i < n && 8+4*i+4 <= len(d); i++ {
+			x.parent[u32(d, 8+4*i)] = top
+		}
+	case XLOG_XACT_COMMIT, XLOG_XACT_COMMIT_PREPARED:
+		xids, at := parseXactRecord(rec)
+		for _, xid := range xids {
+			x.status[xid] = XactCommitted
+			if !at.IsZero() {
+				x.time[xid] = at
+			}
+		}
+	case XLOG_XACT_ABORT, XLOG_XACT_ABORT_PREPARED:
+		xids, _ := parseXactRecord(rec)
+		for _, xid := range xids {
+			x.status[xid] = XactAborted
+		}
+	}
+}
+
+// parseXactRecord returns the transaction a commit or abort record ends
+// (the prepared one for COMMIT/ABORT PREPARED) and its subtransactions,
+// with the record's timestamp (ParseCommitRecord, ParseAbortRecord)
+func parseXactRecord(rec *WALRecord) (xids []uint32, at time.Time) {
+	d := rec.MainData
+	if len(d) < 8 {
+		return nil, at
+	}
+	at = pgEpoch.Add(time.Duration(i64(d, 0)) * time.Microsecond)
+	pos := 8
+	skip := func(n int) bool {
+		pos += n
+		return pos <= len(d)
+	}
+	count := func(size int) (int, bool) {
+		if pos+4 > len(d) {
+			return 0, false
+		}
+		n := int(i32(d, pos))
+		return n, n >= 0 && skip(4+n*size)
+	}
+
+	var xinfo uint32
+	if rec.Info&0x80 != 0 { // XLOG_XACT_HAS_INFO
+		if len(d) < 12 {
+			return nil, at
+		}
+		xinfo = u32(d, pos)
+		pos += 4
+	}
+	xid := rec.TransactionID
+	var subs []uint32
+	ok := true
+	if xinfo&0x01 != 0 { // dbinfo
+		ok = skip(8)
+	}
+	if ok && xinfo&0x02 != 0 { // subxacts
+		var n int
+		if n, ok = count(4); ok {
+			for i := 0; i < n; i++ {
+				subs = append(subs, u32(d, pos-4*n+4*i))
+			}
+		}
+	}
+	if ok && xinfo&0x04 != 0 { // relfilenodes
+		_, ok = count(12)
+	}
+	if ok && xinfo&0x100 != 0 && rec.version >= 15 { // dropped stats
+		_, ok = count(12)
+	}
+	if ok && xinfo&0x08 != 0 { // invalidation messages
+		_, ok = count(16)
+	}
+	if ok && xinfo&0x10 != 0 && pos+4 <= len(d) { // two-phase
+		xid = u32(d, pos)
+	}
+	return append([]uint32{xid}, subs...), at
+}
+
+// resolve returns the status of xid and its commit time: WAL first, then
+// its top-level transaction, then pg_xact
+func (x *walXacts) resolve(xid uint32) (XactStatus, *time.Time) {
+	for depth, cur := 0, xid; depth < 64 && cur != 0; depth++ {
+		if s, ok := x.status[cur]; ok {
+			if t, ok := x.time[cur]; ok {
+				return s, &t
+			}
+			return s, nil
+		}
+		cur = x.parent[cur]
+	}
+	if s, ok := x.clog.Status(xid); ok {
+		if t, ok := x.clog.CommitTime(xid); ok {
+			return s, &t
+		}
+		return s, nil
+	}
+	return XactInProgress, nil
+}
+
+// walCatalog resolves the filenodes of one database
+type walCatalog struct {
+	rels   *databaseRelations
+	tables map[string]walTable // by main fork path
+	dec    *Decoder
+}
+
+type walTable struct {
+	info    TableInfo
+	columns []Column
+}
+
+// walChangeStream turns records into changes
+type walChangeStream struct {
+	dataDir   string
+	opts      *Options
+	xacts     *walXacts
+	dbNames   map[uint32]string
+	catalogs  map[uint32]*walCatalog
+	pages     *walPages
+	globalMap *RelMapFile
+}
+
+// catalog opens the catalog of a database on first use; nil when it
+// cannot be read
+func (s *walChangeStream) catalog(dbOID uint32) *walCatalog {
+	if c, ok := s.catalogs[dbOID]; ok {
+		return c
+	}
+	var c *walCatalog
+	if rels, err := openRelations(s.dataDir, dbOID, s.xacts.clog, s.globalMap); err == nil {
+		c = &walCatalog{rels: rels, tables: make(map[string]walTable)}
+		byFilenode := make(map[uint32]TableInfo)
+		for path, rel := range rels.dead {
+			c.tables[path] = walTable{rel.info, columnsFromAttrs(rel.attrs)}
+			byFilenode[rel.info.Filenode] = rel.info
+		}
+		for path, info := range rels.live {
+			c.tables[path] = walTable{info, columnsFromAttrs(rels.attrs[info.OID])}
+			byFilenode[info.Filenode] = info
+		}
+		c.dec = &Decoder{TOAST: NewTOASTReaderFromFiles(byFilenode, rels.loc.reader(byFilenode, os.ReadFile), s.xacts.clog)}
+	}
+	s.catalogs[dbOID] = c
+	return c
+}
+
+// wanted reports whether changes of a relation pass the filters
+func (s *walChangeStream) wanted(rel *RelFileNode, t *walTable) bool {
+	o := s.opts
+	if o.DatabaseFilter != "" && s.dbNames[rel.DbOID] != o.DatabaseFilter {
+		return false
+	}
+	if t == nil {
+		return o.TableFilter == "" && o.SchemaFilter == "" && (!o.SkipSystemTables || rel.DbOID != 0)
+	}
+	return o.wantTable(t.info)
+}
+
+// walChangeContext is a change being decoded, with the relation it touches
+type walChangeContext struct {
+	s     *walChangeStream
+	rec   *WALRecord
+	path  string
+	table *walTable
+	cat   *walCatalog
+}
+
+// decode returns the changes of one record
+func (s *walChangeStream) decode(rec *WALRecord) []*WALChange {
+	if rec.ResourceMgr != RM_HEAP_ID && rec.ResourceMgr != RM_HEAP2_ID {
+		return nil
+	}
+
+	// Full-page images hold the page after the change: old tuples are
+	// still there with xmax set, new ones are in place
+	for _, blk := range rec.Blocks {
+		if blk.Image == nil || blk.ForkNum != 0 || blk.RelFileNode == nil {
+			continue
+		}
+		if page, err := blk.Image.Page(); err == nil {
+			s.pages.install(s.blockKey(&blk), page)
+		}
+	}
+
+	if len(rec.Blocks) == 0 || rec.Blocks[0].RelFileNode == nil {
+		return nil
+	}
+	op := rec.Info & 0x70
+	switch {
+	case rec.ResourceMgr == RM_HEAP_ID && (op == XLOG_HEAP_INSERT || op == XLOG_HEAP_DELETE || op == XLOG_HEAP_UPDATE || op == XLOG_HEAP_HOT_UPDATE):
+	case rec.ResourceMgr == RM_HEAP2_ID && op == XLOG_HEAP2_MULTI_INSERT:
+	default:
+		return nil
+	}
+	if rec.Info&XLOG_HEAP_INIT_PAGE != 0 {
+		s.pages.reset(s.blockKey(&rec.Blocks[0]))
+	}
+
+	rel := rec.Blocks[0].RelFileNode
+	ctx := &walChangeContext{s: s, rec: rec}
+	if ctx.cat = s.catalog(rel.DbOID); ctx.cat != nil {
+		ctx.path = ctx.cat.rels.loc.path(rel.SpcOID, rel.RelOID)
+		if t, ok := ctx.cat.tables[ctx.path]; ok {
+			ctx.table = &t
+		}
+	}
+	if !s.wanted(rel, ctx.table) {
+		return nil
+	}
+
+	var changes []*WALChange
+	switch {
+	case rec.ResourceMgr == RM_HEAP2_ID:
+		changes = ctx.multiInsert()
+	case op == XLOG_HEAP_INSERT:
+		changes = ctx.insert()
+	case op == XLOG_HEAP_DELETE:
+		changes = ctx.delete()
+	default:
+		changes = ctx.update()
+	}
+
+	status, at := s.xacts.resolve(rec.TransactionID)
+	for _, c := range changes {
+		c.LSN, c.XID = FormatLSN(rec.LSN), rec.TransactionID
+		c.Status, c.CommitTime = status.String(), at
+		c.Database = s.dbNames[rel.DbOID]
+		c.Filenode = rel.RelOID
+		if ctx.table != nil {
+			c.Table = qualifiedName(ctx.table.info.Schema, ctx.table.info.Name)
+		}
+	}
+	return changes
+}
+
+func (s *walChangeStream) blockKey(blk *WALBlockRef) walBlockKey {
+	r := blk.RelFileNode
+	return walBlockKey{*r, blk.BlockNum}
+}
+
+// row decodes a tuple with the table's columns; nil when the relation is
+// unknown or the tuple missing
+func (ctx *walChangeContext) row(raw []byte) map[string]interface{} {
+	if raw == nil || ctx.table == nil {
+		return nil
+	}
+	return ctx.cat.dec.DecodeTuple(ParseHeapTuple(raw), ctx.table.columns)
+}
+
+// walTupleBytes rebuilds a heap tuple from an xl_heap_header and the bytes
+// after the fixed tuple header (null bitmap, padding, data)
+func walTupleBytes(xmin, xmax uint32, header, rest []byte) []byte {
+	raw := make([]byte, tupleHeaderSize+len(rest))
+	binary.LittleEndian.PutUint32(raw[0:], xmin)
+	binary.LittleEndian.PutUint32(raw[4:], xmax)
+	binary.LittleEndian.PutUint16(raw[18:], u16(header, 0))
+	binary.LittleEndian.PutUint16(raw[20:], u16(header, 2))
+	raw[22] = header[4]
+	copy(raw[tupleHeaderSize:], rest)
+	return raw
+}
+
+func (ctx *walChangeContext) insert() []*WALChange {
+	blk, d := &ctx.rec.Blocks[0], ctx.rec.MainData
+	if len(d) < sizeOfHeapInsert {
+		return nil
+	}
+	offnum := u16(d, 0)
+	key := ctx.s.blockKey(blk)
+	c := &WALChange{Kind: WALInsert, Ctid: fmt.Sprintf("(%d,%d)", blk.BlockNum, offnum)}
+
+	var raw []byte
+	if len(blk.Data) >= sizeOfHeapHeader {
+		raw = walTupleBytes(ctx.rec.TransactionID, 0, blk.Data, blk.Data[sizeOfHeapHeader:])
+		ctx.s.pages.put(key, offnum, raw)
+	} else if raw = ctx.s.pages.tuple(ctx, key, offnum, 0); raw == nil {
+		c.Error = "new tuple not in WAL"
+	}
+	c.New = ctx.row(raw)
+	return []*WALChange{c}
+}
+
+func (ctx *walChangeContext) multiInsert() []*WALChange {
+	blk, d := &ctx.rec.Blocks[0], ctx.rec.MainData
+	if len(d) < sizeOfHeapMultiInsert {
+		return nil
+	}
+	n := int(u16(d, 2))
+	initPage := ctx.rec.Info&XLOG_HEAP_INIT_PAGE != 0
+	key := ctx.s.blockKey(blk)
+
+	var changes []*WALChange
+	pos := 0
+	for i := 0; i < n; i++ {
+		offnum := uint16(i + 1)
+		if !initPage {
+			if sizeOfHeapMultiInsert+2*i+2 > len(d) {
+				break
+			}
+			offnum = u16(d, sizeOfHeapMultiInsert+2*i)
+		}
+		c := &WALChange{Kind: WALInsert, Ctid: fmt.Sprintf("(%d,%d)", blk.BlockNum, offnum)}
+
+		// xl_multi_insert_tuple headers are SHORTALIGNed
+		var raw []byte
+		pos = (pos + 1) &^ 1
+		if pos+sizeOfMultiInsertTuple <= len(blk.Data) {
+			size := int(u16(blk.Data, pos))
+			start := pos + sizeOfMultiInsertTuple
+			if start+size > len(blk.Data) {
+				break
+			}
+			raw = walTupleBytes(ctx.rec.TransactionID, 0, blk.Data[pos+2:], blk.Data[start:start+size])
+			ctx.s.pages.put(key, offnum, raw)
+			pos = start + size
+		} else if raw = ctx.s.pages.tuple(ctx, key, offnum, 0); raw == nil {
+			c.Error = "new tuple not in WAL"
+		}
+		c.New = ctx.row(raw)
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+func (ctx *walChangeContext) delete() []*WALChange {
+	blk, d := &ctx.rec.Blocks[0], ctx.rec.MainData
+	if len(d) < sizeOfHeapDelete {
+		return nil
+	}
+	xmax, offnum, flags := u32(d, 0), u16(d, 4), d[7]
+	c := &WALChange{Kind: WALDelete, Ctid: fmt.Sprintf("(%d,%d)", blk.BlockNum, offnum)}
+
+	var raw []byte
+	if flags&(xlhDeleteContainsOldTuple|xlhDeleteContainsOldKey) != 0 && len(d) >= sizeOfHeapDelete+sizeOfHeapHeader {
+		raw = walTupleBytes(0, xmax, d[sizeOfHeapDelete:], d[sizeOfHeapDelete+sizeOfHeapHeader:])
+	} else if raw = ctx.s.pages.tuple(ctx, ctx.s.blockKey(blk), offnum, xmax); raw == nil {
+		c.Error = "old tuple not in WAL or on disk"
+	}
+	c.Old = ctx.row(raw)
+	return []*WALChange{c}
+}
+
+func (ctx *walChangeContext) update() []*WALChange {
+	rec, d := ctx.rec, ctx.rec.MainData
+	if len(d) < sizeOfHeapUpdate {
+		return nil
+	}
+	oldXmax, oldOff, flags := u32(d, 0), u16(d, 4), d[7]
+	newXmax, newOff := u32(d, 8), u16(d, 12)
+	newBlk, oldBlk := &rec.Blocks[0], &rec.Blocks[0]
+	if len(rec.Blocks) > 1 {
+		oldBlk = &rec.Blocks[1]
+	}
+	newKey, oldKey := ctx.s.blockKey(newBlk), ctx.s.blockKey(oldBlk)
+	c := &WALChange{
+		Kind:    WALUpdate,
+		Ctid:    fmt.Sprintf("(%d,%d)", newBlk.BlockNum, newOff),
+		OldCtid: fmt.Sprintf("(%d,%d)", oldBlk.BlockNum, oldOff),
+	}
+
+	// The old version: logged in full, on a page, or only its key
+	var logged []byte
+	if flags&(xlhUpdateContainsOldTuple|xlhUpdateContainsOldKey) != 0 && len(d) >= sizeOfHeapUpdate+sizeOfHeapHeader {
+		logged = walTupleBytes(0, oldXmax, d[sizeOfHeapUpdate:], d[sizeOfHeapUpdate+sizeOfHeapHeader:])
+	}
+	onPage := ctx.s.pages.tuple(ctx, oldKey, oldOff, oldXmax)
+	switch {
+	case logged != nil && flags&xlhUpdateContainsOldTuple != 0:
+		c.Old = ctx.row(logged)
+	case onPage != nil:
+		c.Old = ctx.row(onPage)
+	case logged != nil:
+		c.Old = ctx.row(logged)
+	}
+
+	// The new version, whose prefix and suffix may be shared with the
+	// old one on the page (heap_xlog_update)
+	var raw []byte
+	if data := newBlk.Data; len(data) > 0 {
+		prefix, suffix := 0, 0
+		if flags&xlhUpdatePrefixFromOld != 0 && len(data) >= 2 {
+			prefix, data = int(u16(data, 0)), data[2:]
+		}
+		if flags&xlhUpdateSuffixFromOld != 0 && len(data) >= 2 {
+			suffix, data = int(u16(data, 0)), data[2:]
+		}
+		if len(data) >= sizeOfHeapHeader {
+			header, rest := data[:sizeOfHeapHeader], data[sizeOfHeapHeader:]
+			raw = rebuildUpdatedTuple(header, rest, onPage, prefix, suffix)
+			if raw != nil {
+				raw = walTupleBytes(rec.TransactionID, newXmax, header, raw)
+			}
+		}
+		if raw == nil {
+			c.Error = "new tuple shares bytes with an old tuple not in WAL or on disk"
+		}
+	} else if raw = ctx.s.pages.tuple(ctx, newKey, newOff, 0); raw == nil {
+		c.Error = "new tuple not in WAL"
+	}
+	if raw != nil {
+		ctx.s.pages.put(newKey, newOff, raw)
+	}
+	if c.Old == nil && c.Error == "" {
+		c.Error = "old tuple not in WAL or on disk"
+	}
+	c.New = ctx.row(raw)
+	return []*WALChange{c}
+}
+
+// rebuildUpdatedTuple returns the bytes after the fixed header of a new
+// tuple version: the null bitmap from rest, prefix bytes of the old
+// tuple's data, the rest, then suffix bytes of the old data
+func rebuildUpdatedTuple(header, rest, old []byte, prefix, suffix int) []byte {
+	if prefix == 0 && suffix == 0 {
+		return rest
+	}
+	if old == nil || len(old) < tupleHeaderSize {
+		return nil
+	}
+	oldData := old[min(int(old[22]), len(old)):]
+	bitmap := int(header[4]) - tupleHeaderSize
+	if bitmap < 0 || bitmap > len(rest) || prefix+suffix > len(oldData) {
+		return nil
+	}
+	out := append([]byte(nil), rest[:bitmap]...)
+	out = append(out, oldData[:prefix]...)
+	out = append(out, rest[bitmap:]...)
+	return append(out, oldData[len(oldData)-suffix:]...)
+}
+
+// walBlockKey identifies a main fork block
+type walBlockKey struct {
+	rel   RelFileNode
+	block uint32
+}
+
+// walPages is what is known of heap pages while reading WAL: the latest
+// full-page image or the block on disk, overlaid with tuples written by
+// records since
+type walPages struct {
+	pages  map[walBlockKey][]byte
+	disk   map[walBlockKey]bool // page read from disk: current state, not the state at the record
+	tuples map[walBlockKey]map[uint16][]byte
+}
+
+func newWALPages() *walPages {
+	return &walPages{
+		pages:  make(map[walBlockKey][]byte),
+		disk:   make(map[walBlockKey]bool),
+		tuples: make(map[walBlockKey]map[uint16][]byte),
+	}
+}
+
+// install replaces what is known of a block with a full-page image
+func (p *walPages) install(k walBlockKey, page []byte) {
+	p.pages[k] = page
+	delete(p.disk, k)
+	delete(p.tuples, k)
+}
+
+// reset forgets a block that a record reinitializes
+func (p *walPages) reset(k walBlockKey) {
+	p.install(k, make([]byte, PageSize))
+}
+
+// put records a tuple a record wrote
+func (p *walPages) put(k walBlockKey, offnum uint16, raw []byte) {
+	if p.tuples[k] == nil {
+		p.tuples[k] = make(map[uint16][]byte)
+	}
+	p.tuples[k][offnum] = raw
+}
+
+// tuple returns the tuple at offnum of a block. A block only known from
+// disk may have been vacuumed and reused since, so its tuple must carry
+// the xmax the record set (unless xmax is 0)
+func (p *walPages) tuple(ctx *walChangeContext, k walBlockKey, offnum uint16, xmax uint32) []byte {
+	if raw, ok := p.tuples[k][offnum]; ok {
+		return raw
+	}
+	page, ok := p.pages[k]
+	if !ok && ctx.path != "" {
+		if data, err := readRelationBlock(ctx.cat.rels.loc.path(k.rel.SpcOID, k.rel.RelOID), k.block); err == nil {
+			page = data
+			p.disk[k] = true
+		}
+		p.pages[k] = page
+	}
+	raw := pageTuple(page, offnum)
+	if raw != nil && p.disk[k] && xmax != 0 && u32(raw, 4) != xmax {
+		return nil
+	}
+	return raw
+}
+
+// pageTuple returns the bytes of the LP_NORMAL item offnum of a heap page
+func pageTuple(page []byte, offnum uint16) []byte {
+	if len(page) < PageSize || offnum == 0 {
+		return nil
+	}
+	h := parseHeader(page)
+	if !validHeader(h) {
+		return nil
+	}
+	items := parseItems(page, h)
+	if int(offnum) > len(items) {
+		return nil
+	}
+	it := items[offnum-1]
+	if it.Flags != lpNormal || it.Length < tupleHeaderSize || it.Offset+it.Length > PageSize {
+		return nil
+	}
+	return page[it.Offset : it.Offset+it.Length]
+}
+
+// readRelationBlock reads one block of a relation's main fork, from the
+// segment file holding it
+func readRelationBlock(path string, block uint32) ([]byte, error) {
+	perSegment := uint32(DefaultSegmentSize / PageSize)
+	if seg := block / perSegment; seg > 0 {
+		path = fmt.Sprintf("%s.%d", path, seg)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	page := make([]byte, PageSize)
+	if _, err := f.ReadAt(page, int64(block%perSegment)*PageSize); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
@@ -0,0 +1,147 @@
+package pgdump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+)
+
+// walBlock is a block reference of a record built by walBlockRecord
+type walBlock struct {
+	rel   RelFileNode
+	block uint32
+	data  []byte
+}
+
+// walBlockRecord builds an XLogRecord with block data and main data
+func walBlockRecord(xid uint32, rmid, info uint8, mainData []byte, blocks ...walBlock) []byte {
+	rec := make([]byte, XLogRecordSize)
+	binary.LittleEndian.PutUint32(rec[4:], xid)
+	rec[16], rec[17] = info, rmid
+	for i, b := range blocks {
+		flags := uint8(0)
+		if len(b.data) > 0 {
+			flags = bkpBlockHasData
+		}
+		rec = append(rec, byte(i), flags)
+		rec = binary.LittleEndian.AppendUint16(rec, uint16(len(b.data)))
+		rec = binary.LittleEndian.AppendUint32(rec, b.rel.SpcOID)
+		rec = binary.LittleEndian.AppendUint32(rec, b.rel.DbOID)
+		rec = binary.LittleEndian.AppendUint32(rec, b.rel.RelOID)
+		rec = binary.LittleEndian.AppendUint32(rec, b.block)
+	}
+	rec = append(rec, xlrBlockIDDataShort, byte(len(mainData)))
+	for _, b := range blocks {
+		rec = append(rec, b.data...)
+	}
+	rec = append(rec, mainData...)
+	binary.LittleEndian.PutUint32(rec[0:], uint32(len(rec)))
+	return rec
+}
+
+func TestReadWALChanges(t *testing.T) {
+	le16 := func(v uint16) []byte { return binary.LittleEndian.AppendUint16(nil, v) }
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	cat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	live := uint16(HeapXminCommitted | HeapXmaxInvalid)
+	user := func(id uint32, name string) []byte {
+		return makeHeapTuple(0, 0, 0, 2, nil, cat(le32(id), makeVarlena([]byte(name))))
+	}
+	// xl_heap_header is t_infomask2, t_infomask and t_hoff, which end the
+	// tuple header: WAL carries a tuple from offset 18
+	logged := func(tuple []byte) []byte { return tuple[18:] }
+
+	dataDir := t.TempDir()
+	writeFiles(t, dataDir, map[string][]byte{
+		"global/1262": makeHeapPage(catalogTuple(1, 0, live, schemaPGDatabase, map[string][]byte{
+			"oid": le32(16384), "datname": nameDatum("shop"),
+		})),
+		"base/16384/1259": makeHeapPage(catalogTuple(100, 0, live, schemaPGClass, map[string][]byte{
+			"oid": le32(16385), "relname": nameDatum("users"), "relnamespace": le32(2200),
+			"relfilenode": le32(16385), "relkind": {'r'},
+		})),
+		"base/16384/1249": makeHeapPage(
+			catalogTuple(100, 0, live, schemaPGAttrV15, map[string][]byte{
+				"attrelid": le32(16385), "attname": nameDatum("id"), "atttypid": le32(OidInt4),
+				"attlen": le16(4), "attnum": le16(1), "attbyval": {1}, "attalign": {'i'},
+			}),
+			catalogTuple(100, 0, live, schemaPGAttrV15, map[string][]byte{
+				"attrelid": le32(16385), "attname": nameDatum("name"), "atttypid": le32(OidText),
+				"attlen": le16(0xFFFF), "attnum": le16(2), "attalign": {'i'},
+			}),
+		),
+	})
+
+	users := RelFileNode{SpcOID: 1663, DbOID: 16384, RelOID: 16385}
+	b := newWALBuilder(DefaultWALSegSize, 1)
+	b.add(walBlockRecord(700, RM_HEAP_ID, XLOG_HEAP_INSERT|XLOG_HEAP_INIT_PAGE, cat(le16(1), []byte{0}),
+		walBlock{users, 0, logged(user(1, "alice"))}))
+	b.add(walBlockRecord(700, RM_HEAP_ID, XLOG_HEAP_INSERT, cat(le16(2), []byte{0}),
+		walBlock{users, 0, logged(user(2, "bob"))}))
+	b.add(walRecord(700, RM_XACT_ID, XLOG_XACT_COMMIT, make([]byte, 8)))
+	// Same-page update: the old version comes from the first insert
+	b.add(walBlockRecord(701, RM_HEAP_ID, XLOG_HEAP_HOT_UPDATE, cat(le32(701), le16(1), []byte{0, 0}, le32(0), le16(3)),
+		walBlock{users, 0, logged(user(1, "carol"))}))
+	b.add(walRecord(701, RM_XACT_ID, XLOG_XACT_COMMIT, make([]byte, 8)))
+	b.add(walBlockRecord(702, RM_HEAP_ID, XLOG_HEAP_DELETE, cat(le32(702), le16(2), []byte{0, 0}),
+		walBlock{users, 0, nil}))
+	b.add(walRecord(702, RM_XACT_ID, XLOG_XACT_ABORT, make([]byte, 8)))
+	// Two tuples on a new page, in a transaction that never ended
+	multi := cat(le16(uint16(len(user(3, "dave"))-tupleHeaderSize)), logged(user(3, "dave")))
+	multi = append(multi, make([]byte, len(multi)%2)...)
+	multi = cat(multi, le16(uint16(len(user(4, "erin"))-tupleHeaderSize)), logged(user(4, "erin")))
+	b.add(walBlockRecord(703, RM_HEAP2_ID, XLOG_HEAP2_MULTI_INSERT|XLOG_HEAP_INIT_PAGE, cat([]byte{0, 0}, le16(2)),
+		walBlock{users, 1, multi}))
+	writeFiles(t, filepath.Join(dataDir, "pg_wal"), b.files())
+
+	read := func(opts *Options) []*WALChange {
+		t.Helper()
+		var changes []*WALChange
+		err := ReadWALChanges(dataDir, opts, func(c *WALChange) error {
+			changes = append(changes, c)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return changes
+	}
+
+	changes := read(&Options{DatabaseFilter: "shop", TableFilter: "USERS"})
+	want := []struct {
+		kind, status, ctid, old, new string
+	}{
+		{WALInsert, "committed", "(0,1)", "", "alice"},
+		{WALInsert, "committed", "(0,2)", "", "bob"},
+		{WALUpdate, "committed", "(0,3)", "alice", "carol"},
+		{WALDelete, "aborted", "(0,2)", "bob", ""},
+		{WALInsert, "in_progress", "(1,1)", "", "dave"},
+		{WALInsert, "in_progress", "(1,2)", "", "erin"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes, want %d: %+v", len(changes), len(want), changes)
+	}
+	for i, w := range want {
+		c := changes[i]
+		if c.Kind != w.kind || c.Status != w.status || c.Ctid != w.ctid || c.Table != "users" || c.Database != "shop" || c.Error != "" {
+			t.Errorf("change %d = %+v", i, c)
+		}
+		if w.old != "" && (c.Old == nil || c.Old["name"] != w.old) || w.old == "" && c.Old != nil {
+			t.Errorf("change %d old = %v, want name %q", i, c.Old, w.old)
+		}
+		if w.new != "" && (c.New == nil || c.New["name"] != w.new) || w.new == "" && c.New != nil {
+			t.Errorf("change %d new = %v, want name %q", i, c.New, w.new)
+		}
+	}
+	if changes[2].OldCtid != "(0,1)" || changes[2].New["id"] != int32(1) || changes[2].CommitTime == nil {
+		t.Errorf("update = %+v", changes[2])
+	}
+
+	if got := read(&Options{TableFilter: "orders"}); len(got) != 0 {
+		t.Errorf("table filter kept %d changes", len(got))
+	}
+	if err := ReadWALChanges(dataDir, &Options{DatabaseFilter: "missing"}, nil); err == nil {
+		t.Error("ReadWALChanges found a missing database")
+	}
+}
@@ -0,0 +1,46 @@
+package pgdump
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// decompressImage expands a compressed page image to exactly size bytes,
+// the page without its hole (RestoreBlockImage in xlogreader.c)
+func decompressImage(method string, src []byte, size int) ([]byte, error) {
+	var out []byte
+	var err error
+	switch method {
+	case CompressionPGLZ:
+		out, err = decompressPGLZ(src, size)
+	case CompressionLZ4:
+		out, err = decompressLZ4(src, size)
+	case CompressionZSTD:
+		out, err = zstdDecompress(src, size)
+	default:
+		return nil, fmt.Errorf("unknown image compression %q", method)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+	if len(out) != size {
+		return nil, fmt.Errorf("%s: image decompressed to %d bytes, want %d", method, len(out), size)
+	}
+	return out, nil
+}
+
+// zstdDecoder is shared: DecodeAll is safe for concurrent use
+var zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+	return zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+})
+
+// zstdDecompress decodes a zstd frame (ZSTD_decompress)
+func zstdDecompress(src []byte, size int) ([]byte, error) {
+	d, err := zstdDecoder()
+	if err != nil {
+		return nil, err
+	}
+	return d.DecodeAll(src, make([]byte, 0, size))
+}
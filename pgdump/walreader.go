@@ -0,0 +1,333 @@
+package pgdump
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// WAL segment limits
+const (
+	DefaultWALSegSize = 16 * 1024 * 1024
+	walMaxRecordSize  = 1020 * 1024 * 1024 // XLogRecordMaxSize
+
+	rmMaxBuiltinID = RM_LOGICALMSG_ID
+	rmMinCustomID  = 128
+)
+
+// WALReader reads WAL records in LSN order, reassembling records that
+// span pages and segment files like PostgreSQL's XLogReader: a record's
+// continuation on the next page must carry XLP_FIRST_IS_CONTRECORD with
+// the matching xlp_rem_len, and each record's xl_prev must point at the
+// one before it. Where the chain breaks (end of WAL, a recycled or
+// missing segment, corruption) reading resumes at the first record that
+// starts in the next segment file
+type WALReader struct {
+	segs    []walSegment
+	segSize uint64
+	header  *WALPageHeader // long header of the first segment
+
+	seg    int    // index in segs of the loaded segment
+	data   []byte // loaded segment
+	pos    uint64 // where the next record starts
+	prev   uint64 // LSN of the last record read, 0 after a resync
+	sync   bool   // pos is a segment start: skip the tail of a record from before
+	loaded int
+}
+
+// walSegment is one segment file, or in-memory data for ParseWALFile
+type walSegment struct {
+	segno uint64
+	path  string
+	data  []byte
+}
+
+// NewWALReader opens the segment files of a pg_wal (or archive)
+// directory. When several timelines have a segment with the same number
+// the highest timeline is read
+func NewWALReader(walDir string) (*WALReader, error) {
+	entries, err := os.ReadDir(walDir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if _, _, _, ok := parseWALFileName(e.Name()); ok && !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	r := &WALReader{seg: -1, segSize: DefaultWALSegSize}
+	for _, name := range names {
+		if h := readWALLongHeader(filepath.Join(walDir, name)); h != nil && h.SegSize > 0 {
+			r.segSize = uint64(h.SegSize)
+			break
+		}
+	}
+
+	bySegno := make(map[uint64]walSegment)
+	for _, name := range names { // sorted: later timelines win
+		_, log, seg, _ := parseWALFileName(name)
+		segno := log*(0x100000000/r.segSize) + seg
+		bySegno[segno] = walSegment{segno: segno, path: filepath.Join(walDir, name)}
+	}
+	for _, s := range bySegno {
+		r.segs = append(r.segs, s)
+	}
+	sort.Slice(r.segs, func(i, j int) bool { return r.segs[i].segno < r.segs[j].segno })
+	return r, nil
+}
+
+// newWALDataReader reads records from one segment held in memory
+func newWALDataReader(data []byte) *WALReader {
+	h := parsePageHeader(data)
+	r := &WALReader{seg: -1, segSize: uint64(len(data))}
+	if h.SegSize > 0 {
+		r.segSize = uint64(h.SegSize)
+	}
+	r.segs = []walSegment{{segno: h.PageAddr / r.segSize, data: data}}
+	return r
+}
+
+// tail returns a reader over the last n segment files of r
+func (r *WALReader) tail(n int) *WALReader {
+	return &WALReader{seg: -1, segSize: r.segSize, segs: r.segs[max(len(r.segs)-n, 0):]}
+}
+
+// parseWALFileName splits a segment file name: 8 hex digits each of
+// timeline, log and segment number
+func parseWALFileName(name string) (tli uint32, log, seg uint64, ok bool) {
+	if len(name) != 24 {
+		return 0, 0, 0, false
+	}
+	t, err1 := strconv.ParseUint(name[0:8], 16, 32)
+	l, err2 := strconv.ParseUint(name[8:16], 16, 32)
+	s, err3 := strconv.ParseUint(name[16:24], 16, 32)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, 0, 0, false
+	}
+	return uint32(t), l, s, true
+}
+
+// readWALLongHeader reads the long page header at the start of a segment
+func readWALLongHeader(path string) *WALPageHeader {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	buf := make([]byte, LongHeaderSize)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return nil
+	}
+	h := parsePageHeader(buf)
+	if !isValidMagic(h.Magic) || h.Info&XLP_LONG_HEADER == 0 {
+		return nil
+	}
+	return h
+}
+
+// Header returns the long page header of the first segment read, nil
+// before the first record
+func (r *WALReader) Header() *WALPageHeader {
+	return r.header
+}
+
+// SegmentsRead returns how many segment files were loaded so far
+func (r *WALReader) SegmentsRead() int {
+	return r.loaded
+}
+
+// Next returns the next record, or io.EOF after the last segment
+func (r *WALReader) Next() (*WALRecord, error) {
+	for {
+		if r.data == nil {
+			if err := r.advance(); err != nil {
+				return nil, err
+			}
+		}
+		start := r.seg
+		rec, err := r.readRecord()
+		if err == nil {
+			return rec, nil
+		}
+		if Debug {
+			fmt.Fprintf(os.Stderr, "[WAL] %v, resuming at the next segment\n", err)
+		}
+		r.seg, r.data = start, nil
+	}
+}
+
+// advance loads the segment after the current one and positions at its
+// first record
+func (r *WALReader) advance() error {
+	for {
+		r.seg++
+		if r.seg >= len(r.segs) {
+			return io.EOF
+		}
+		if r.load(r.seg) == nil {
+			r.pos = r.segs[r.seg].segno * r.segSize
+			r.prev, r.sync = 0, true
+			return nil
+		}
+	}
+}
+
+// load reads segment i into r.data
+func (r *WALReader) load(i int) error {
+	s := r.segs[i]
+	data := s.data
+	if data == nil {
+		var err error
+		if data, err = os.ReadFile(s.path); err != nil {
+			return err
+		}
+	}
+	r.seg, r.data = i, data
+	r.loaded++
+	if r.header == nil {
+		if h := parsePageHeader(data); len(data) >= LongHeaderSize && isValidMagic(h.Magic) {
+			r.header = h
+		}
+	}
+	return nil
+}
+
+// page returns the WAL page holding lsn and its header, moving on to the
+// next segment file when lsn is in it
+func (r *WALReader) page(lsn uint64) ([]byte, *WALPageHeader, error) {
+	segno := lsn / r.segSize
+	if r.segs[r.seg].segno != segno {
+		next := r.seg + 1
+		if next >= len(r.segs) || r.segs[next].segno != segno || r.load(next) != nil {
+			return nil, nil, fmt.Errorf("WAL segment for %s missing", FormatLSN(lsn))
+		}
+	}
+	off := lsn % r.segSize / WALPageSize * WALPageSize
+	if off+WALPageSize > uint64(len(r.data)) {
+		return nil, nil, fmt.Errorf("WAL segment truncated before %s", FormatLSN(lsn))
+	}
+	p := r.data[off : off+WALPageSize]
+	h := parsePageHeader(p)
+	if !isValidMagic(h.Magic) || h.PageAddr != lsn-lsn%WALPageSize {
+		return nil, nil, fmt.Errorf("invalid WAL page header at %s", FormatLSN(lsn-lsn%WALPageSize))
+	}
+	return p, h, nil
+}
+
+// validRmgrID reports whether rmid is a built-in resource manager or in
+// the custom range PG 15 added (RmgrIdIsValid)
+func validRmgrID(rmid uint8) bool {
+	return rmid <= rmMaxBuiltinID || rmid >= rmMinCustomID
+}
+
+// walPageHeaderSize returns the size of a page's header
+func walPageHeaderSize(h *WALPageHeader) uint64 {
+	if h.Info&XLP_LONG_HEADER != 0 {
+		return LongHeaderSize
+	}
+	return ShortHeaderSize
+}
+
+// skipContinuation moves pos past the tail of a record that began before
+// the segment, following xlp_rem_len across pages
+func (r *WALReader) skipContinuation() error {
+	for {
+		_, h, err := r.page(r.pos)
+		if err != nil {
+			return err
+		}
+		hs := walPageHeaderSize(h)
+		if h.Info&XLP_FIRST_IS_CONTRECORD == 0 {
+			r.pos += hs
+			return nil
+		}
+		if end := hs + uint64(align8(int(h.RemLen))); end < WALPageSize {
+			r.pos += end
+			return nil
+		}
+		r.pos += WALPageSize
+	}
+}
+
+// readRecord reassembles the record at pos
+func (r *WALReader) readRecord() (*WALRecord, error) {
+	if r.sync {
+		if err := r.skipContinuation(); err != nil {
+			return nil, err
+		}
+		r.sync = false
+	}
+	if r.pos%WALPageSize == 0 {
+		_, h, err := r.page(r.pos)
+		if err != nil {
+			return nil, err
+		}
+		r.pos += walPageHeaderSize(h)
+	}
+
+	// xl_tot_len is always on the record's first page: records start
+	// MAXALIGNed and pages end on a MAXALIGN boundary
+	lsn := r.pos
+	p, first, err := r.page(lsn)
+	if err != nil {
+		return nil, err
+	}
+	off := int(lsn % WALPageSize)
+	totalLen := u32(p, off)
+	if totalLen < XLogRecordSize || totalLen > walMaxRecordSize {
+		return nil, fmt.Errorf("invalid record length %d at %s", totalLen, FormatLSN(lsn))
+	}
+	// A header on this page is checked before xl_tot_len is trusted
+	// (ValidXLogRecordHeader); either way the buffer grows with the pages
+	// read, so a corrupt length cannot allocate much
+	if off+XLogRecordSize <= WALPageSize {
+		if rmid := p[off+17]; !validRmgrID(rmid) {
+			return nil, fmt.Errorf("invalid resource manager ID %d at %s", rmid, FormatLSN(lsn))
+		}
+		if prev := u64(p, off+8); r.prev != 0 && prev != r.prev {
+			return nil, fmt.Errorf("record at %s has incorrect prev-link %s", FormatLSN(lsn), FormatLSN(prev))
+		}
+	}
+
+	buf := make([]byte, 0, min(int(totalLen), WALPageSize))
+	cur := lsn
+	for len(buf) < int(totalLen) {
+		p, h, err := r.page(cur)
+		if err != nil {
+			return nil, err
+		}
+		if cur%WALPageSize == 0 {
+			if rem := totalLen - uint32(len(buf)); h.Info&XLP_FIRST_IS_CONTRECORD == 0 || h.RemLen != rem {
+				return nil, fmt.Errorf("missing continuation of record %s at %s", FormatLSN(lsn), FormatLSN(cur))
+			}
+			cur += walPageHeaderSize(h)
+		}
+		off = int(cur % WALPageSize)
+		n := min(int(totalLen)-len(buf), WALPageSize-off)
+		buf = append(buf, p[off:off+n]...)
+		cur += uint64(n)
+	}
+
+	rec, err := parseXLogRecord(buf, lsn, walVersion(first.Magic))
+	if err != nil {
+		return nil, fmt.Errorf("invalid record at %s: %w", FormatLSN(lsn), err)
+	}
+	if r.prev != 0 && rec.PrevLSN != r.prev {
+		return nil, fmt.Errorf("record at %s has incorrect prev-link %s", FormatLSN(lsn), FormatLSN(rec.PrevLSN))
+	}
+	r.prev = lsn
+	r.pos = (cur + 7) &^ 7
+	rec.end = r.pos
+
+	// The rest of the segment after XLOG_SWITCH is unused
+	if rec.ResourceMgr == RM_XLOG_ID && rec.Info&0xF0 == 0x40 && r.pos%r.segSize != 0 {
+		r.pos += r.segSize - r.pos%r.segSize
+	}
+	return rec, nil
+}
@@ -0,0 +1,252 @@
+package pgdump
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Record body block ids (xlogrecord.h)
+const (
+	xlrMaxBlockID       = 32
+	xlrBlockIDDataShort = 255
+	xlrBlockIDDataLong  = 254
+	xlrBlockIDOrigin    = 253
+	xlrBlockIDToplevel  = 252 // PG 14+
+)
+
+// XLogRecordBlockHeader fork_flags
+const (
+	bkpBlockForkMask = 0x0F
+	bkpBlockHasImage = 0x10
+	bkpBlockHasData  = 0x20
+	bkpBlockWillInit = 0x40
+	bkpBlockSameRel  = 0x80
+)
+
+// bimg_info flags. PG 15 moved BKPIMAGE_APPLY and split compression by
+// method
+const (
+	bkpImageHasHole = 0x01
+
+	bkpImageIsCompressed14 = 0x02
+	bkpImageApply14        = 0x04
+
+	bkpImageApply        = 0x02
+	bkpImageCompressPGLZ = 0x04
+	bkpImageCompressLZ4  = 0x08
+	bkpImageCompressZSTD = 0x10
+)
+
+// Image compression methods
+const (
+	CompressionPGLZ = "pglz"
+	CompressionLZ4  = "lz4"
+	CompressionZSTD = "zstd"
+)
+
+// decodeRecordBody decodes what follows the XLogRecord header: the block
+// headers, replication origin, toplevel xid and main data header, then
+// each block's image and data in block order, then the main data
+// (DecodeXLogRecord in xlogreader.c)
+func decodeRecordBody(rec *WALRecord, body []byte, version int) error {
+	pos := 0
+	need := func(n int) error {
+		if pos+n > len(body) {
+			return fmt.Errorf("record body truncated at offset %d", pos)
+		}
+		return nil
+	}
+
+	var rel *RelFileNode
+	dataTotal, mainLen := 0, 0
+	lastID := -1
+	for len(body)-pos > dataTotal {
+		id := body[pos]
+		pos++
+
+		switch {
+		case id == xlrBlockIDDataShort:
+			if err := need(1); err != nil {
+				return err
+			}
+			mainLen = int(body[pos])
+			pos++
+		case id == xlrBlockIDDataLong:
+			if err := need(4); err != nil {
+				return err
+			}
+			mainLen = int(binary.LittleEndian.Uint32(body[pos:]))
+			pos += 4
+		case id == xlrBlockIDOrigin:
+			if err := need(2); err != nil {
+				return err
+			}
+			rec.Origin = binary.LittleEndian.Uint16(body[pos:])
+			pos += 2
+			continue
+		case id == xlrBlockIDToplevel:
+			if err := need(4); err != nil {
+				return err
+			}
+			rec.ToplevelXID = binary.LittleEndian.Uint32(body[pos:])
+			pos += 4
+			continue
+		case id <= xlrMaxBlockID:
+			if int(id) <= lastID {
+				return fmt.Errorf("out-of-order block_id %d", id)
+			}
+			lastID = int(id)
+			blk, err := decodeBlockHeader(body, &pos, id, rel, version)
+			if err != nil {
+				return err
+			}
+			rel = blk.RelFileNode
+			dataTotal += blk.DataLen
+			if blk.Image != nil {
+				dataTotal += int(blk.Image.Length)
+			}
+			rec.Blocks = append(rec.Blocks, blk)
+			continue
+		default:
+			return fmt.Errorf("invalid block_id %d", id)
+		}
+		break // the main data header comes last
+	}
+
+	// Block images and data, then the main data
+	if pos+dataTotal+mainLen != len(body) {
+		return fmt.Errorf("record body has %d bytes, headers describe %d", len(body), pos+dataTotal+mainLen)
+	}
+	for i := range rec.Blocks {
+		blk := &rec.Blocks[i]
+		if blk.Image != nil {
+			blk.Image.Data = body[pos : pos+int(blk.Image.Length)]
+			pos += int(blk.Image.Length)
+		}
+		blk.Data = body[pos : pos+blk.DataLen]
+		pos += blk.DataLen
+	}
+	rec.MainData = body[pos:]
+	rec.MainDataLen = mainLen
+	return nil
+}
+
+// decodeBlockHeader decodes one XLogRecordBlockHeader with its image
+// header, relation and block number. rel is the relation of the previous
+// block, which BKPBLOCK_SAME_REL refers to
+func decodeBlockHeader(body []byte, pos *int, id uint8, rel *RelFileNode, version int) (WALBlockRef, error) {
+	p := *pos
+	if p+3 > len(body) {
+		return WALBlockRef{}, fmt.Errorf("block %d header truncated", id)
+	}
+	flags := body[p]
+	blk := WALBlockRef{
+		ID:       id,
+		ForkNum:  flags & bkpBlockForkMask,
+		Flags:    uint16(flags),
+		WillInit: flags&bkpBlockWillInit != 0,
+		DataLen:  int(binary.LittleEndian.Uint16(body[p+1:])),
+	}
+	p += 3
+	if hasData := flags&bkpBlockHasData != 0; hasData != (blk.DataLen > 0) {
+		return blk, fmt.Errorf("block %d: BKPBLOCK_HAS_DATA does not match data length %d", id, blk.DataLen)
+	}
+
+	if flags&bkpBlockHasImage != 0 {
+		if p+5 > len(body) {
+			return blk, fmt.Errorf("block %d image header truncated", id)
+		}
+		img := &WALBlockImage{
+			Length:     binary.LittleEndian.Uint16(body[p:]),
+			HoleOffset: binary.LittleEndian.Uint16(body[p+2:]),
+			Info:       body[p+4],
+		}
+		p += 5
+		hasHole := img.Info&bkpImageHasHole != 0
+		if version >= 15 {
+			img.Apply = img.Info&bkpImageApply != 0
+			switch {
+			case img.Info&bkpImageCompressPGLZ != 0:
+				img.Compression = CompressionPGLZ
+			case img.Info&bkpImageCompressLZ4 != 0:
+				img.Compression = CompressionLZ4
+			case img.Info&bkpImageCompressZSTD != 0:
+				img.Compression = CompressionZSTD
+			}
+		} else {
+			img.Apply = img.Info&bkpImageApply14 != 0
+			if img.Info&bkpImageIsCompressed14 != 0 {
+				img.Compression = CompressionPGLZ
+			}
+		}
+
+		// A compressed image records its hole length; otherwise the hole
+		// is what the image lacks of a full page
+		switch {
+		case hasHole && img.Compression != "":
+			if p+2 > len(body) {
+				return blk, fmt.Errorf("block %d compression header truncated", id)
+			}
+			img.HoleLength = binary.LittleEndian.Uint16(body[p:])
+			p += 2
+		case hasHole:
+			img.HoleLength = PageSize - img.Length
+		}
+
+		switch {
+		case hasHole && (img.HoleOffset == 0 || img.HoleLength == 0 || img.Length == PageSize):
+			return blk, fmt.Errorf("block %d: invalid image hole at %d length %d", id, img.HoleOffset, img.HoleLength)
+		case !hasHole && img.HoleOffset != 0:
+			return blk, fmt.Errorf("block %d: image hole offset %d without a hole", id, img.HoleOffset)
+		case img.Compression != "" && img.Length == PageSize:
+			return blk, fmt.Errorf("block %d: compressed image of a full page", id)
+		case !hasHole && img.Compression == "" && img.Length != PageSize:
+			return blk, fmt.Errorf("block %d: image length %d without a hole", id, img.Length)
+		}
+		blk.Image = img
+	}
+
+	if flags&bkpBlockSameRel == 0 {
+		if p+12 > len(body) {
+			return blk, fmt.Errorf("block %d relation truncated", id)
+		}
+		rel = &RelFileNode{
+			SpcOID: binary.LittleEndian.Uint32(body[p:]),
+			DbOID:  binary.LittleEndian.Uint32(body[p+4:]),
+			RelOID: binary.LittleEndian.Uint32(body[p+8:]),
+		}
+		p += 12
+	} else if rel == nil {
+		return blk, fmt.Errorf("block %d: BKPBLOCK_SAME_REL without a previous relation", id)
+	}
+	blk.RelFileNode = rel
+
+	if p+4 > len(body) {
+		return blk, fmt.Errorf("block %d number truncated", id)
+	}
+	blk.BlockNum = binary.LittleEndian.Uint32(body[p:])
+	*pos = p + 4
+	return blk, nil
+}
+
+// Page returns the 8 KB page an image stores, decompressed and with the
+// hole zero-filled
+func (img *WALBlockImage) Page() ([]byte, error) {
+	data := img.Data
+	if img.Compression != "" {
+		if int(img.HoleLength) >= PageSize {
+			return nil, fmt.Errorf("invalid image hole length %d", img.HoleLength)
+		}
+		var err error
+		if data, err = decompressImage(img.Compression, data, PageSize-int(img.HoleLength)); err != nil {
+			return nil, err
+		}
+	}
+	if len(data)+int(img.HoleLength) != PageSize || int(img.HoleOffset) > len(data) {
+		return nil, fmt.Errorf("image of %d bytes with a %d-byte hole is not a page", len(data), img.HoleLength)
+	}
+	page := make([]byte, PageSize)
+	copy(page, data[:img.HoleOffset])
+	copy(page[int(img.HoleOffset)+int(img.HoleLength):], data[img.HoleOffset:])
+	return page, nil
+}
@@ -0,0 +1,94 @@
+package pgdump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecodeXLogRecord(t *testing.T) {
+	le16 := func(v uint16) []byte { return binary.LittleEndian.AppendUint16(nil, v) }
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	cat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	// Block 0: image with a 1000-byte hole at 200, plus 6 bytes of data.
+	// Block 1: same relation, data only. Then origin, toplevel xid and
+	// 3 bytes of main data
+	image := bytes.Repeat([]byte{0xAB}, PageSize-1000)
+	body := cat(
+		[]byte{0, bkpBlockHasImage | bkpBlockHasData | bkpBlockWillInit}, le16(6),
+		le16(uint16(len(image))), le16(200), []byte{bkpImageHasHole | bkpImageApply},
+		le32(1663), le32(16384), le32(16400), le32(7),
+		[]byte{1, bkpBlockHasData | bkpBlockSameRel}, le16(2), le32(9),
+		[]byte{xlrBlockIDOrigin}, le16(4),
+		[]byte{xlrBlockIDToplevel}, le32(600),
+		[]byte{xlrBlockIDDataShort, 3},
+		image, []byte("block0"), []byte("b1"), []byte("xyz"),
+	)
+	rec := cat(make([]byte, XLogRecordSize), body)
+	binary.LittleEndian.PutUint32(rec[0:], uint32(len(rec)))
+	binary.LittleEndian.PutUint32(rec[4:], 601)
+	rec[16], rec[17] = XLOG_HEAP_INSERT, RM_HEAP_ID
+	setRecordCRC(rec)
+
+	r, err := parseXLogRecord(rec, 0x1000028, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Origin != 4 || r.ToplevelXID != 600 || string(r.MainData) != "xyz" {
+		t.Errorf("record = %+v", r)
+	}
+	if len(r.Blocks) != 2 {
+		t.Fatalf("got %d blocks, want 2", len(r.Blocks))
+	}
+	b0, b1 := r.Blocks[0], r.Blocks[1]
+	if b0.RelFileNode == nil || b0.RelFileNode.RelOID != 16400 || b0.BlockNum != 7 || !b0.WillInit || string(b0.Data) != "block0" {
+		t.Errorf("block 0 = %+v", b0)
+	}
+	if img := b0.Image; img == nil || img.HoleOffset != 200 || img.HoleLength != 1000 || !img.Apply || img.Compression != "" || !bytes.Equal(img.Data, image) {
+		t.Errorf("block 0 image = %+v", b0.Image)
+	}
+	if b1.RelFileNode != b0.RelFileNode || b1.BlockNum != 9 || string(b1.Data) != "b1" {
+		t.Errorf("block 1 = %+v", b1)
+	}
+
+	rec[len(rec)-1] ^= 0xFF
+	if _, err := parseXLogRecord(rec, 0, 16); err == nil {
+		t.Error("CRC accepted a modified record")
+	}
+
+	// bimg_info before PG 15: 0x02 is compression, not BKPIMAGE_APPLY
+	compressed := cat(
+		[]byte{0, bkpBlockHasImage}, le16(0),
+		le16(100), le16(200), []byte{bkpImageHasHole | bkpImageIsCompressed14}, le16(1000),
+		le32(1663), le32(16384), le32(16400), le32(0),
+		make([]byte, 100),
+	)
+	for _, tt := range []struct {
+		version     int
+		compression string
+		apply       bool
+		ok          bool
+	}{
+		{14, CompressionPGLZ, false, true},
+		{16, "", true, false}, // no compression header: the lengths no longer add up
+	} {
+		got := &WALRecord{}
+		err := decodeRecordBody(got, compressed, tt.version)
+		if (err == nil) != tt.ok {
+			t.Errorf("PG %d: err = %v", tt.version, err)
+			continue
+		}
+		if err == nil {
+			img := got.Blocks[0].Image
+			if img.Compression != tt.compression || img.Apply != tt.apply || img.HoleLength != 1000 || len(img.Data) != 100 {
+				t.Errorf("PG %d: image = %+v", tt.version, img)
+			}
+		}
+	}
+
+	// A first block cannot refer to a previous relation
+	if err := decodeRecordBody(&WALRecord{}, cat([]byte{0, bkpBlockSameRel}, le16(0), le32(1)), 16); err == nil {
+		t.Error("BKPBLOCK_SAME_REL on the first block accepted")
+	}
+}
@@ -0,0 +1,338 @@
+package pgdump
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// XactStatus is a transaction's state in pg_xact (2 bits per transaction)
+type XactStatus uint8
+
+const (
+	XactInProgress XactStatus = iota
+	XactCommitted
+	XactAborted
+	XactSubCommitted
+)
+
+func (s XactStatus) String() string {
+	switch s {
+	case XactInProgress:
+		return "in_progress"
+	case XactCommitted:
+		return "committed"
+	case XactAborted:
+		return "aborted"
+	case XactSubCommitted:
+		return "sub_committed"
+	}
+	return fmt.Sprintf("unknown(%d)", s)
+}
+
+// Special transaction IDs (access/transam.h)
+const (
+	InvalidXID     = 0
+	BootstrapXID   = 1
+	FrozenXID      = 2
+	FirstNormalXID = 3
+)
+
+// VisibilityMode selects how tuple visibility is decided
+type VisibilityMode int
+
+const (
+	VisibilityAuto     VisibilityMode = iota // pg_xact when readable, else hint bits
+	VisibilityHintBits                       // infomask hint bits only
+	VisibilityCLOG                           // commit status from pg_xact
+)
+
+// ParseVisibilityMode parses "auto", "hints" or "clog"
+func ParseVisibilityMode(s string) (VisibilityMode, error) {
+	switch s {
+	case "", "auto":
+		return VisibilityAuto, nil
+	case "hints":
+		return VisibilityHintBits, nil
+	case "clog":
+		return VisibilityCLOG, nil
+	}
+	return VisibilityAuto, fmt.Errorf("unknown visibility mode %q (want auto, hints or clog)", s)
+}
+
+// SLRU geometry for the default 8 KB block size
+const (
+	slruPagesPerSegment = 32
+	xactsPerSegment     = PageSize * 4 * slruPagesPerSegment // 2 bits each
+	subtransPerSegment  = PageSize / 4 * slruPagesPerSegment // parent xid each
+	multiPerSegment     = PageSize / 4 * slruPagesPerSegment // member offset each
+
+	// pg_multixact/members stores groups of 4 flag bytes followed by 4 xids
+	memberGroupSize    = 20
+	membersPerPage     = PageSize / memberGroupSize * 4
+	multiStatusNoKeyUp = 4 // MultiXactStatusNoKeyUpdate; Update = 5
+
+	// pg_commit_ts stores a TimestampTz and a RepOriginId per xid
+	commitTsEntrySize = 10
+	commitTsPerPage   = PageSize / commitTsEntrySize
+)
+
+// CommitLog reads transaction status from pg_xact, pg_subtrans and
+// pg_multixact, loading SLRU segments on first use
+type CommitLog struct {
+	read     RemoteReader
+	segments map[string][]byte // "<dir>/<segment>" -> contents (nil = unreadable)
+
+	nextXID         uint32
+	nextMulti       uint32
+	nextMultiOffset uint32
+}
+
+// NewCommitLog returns a CommitLog reading files relative to a data
+// directory, e.g. "pg_xact/0000"
+func NewCommitLog(read RemoteReader) *CommitLog {
+	c := &CommitLog{read: read, segments: make(map[string][]byte)}
+	if data, err := read("global/pg_control"); err == nil {
+		if cf, err := ParseControlFile(data); err == nil {
+			c.nextXID, c.nextMulti, c.nextMultiOffset = cf.NextXID, cf.NextMulti, cf.NextMultiOffset
+		}
+	}
+	return c
+}
+
+// OpenCommitLog returns a CommitLog for a local data directory
+func OpenCommitLog(dataDir string) *CommitLog {
+	return NewCommitLog(func(path string) ([]byte, error) {
+		return os.ReadFile(filepath.Join(dataDir, path))
+	})
+}
+
+// Readable reports whether pg_xact holds the most recent transactions
+func (c *CommitLog) Readable() bool {
+	latest := uint32(FirstNormalXID)
+	if c.nextXID > FirstNormalXID {
+		latest = c.nextXID - 1
+	}
+	return c.segment("pg_xact", latest/xactsPerSegment) != nil
+}
+
+// segment returns an SLRU segment file, cached after the first read
+func (c *CommitLog) segment(dir string, segno uint32) []byte {
+	key := fmt.Sprintf("%s/%04X", dir, segno)
+	if data, ok := c.segments[key]; ok {
+		return data
+	}
+	data, err := c.read(key)
+	if err != nil {
+		data = nil
+	}
+	c.segments[key] = data
+	return data
+}
+
+// slot returns size bytes at entry index of an SLRU with perSegment
+// entries per segment file
+func (c *CommitLog) slot(dir string, index, perSegment uint32, size int) []byte {
+	seg := c.segment(dir, index/perSegment)
+	off := int(index%perSegment) * size
+	if off+size > len(seg) {
+		return nil
+	}
+	return seg[off : off+size]
+}
+
+// Status returns the commit status of xid. ok is false when its pg_xact
+// page is missing. Subcommitted transactions take their parent's status.
+// A nil CommitLog knows only the special xids
+func (c *CommitLog) Status(xid uint32) (status XactStatus, ok bool) {
+	for depth := 0; depth < 64; depth++ {
+		switch xid {
+		case BootstrapXID, FrozenXID:
+			return XactCommitted, true
+		case InvalidXID:
+			return XactAborted, true
+		}
+		if c == nil {
+			return XactInProgress, false
+		}
+		seg := c.segment("pg_xact", xid/xactsPerSegment)
+		off := int(xid%xactsPerSegment) / 4
+		if off >= len(seg) {
+			return XactInProgress, false
+		}
+		status = XactStatus(seg[off]>>((xid%4)*2)) & 0x03
+		if status != XactSubCommitted {
+			return status, true
+		}
+		// pg_subtrans only survives until restart; without a parent the
+		// top-level transaction never committed
+		parent := c.slot("pg_subtrans", xid, subtransPerSegment, 4)
+		if parent == nil || u32(parent, 0) == InvalidXID || u32(parent, 0) == xid {
+			return XactInProgress, true
+		}
+		xid = u32(parent, 0)
+	}
+	return XactInProgress, false
+}
+
+// CommitTime returns when xid committed, from pg_commit_ts. ok is false
+// unless track_commit_timestamp was on at the time
+func (c *CommitLog) CommitTime(xid uint32) (t time.Time, ok bool) {
+	if c == nil || xid < FirstNormalXID {
+		return t, false
+	}
+	page := xid / commitTsPerPage
+	seg := c.segment("pg_commit_ts", page/slruPagesPerSegment)
+	off := int(page%slruPagesPerSegment)*PageSize + int(xid%commitTsPerPage)*commitTsEntrySize
+	if off+8 > len(seg) || i64(seg, off) == 0 {
+		return t, false
+	}
+	return pgEpoch.Add(time.Duration(i64(seg, off)) * time.Microsecond), true
+}
+
+// MultiMember is one transaction of a MultiXact
+type MultiMember struct {
+	XID    uint32 `json:"xid"`
+	Status uint8  `json:"status"` // MultiXactStatus: 0-3 locks, 4-5 updates
+}
+
+// MultiMembers lists the members of a MultiXact from pg_multixact
+func (c *CommitLog) MultiMembers(multi uint32) []MultiMember {
+	if c == nil {
+		return nil
+	}
+	start := c.slot("pg_multixact/offsets", multi, multiPerSegment, 4)
+	if start == nil || u32(start, 0) == 0 {
+		return nil
+	}
+	first := u32(start, 0)
+
+	// The member count is the distance to the next MultiXact's offset
+	last := c.nextMultiOffset
+	if next := multi + 1; next != c.nextMulti {
+		if next == 0 {
+			next = 1 // MultiXactIds wrap around to FirstMultiXactId
+		}
+		if end := c.slot("pg_multixact/offsets", next, multiPerSegment, 4); end != nil && u32(end, 0) != 0 {
+			last = u32(end, 0)
+		}
+	}
+
+	var members []MultiMember
+	for off := first; off != last && len(members) < 1024; off++ {
+		page := off / membersPerPage
+		group := int(off%membersPerPage) / 4 * memberGroupSize
+		seg := c.segment("pg_multixact/members", page/slruPagesPerSegment)
+		base := int(page%slruPagesPerSegment)*PageSize + group
+		if base+memberGroupSize > len(seg) {
+			break
+		}
+		members = append(members, MultiMember{
+			XID:    u32(seg, base+4+int(off%4)*4),
+			Status: seg[base+int(off%4)],
+		})
+	}
+	return members
+}
+
+// MultiUpdater returns the member of a MultiXact that updated or deleted
+// the tuple; ok is false when all members only locked it
+func (c *CommitLog) MultiUpdater(multi uint32) (xid uint32, ok bool) {
+	for _, m := range c.MultiMembers(multi) {
+		if m.Status >= multiStatusNoKeyUp {
+			return m.XID, true
+		}
+	}
+	return 0, false
+}
+
+// IsVisible reports whether a tuple is visible to a new snapshot: its
+// inserter committed and no committed transaction deleted or updated it.
+// Hint bits are trusted when set, pg_xact decides otherwise; a nil
+// CommitLog goes by hint bits alone
+func (c *CommitLog) IsVisible(h *HeapTupleHeader) bool {
+	return c.XminCommitted(h) && !c.XmaxCommitted(h)
+}
+
+// XminCommitted reports whether the inserting transaction committed
+// (frozen tuples always have)
+func (c *CommitLog) XminCommitted(h *HeapTupleHeader) bool {
+	switch {
+	case h.Infomask&HeapXminCommitted != 0:
+		return true
+	case h.Infomask&HeapXminInvalid != 0:
+		return false
+	}
+	status, _ := c.Status(h.Xmin)
+	return status == XactCommitted
+}
+
+// XmaxCommitted reports whether a committed transaction deleted or
+// updated the tuple. Row locks, including lock-only MultiXacts, leave
+// it live
+func (c *CommitLog) XmaxCommitted(h *HeapTupleHeader) bool {
+	_, ok := c.deleter(h)
+	return ok
+}
+
+// VisibleAt reports whether a tuple was visible to a snapshot taken just
+// after xid committed: its inserter committed no later than xid and no
+// transaction up to xid deleted it. pg_xact keeps no commit order, so
+// transactions are ordered by xid. Freezing keeps the raw xmin (9.4+),
+// so frozen tuples are ordered by it too
+func (c *CommitLog) VisibleAt(h *HeapTupleHeader, xid uint32) bool {
+	if !xidPrecedesOrEquals(h.Xmin, xid) || !c.XminCommitted(h) {
+		return false
+	}
+	deleter, ok := c.deleter(h)
+	return !ok || !xidPrecedesOrEquals(deleter, xid)
+}
+
+// deleter returns the committed transaction that deleted or updated the
+// tuple, resolving MultiXact xmax to its updating member
+func (c *CommitLog) deleter(h *HeapTupleHeader) (xid uint32, ok bool) {
+	if h.Infomask&HeapXmaxInvalid != 0 || h.Xmax == InvalidXID || xmaxLockOnly(h.Infomask) {
+		return 0, false
+	}
+	xid = h.Xmax
+	if h.Infomask&HeapXmaxIsMulti != 0 {
+		if xid, ok = c.MultiUpdater(h.Xmax); !ok {
+			return 0, false
+		}
+	} else if h.Infomask&HeapXmaxCommitted != 0 {
+		return xid, true
+	}
+	status, _ := c.Status(xid)
+	return xid, status == XactCommitted
+}
+
+// xidPrecedesOrEquals compares xids modulo 2^32 like
+// TransactionIdPrecedesOrEquals; special xids precede all normal ones
+func xidPrecedesOrEquals(a, b uint32) bool {
+	if a < FirstNormalXID || b < FirstNormalXID {
+		return a <= b
+	}
+	return int32(a-b) <= 0
+}
+
+// xmaxLockOnly mirrors HEAP_XMAX_IS_LOCKED_ONLY
+func xmaxLockOnly(infomask uint16) bool {
+	return infomask&HeapXmaxLockOnly != 0 ||
+		infomask&(HeapXmaxIsMulti|heapLockMask) == HeapXmaxExclLock
+}
+
+// commitLogFor returns the CommitLog to use for mode, or nil when
+// visibility should come from hint bits
+func commitLogFor(c *CommitLog, mode VisibilityMode) *CommitLog {
+	switch mode {
+	case VisibilityHintBits:
+		return nil
+	case VisibilityCLOG:
+		return c
+	}
+	if c.Readable() {
+		return c
+	}
+	return nil
+}
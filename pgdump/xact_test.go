@@ -0,0 +1,281 @@
+package pgdump
+
+import (
+	"encoding/binary"
+	"os"
+	"slices"
+	"testing"
+)
+
+// testCommitLog builds a CommitLog over in-memory SLRU segments
+func testCommitLog(files map[string][]byte) *CommitLog {
+	return NewCommitLog(func(path string) ([]byte, error) {
+		if data, ok := files[path]; ok {
+			return data, nil
+		}
+		return nil, os.ErrNotExist
+	})
+}
+
+// setXactStatus writes a 2-bit status into a pg_xact segment
+func setXactStatus(seg []byte, xid uint32, status XactStatus) {
+	seg[xid%xactsPerSegment/4] |= byte(status) << ((xid % 4) * 2)
+}
+
+func TestCommitLogStatus(t *testing.T) {
+	xact := make([]byte, PageSize)
+	setXactStatus(xact, 100, XactCommitted)
+	setXactStatus(xact, 101, XactAborted)
+	setXactStatus(xact, 103, XactSubCommitted) // child of 100
+	setXactStatus(xact, 104, XactSubCommitted) // child of 102 (in progress)
+	setXactStatus(xact, 105, XactSubCommitted) // parent lost with pg_subtrans
+
+	subtrans := make([]byte, PageSize)
+	binary.LittleEndian.PutUint32(subtrans[103*4:], 100)
+	binary.LittleEndian.PutUint32(subtrans[104*4:], 102)
+
+	c := testCommitLog(map[string][]byte{"pg_xact/0000": xact, "pg_subtrans/0000": subtrans})
+	tests := []struct {
+		xid    uint32
+		status XactStatus
+		ok     bool
+	}{
+		{FrozenXID, XactCommitted, true},
+		{BootstrapXID, XactCommitted, true},
+		{100, XactCommitted, true},
+		{101, XactAborted, true},
+		{102, XactInProgress, true},
+		{103, XactCommitted, true},
+		{104, XactInProgress, true},
+		{105, XactInProgress, true},
+		{xactsPerSegment + 5, XactInProgress, false}, // segment 0001 missing
+	}
+	for _, tt := range tests {
+		status, ok := c.Status(tt.xid)
+		if status != tt.status || ok != tt.ok {
+			t.Errorf("Status(%d) = %v, %v; want %v, %v", tt.xid, status, ok, tt.status, tt.ok)
+		}
+	}
+	if !c.Readable() {
+		t.Error("Readable() = false with pg_xact/0000 present")
+	}
+	if testCommitLog(nil).Readable() {
+		t.Error("Readable() = true without pg_xact")
+	}
+}
+
+func TestCommitLogMultiXact(t *testing.T) {
+	// Multi 1 = {200 key share, 201 update}, multi 2 = {202 share, 203 share}
+	offsets := make([]byte, PageSize)
+	binary.LittleEndian.PutUint32(offsets[4:], 1)
+	binary.LittleEndian.PutUint32(offsets[8:], 3)
+	members := make([]byte, PageSize)
+	for off, m := range []MultiMember{{200, 0}, {201, 5}, {202, 1}, {203, 1}} {
+		off++ // offset 0 is never used
+		base := off / 4 * memberGroupSize
+		members[base+off%4] = m.Status
+		binary.LittleEndian.PutUint32(members[base+4+off%4*4:], m.XID)
+	}
+	c := testCommitLog(map[string][]byte{"pg_multixact/offsets/0000": offsets, "pg_multixact/members/0000": members})
+	c.nextMulti, c.nextMultiOffset = 3, 5
+
+	if got := c.MultiMembers(1); len(got) != 2 || got[1] != (MultiMember{201, 5}) {
+		t.Errorf("MultiMembers(1) = %v", got)
+	}
+	if xid, ok := c.MultiUpdater(1); !ok || xid != 201 {
+		t.Errorf("MultiUpdater(1) = %d, %v; want 201", xid, ok)
+	}
+	if got := c.MultiMembers(2); len(got) != 2 || got[0].XID != 202 {
+		t.Errorf("MultiMembers(2) = %v", got)
+	}
+	if _, ok := c.MultiUpdater(2); ok {
+		t.Error("MultiUpdater(2) found an updater among lockers")
+	}
+}
+
+func TestCommitLogVisibility(t *testing.T) {
+	xact := make([]byte, PageSize)
+	setXactStatus(xact, 100, XactCommitted)
+	setXactStatus(xact, 101, XactAborted)
+	setXactStatus(xact, 201, XactCommitted)
+	offsets := make([]byte, PageSize)
+	binary.LittleEndian.PutUint32(offsets[4:], 1)
+	members := make([]byte, PageSize)
+	members[1] = 5 // multi 1: xid 201 updated
+	binary.LittleEndian.PutUint32(members[8:], 201)
+	c := testCommitLog(map[string][]byte{
+		"pg_xact/0000": xact, "pg_multixact/offsets/0000": offsets, "pg_multixact/members/0000": members,
+	})
+	c.nextMulti, c.nextMultiOffset = 2, 2
+
+	tests := []struct {
+		name       string
+		xmin, xmax uint32
+		infomask   uint16
+		visible    bool
+		hintBits   bool
+	}{
+		{"committed without hint bits", 100, 0, HeapXmaxInvalid, true, false},
+		{"aborted insert", 101, 0, HeapXmaxInvalid, false, false},
+		{"in-progress insert", 102, 0, HeapXmaxInvalid, false, false},
+		{"xmin hint wins", 102, 0, HeapXminCommitted | HeapXmaxInvalid, true, true},
+		{"frozen", 5, 0, HeapXminFrozen | HeapXmaxInvalid, true, true},
+		{"frozen xid", FrozenXID, 0, HeapXmaxInvalid, true, false},
+		{"deleted without hint bits", 100, 100, HeapXminCommitted, false, true},
+		{"aborted delete", 100, 101, HeapXminCommitted, true, true},
+		{"in-progress delete", 100, 102, HeapXminCommitted, true, true},
+		{"row lock", 100, 100, HeapXminCommitted | HeapXmaxExclLock, true, true},
+		{"lock-only multi", 100, 1, HeapXminCommitted | HeapXmaxIsMulti | HeapXmaxLockOnly, true, true},
+		{"multi updater committed", 100, 1, HeapXminCommitted | HeapXmaxIsMulti, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tuple := ParseHeapTuple(makeHeapTuple(tt.xmin, tt.xmax, tt.infomask, 0, nil, nil))
+			if got := c.IsVisible(tuple.Header); got != tt.visible {
+				t.Errorf("CommitLog.IsVisible() = %v, want %v", got, tt.visible)
+			}
+			if got := tuple.IsVisible(); got != tt.hintBits {
+				t.Errorf("hint-bit IsVisible() = %v, want %v", got, tt.hintBits)
+			}
+		})
+	}
+}
+
+func TestDecoderReadRowsCommitLog(t *testing.T) {
+	xact := make([]byte, PageSize)
+	setXactStatus(xact, 100, XactCommitted)
+	setXactStatus(xact, 101, XactCommitted)
+	c := testCommitLog(map[string][]byte{"pg_xact/0000": xact})
+
+	columns := []Column{{Name: "id", TypID: OidInt4, Len: 4, Align: 'i', Num: 1}}
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	page := makeHeapPage(
+		makeHeapTuple(100, 0, HeapXmaxInvalid, 1, nil, le32(1)),                   // fresh insert, no hints yet
+		makeHeapTuple(100, 101, HeapXminCommitted, 1, nil, le32(2)),               // deleted, xmax hint unset
+		makeHeapTuple(100, 0, HeapXminCommitted|HeapXmaxInvalid, 1, nil, le32(3)), // hinted live row
+	)
+
+	if rows := ReadRows(page, columns, true); len(rows) != 2 || rows[0]["id"] != int32(2) {
+		t.Errorf("hint bits: rows = %v, want ids 2 and 3", rows)
+	}
+	dec := &Decoder{Xact: commitLogFor(c, VisibilityAuto)}
+	if rows := dec.ReadRows(page, columns, true); len(rows) != 2 || rows[0]["id"] != int32(1) || rows[1]["id"] != int32(3) {
+		t.Errorf("clog: rows = %v, want ids 1 and 3", rows)
+	}
+	if commitLogFor(c, VisibilityHintBits) != nil || commitLogFor(testCommitLog(nil), VisibilityAuto) != nil {
+		t.Error("commitLogFor should fall back to hint bits")
+	}
+}
+
+func TestDumpDataDirCommitLogCatalogs(t *testing.T) {
+	le16 := func(v uint16) []byte { return binary.LittleEndian.AppendUint16(nil, v) }
+	le32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	xact := make([]byte, PageSize)
+	setXactStatus(xact, 100, XactCommitted)
+
+	// Everything was written by 100, which committed before any reader
+	// set hint bits
+	value := "a value stored out of line"
+	chunk := append(append(le32(7), le32(0)...), makeVarlena([]byte(value))...)
+	dataDir := t.TempDir()
+	writeFiles(t, dataDir, map[string][]byte{
+		"pg_xact/0000": xact,
+		"global/1262": makeHeapPage(catalogTuple(100, 0, 0, schemaPGDatabase, map[string][]byte{
+			"oid": le32(16384), "datname": nameDatum("shop"),
+		})),
+		"base/16384/1259": makeHeapPage(
+			catalogTuple(100, 0, 0, schemaPGClass, map[string][]byte{
+				"oid": le32(16385), "relname": nameDatum("docs"), "relfilenode": le32(16385),
+				"reltoastrelid": le32(16388), "relkind": {'r'},
+			}),
+			catalogTuple(100, 0, 0, schemaPGClass, map[string][]byte{
+				"oid": le32(16388), "relname": nameDatum("pg_toast_16385"), "relfilenode": le32(16388),
+				"relkind": {'t'},
+			}),
+		),
+		"base/16384/1249": makeHeapPage(catalogTuple(100, 0, 0, schemaPGAttrV15, map[string][]byte{
+			"attrelid": le32(16385), "attname": nameDatum("doc"), "atttypid": le32(OidText),
+			"attlen": le16(0xFFFF), "attnum": le16(1), "attalign": {'i'},
+		})),
+		"base/16384/16385": makeHeapPage(makeHeapTuple(100, 0, 0, 1, nil,
+			makeTOASTPointer(uint32(len(value)+4), uint32(len(value)), 7, 16388))),
+		"base/16384/16388": makeHeapPage(makeHeapTuple(100, 0, 0, 3, nil, chunk)),
+	})
+
+	for _, tt := range []struct {
+		mode VisibilityMode
+		want []string
+	}{
+		{VisibilityHintBits, nil},
+		{VisibilityCLOG, []string{value}},
+	} {
+		result, err := DumpDataDir(dataDir, &Options{SkipSystemTables: true, Visibility: tt.mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, db := range result.Databases {
+			for _, tbl := range db.Tables {
+				for _, row := range tbl.Rows {
+					doc, _ := row["doc"].(string)
+					got = append(got, doc)
+				}
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("visibility %d: docs = %q, want %q", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestCommitLogVisibleAt(t *testing.T) {
+	xact := make([]byte, PageSize)
+	for _, xid := range []uint32{100, 110, 120} {
+		setXactStatus(xact, xid, XactCommitted)
+	}
+	setXactStatus(xact, 115, XactAborted)
+	c := testCommitLog(map[string][]byte{"pg_xact/0000": xact})
+
+	tests := []struct {
+		name       string
+		xmin, xmax uint32
+		infomask   uint16
+		asOf       []uint32 // snapshots that see the tuple
+		hidden     []uint32 // snapshots that do not
+	}{
+		{"live row", 100, 0, HeapXmaxInvalid, []uint32{100, 130}, []uint32{99}},
+		{"deleted at 110", 100, 110, 0, []uint32{100, 109}, []uint32{99, 110, 130}},
+		{"aborted delete", 100, 115, 0, []uint32{100, 130}, nil},
+		{"old version of update at 120", 110, 120, HeapUpdated, []uint32{110, 119}, []uint32{100, 120}},
+		{"aborted insert", 115, 0, HeapXmaxInvalid, nil, []uint32{115, 130}},
+		{"frozen", 90, 0, HeapXminFrozen | HeapXmaxInvalid, []uint32{90, 130}, []uint32{3, 89}},
+		{"frozen before 9.4", FrozenXID, 0, HeapXmaxInvalid, []uint32{3, 130}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := ParseHeapTuple(makeHeapTuple(tt.xmin, tt.xmax, tt.infomask, 0, nil, nil)).Header
+			for _, xid := range tt.asOf {
+				if !c.VisibleAt(h, xid) {
+					t.Errorf("VisibleAt(%d) = false, want true", xid)
+				}
+			}
+			for _, xid := range tt.hidden {
+				if c.VisibleAt(h, xid) {
+					t.Errorf("VisibleAt(%d) = true, want false", xid)
+				}
+			}
+		})
+	}
+
+	// Without pg_xact only hinted transactions count as committed
+	h := ParseHeapTuple(makeHeapTuple(100, 110, HeapXminCommitted|HeapXmaxCommitted, 0, nil, nil)).Header
+	var hints *CommitLog
+	if !hints.VisibleAt(h, 105) || hints.VisibleAt(h, 110) {
+		t.Error("hint-bit VisibleAt does not honor xmax 110")
+	}
+
+	// Comparisons wrap around 2^32
+	if !xidPrecedesOrEquals(0xFFFFFFF0, 5) || xidPrecedesOrEquals(5, 0xFFFFFFF0) || !xidPrecedesOrEquals(FrozenXID, 5) {
+		t.Error("xidPrecedesOrEquals ignores wraparound")
+	}
+}